@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// worker - фоновая задача, которая работает до отмены своего контекста
+type worker struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startWorker - запускает run в отдельной горутине
+func startWorker(name string, run func(ctx context.Context)) *worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{name: name, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		run(ctx)
+	}()
+	return w
+}
+
+// stop - отменяет контекст и ждет, пока run вернется
+func (w *worker) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// newServer - http.Server с таймаутами из конфига, без них медленный клиент держит соединение вечно
+func newServer(cfg config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
+	}
+}
+
+// server - HTTP или gRPC сервер, который запускает и останавливает serve
+type server interface {
+	// listenAndServe - блокируется, пока сервер не остановят
+	listenAndServe() error
+	// shutdown - перестает принимать соединения и ждет запросы в работе, пока не отменен ctx.
+	//			  Кто не успел - обрывается
+	shutdown(ctx context.Context) error
+}
+
+// httpServer - с TLSConfig слушает HTTPS
+type httpServer struct {
+	*http.Server
+}
+
+func (s *httpServer) listenAndServe() error {
+	if s.TLSConfig != nil {
+		// Сертификат уже в TLSConfig
+		return s.ListenAndServeTLS("", "")
+	}
+	return s.ListenAndServe()
+}
+
+func (s *httpServer) shutdown(ctx context.Context) error {
+	err := s.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.Close()
+	}
+	return err
+}
+
+// grpcListener - gRPC сервер на адресе addr
+type grpcListener struct {
+	server *grpc.Server
+	addr   string
+}
+
+func (s *grpcListener) listenAndServe() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(listener)
+}
+
+func (s *grpcListener) shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// serve - обслуживает запросы до SIGINT/SIGTERM, затем останавливает все серверы,
+//		   дожидаясь запросов в работе не дольше shutdownTimeout.
+//		   Ошибка - один из серверов не смог запуститься или завершился сам,
+//		   остальные тогда тоже останавливаем
+func serve(servers []server, shutdownTimeout time.Duration, logger *logrus.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s server) {
+			errs <- s.listenAndServe()
+		}(s)
+	}
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+	case <-ctx.Done():
+	}
+	// Повторный сигнал завершает процесс сразу
+	stop()
+
+	logger.Info("the server is shutting down, waiting for requests in flight up to ", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.shutdown(shutdownCtx); err != nil {
+			logger.Print("server shutdown: ", err)
+			if serveErr == nil {
+				serveErr = err
+			}
+		}
+	}
+	return serveErr
+}
+
+// shutdown - останавливает фоновые задачи в обратном порядке запуска и закрывает БД последней:
+//			  задачи еще пишут в нее то, что накопили
+func shutdown(workers []*worker, storage db.Repository, logger *logrus.Logger) {
+	for i := len(workers) - 1; i >= 0; i-- {
+		workers[i].stop()
+		logger.Infof("the %s stopped", workers[i].name)
+	}
+	if err := storage.Close(); err != nil {
+		logger.Print("db close: ", err)
+		return
+	}
+	logger.Info("the db closed")
+}
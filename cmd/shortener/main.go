@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
 	"net/http"
+	"net/url"
+	"os"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/yury-nazarov/shorturl/internal/app/grpcserver"
 	"github.com/yury-nazarov/shorturl/internal/app/handler"
+	"github.com/yury-nazarov/shorturl/internal/app/middleware"
 	"github.com/yury-nazarov/shorturl/internal/app/service"
 	"github.com/yury-nazarov/shorturl/internal/config"
 	"github.com/yury-nazarov/shorturl/internal/logger"
@@ -19,16 +29,119 @@ func main() {
 	if err != nil {
 		logger.Fatal(err)
 	}
+	// Подкоманда управления миграциями: shortener migrate up|down|status
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err = runMigrate(cfg, args[1:]); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	// Сначала собираем все, что может не собраться из конфига: после открытия БД и запуска
+	// фоновых задач выходить по logger.Fatal нельзя, он не сбросит журнал и не закроет пул соединений
+	geoIP, err := service.NewGeoIP(cfg.GeoIPPath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// Инициируем проверку оригинальных URL, блоклист перечитываем при изменении файла
+	blocklist, err := service.NewBlocklist(cfg.URLBlocklistPath, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	validator, err := service.NewURLValidator(cfg, blocklist)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// Инициируем подпись cookie сессий
+	sessions, err := middleware.NewSessions(cfg, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// Инициируем проверку JWT от SSO шлюза, nil - JWT не настроены
+	verifier, err := middleware.NewJWTVerifier(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// Инициируем доступ к admin API, nil - ADMIN_TOKEN не задан
+	admin, err := middleware.NewAdminAuth(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// В режиме HTTPS сертификат общий для HTTP и gRPC серверов
+	var tlsConfig *tls.Config
+	if cfg.EnableHTTPS {
+		if tlsConfig, err = newTLSConfig(cfg, logger); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	// Инициируем лимиты запросов на клиента, корзины храним в памяти процесса
+	limiter := middleware.NewRateLimiter(cfg, nil, logger)
+
 	// Инициируем БД
 	db := db.New(cfg, logger)
 	// Создаем объект для доступа к методам компрессии URL
-	linkCompressor := service.NewLinkCompressor(cfg, logger)
+	linkCompressor, err := service.NewLinkCompressor(cfg, db, logger)
+	if err != nil {
+		shutdown(nil, db, logger)
+		logger.Fatal(err)
+	}
+	// Асинхронная запись переходов по коротким URL и удаление ссылок пользователями
+	tracker := service.NewClickTracker(db, geoIP, cfg.ClickBufferSize, cfg.ClickFlushInterval, logger)
+	deleter := service.NewURLDeleter(db, cfg, logger)
 	// Инициируем объект для доступа к хендлерам
-	controller := handler.NewController(db, linkCompressor, logger)
+	controller := handler.NewController(db, linkCompressor, tracker, validator, deleter, logger)
 	// Инициируем роутер
-	r := handler.NewRouter(controller, db, logger)
-	// Запускаем сервер
-	logger.Info("the server run on ", cfg.ServerAddress)
-	logger.Fatal(http.ListenAndServe(cfg.ServerAddress, r))
+	r := handler.NewRouter(controller, db, sessions, verifier, admin, limiter, logger)
+	servers, err := newServers(cfg, r, tlsConfig, logger)
+	if err != nil {
+		shutdown(nil, db, logger)
+		logger.Fatal(err)
+	}
+
+	// Фоновые задачи запускаем, когда все собрано, останавливаем после сервера в обратном порядке
+	var workers []*worker
+	// Удаление ссылок с истекшим сроком жизни
+	sweeper := service.NewExpiredSweeper(db, cfg.ExpiredSweepInterval, logger)
+	workers = append(workers, startWorker("expired sweeper", sweeper.Run))
+	workers = append(workers, startWorker("click tracker", tracker.Run))
+	workers = append(workers, startWorker("blocklist reloader", func(ctx context.Context) {
+		blocklist.Run(ctx, cfg.URLBlocklistReload)
+	}))
+	// Задания на удаление переживают рестарт
+	workers = append(workers, startWorker("url deleter", deleter.Run))
+	// gRPC API для внутренних сервисов, только если задан GRPC_ADDRESS
+	if len(cfg.GRPCAddress) != 0 {
+		grpcServer := grpcserver.NewServer(db, linkCompressor, validator, deleter, verifier, tlsConfig, logger)
+		servers = append(servers, &grpcListener{server: grpcServer, addr: cfg.GRPCAddress})
+		logger.Info("the grpc server run on ", cfg.GRPCAddress)
+	}
+	// Запускаем серверы, по SIGTERM дожидаемся запросов в работе и останавливаем все остальное
+	err = serve(servers, cfg.ShutdownTimeout, logger)
+	shutdown(workers, db, logger)
+	if err != nil {
+		logger.Print(err)
+		os.Exit(1)
+	}
 }
 
+// newServers - основной HTTP сервер, с tlsConfig - HTTPS с HSTS и, если задан HTTP_REDIRECT_ADDRESS,
+//				HTTP сервер с перенаправлением на HTTPS
+func newServers(cfg config.Config, handler http.Handler, tlsConfig *tls.Config, logger *logrus.Logger) ([]server, error) {
+	if tlsConfig == nil {
+		logger.Info("the server run on http://", cfg.ServerAddress)
+		return []server{&httpServer{newServer(cfg, cfg.ServerAddress, handler)}}, nil
+	}
+	primary := newServer(cfg, cfg.ServerAddress, middleware.HSTS(cfg.HSTSMaxAge)(handler))
+	primary.TLSConfig = tlsConfig
+	logger.Info("the server run on https://", cfg.ServerAddress)
+	servers := []server{&httpServer{primary}}
+
+	if len(cfg.HTTPRedirectAddress) != 0 {
+		baseURL, err := url.Parse(cfg.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("base url: %w", err)
+		}
+		servers = append(servers, &httpServer{newServer(cfg, cfg.HTTPRedirectAddress, middleware.RedirectToHTTPS(baseURL.Host))})
+		logger.Info("the redirect to https run on http://", cfg.HTTPRedirectAddress)
+	}
+	return servers, nil
+}
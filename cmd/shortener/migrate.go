@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/pg"
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// runMigrate - подкоманда управления схемой Postgres:
+//				shortener [-d DSN] migrate up|down|status
+func runMigrate(cfg config.Config, args []string) error {
+	if len(cfg.DatabaseDSN) == 0 {
+		return fmt.Errorf("migrate: database dsn is required, use -d or DATABASE_DSN")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: shortener migrate up|down|status")
+	}
+	ctx := context.Background()
+	db := pg.New(cfg.DatabaseDSN)
+
+	switch args[0] {
+	case "up":
+		return db.MigrateUp(ctx)
+	case "down":
+		return db.MigrateDown(ctx)
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range status {
+			appliedAt := "pending"
+			if m.AppliedAt != nil {
+				appliedAt = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("migrate: unknown command %s, use up|down|status", args[0])
+}
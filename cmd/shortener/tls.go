@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// selfSignedValidity - срок жизни самоподписанного сертификата, он выпускается на каждом старте
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// newTLSConfig - сертификат из TLS_CERT_FILE и TLS_KEY_FILE, без них - самоподписанный на хост из BaseURL
+func newTLSConfig(cfg config.Config, logger *logrus.Logger) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if len(cfg.TLSCertFile) != 0 {
+		cert, err = tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	} else {
+		baseURL, err := url.Parse(cfg.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("base url: %w", err)
+		}
+		cert, err = selfSignedCertificate(baseURL.Hostname(), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("tls: self-signed certificate: %w", err)
+		}
+		logger.Warn("the server uses a self-signed certificate for ", baseURL.Hostname(), ", browsers will not trust it")
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// selfSignedCertificate - сертификат на ключе P-256 для host, localhost и 127.0.0.1
+func selfSignedCertificate(host string, now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"shorturl"}, CommonName: host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if len(host) != 0 && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,68 @@
+package grpcserver
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+// errorDomain - domain в google.rpc.ErrorInfo
+const errorDomain = "shorturl"
+
+// statusCode - код gRPC для ошибки из БД или сервисного слоя, соответствует HTTP статусам из handler
+func statusCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, db.ErrNotFound), errors.Is(err, db.ErrDeleted), errors.Is(err, db.ErrExpired):
+		return codes.NotFound
+	case errors.Is(err, db.ErrBlocked), errors.Is(err, service.ErrRejectedURL):
+		return codes.FailedPrecondition
+	case errors.Is(err, db.ErrConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, db.ErrUnavailable), errors.Is(err, service.ErrShortCodeExhausted):
+		return codes.Unavailable
+	case errors.Is(err, service.ErrQueueFull):
+		return codes.ResourceExhausted
+	case errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrInvalidExpiration),
+		errors.Is(err, service.ErrInvalidURL), errors.Is(err, service.ErrEmptyBatch), errors.Is(err, service.ErrBatchTooLarge),
+		errors.Is(err, db.ErrTooLong):
+		return codes.InvalidArgument
+	case errors.Is(err, appMiddleware.ErrUnauthenticated):
+		return codes.Unauthenticated
+	case errors.Is(err, appMiddleware.ErrUserBlocked):
+		return codes.PermissionDenied
+	}
+	return codes.Internal
+}
+
+// statusError - логирует ошибку и переводит ее в статус gRPC.
+//				 Для ошибок проверки URL прикладываем ErrorInfo с кодом, как в теле HTTP ответа.
+//				 Текст внутренних ошибок клиенту не отдаем
+func statusError(logger *logrus.Logger, err error) error {
+	logger.Print(err)
+	code := statusCode(err)
+	if code == codes.Internal {
+		return status.Error(code, "internal error")
+	}
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return status.Error(code, err.Error())
+	}
+	st := status.New(code, validationErr.Reason)
+	info := &errdetails.ErrorInfo{Reason: validationErr.Code, Domain: errorDomain}
+	if len(validationErr.CorrelationID) != 0 {
+		info.Metadata = map[string]string{"correlation_id": validationErr.CorrelationID}
+	}
+	withDetails, detailsErr := st.WithDetails(info)
+	if detailsErr != nil {
+		logger.Print(detailsErr)
+		return st.Err()
+	}
+	return withDetails.Err()
+}
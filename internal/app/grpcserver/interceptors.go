@@ -0,0 +1,94 @@
+package grpcserver
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/yury-nazarov/shorturl/internal/app/grpcserver/pb"
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// methodAccess - что нужно методу: право API ключа, как у HTTP маршрута, и меняет ли он данные
+type methodAccess struct {
+	// public - метод работает без аутентификации
+	public bool
+	// scope - право API ключа, пустое - достаточно аутентификации
+	scope string
+	// readOnly - новый пользователь из JWT на таком запросе не сохраняется
+	readOnly bool
+}
+
+var methods = map[string]methodAccess{
+	pb.Shortener_Shorten_FullMethodName:      {scope: models.ScopeShorten},
+	pb.Shortener_ShortenBatch_FullMethodName: {scope: models.ScopeShorten},
+	pb.Shortener_Resolve_FullMethodName:      {readOnly: true},
+	pb.Shortener_ListUserURLs_FullMethodName: {scope: models.ScopeRead, readOnly: true},
+	pb.Shortener_DeleteURLs_FullMethodName:   {scope: models.ScopeDelete},
+	pb.Shortener_Ping_FullMethodName:         {public: true, readOnly: true},
+}
+
+// loggingInterceptor - как middleware.Logger: метод, код ответа, время и адрес клиента
+func loggingInterceptor(logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		var addr string
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+		logger.Infof("grpc %s from %s - %s in %s", info.FullMethod, addr, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// recoveryInterceptor - как middleware.Recoverer: паника в обработчике не роняет сервер, клиенту - Internal
+func recoveryInterceptor(logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				logger.Errorf("grpc %s panic: %v\n%s", info.FullMethod, rec, debug.Stack())
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// authInterceptor - как HTTPCookieAuth с заголовком Authorization и RequireScope:
+//					 метаданные "authorization: Bearer <API ключ или JWT>", cookie в gRPC нет.
+//					 Неизвестные методы не пропускаем
+func authInterceptor(repo db.Repository, verifier appMiddleware.TokenVerifier, logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		access, ok := methods[info.FullMethod]
+		if !ok {
+			return nil, status.Error(codes.Unimplemented, "unknown method")
+		}
+		if access.public {
+			return handler(ctx, req)
+		}
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) != 0 {
+				header = values[0]
+			}
+		}
+		ctx, err := appMiddleware.AuthenticateBearer(ctx, repo, verifier, header, access.readOnly)
+		if err != nil {
+			return nil, statusError(logger, err)
+		}
+		if key, ok := appMiddleware.APIKey(ctx); ok && len(access.scope) != 0 && !key.HasScope(access.scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "api key has no %q scope", access.scope)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,184 @@
+// Package grpcserver - gRPC API для внутренних сервисов, повторяет HTTP API из handler.
+//					   Описание API - api/shortener.proto
+package grpcserver
+
+//go:generate protoc --proto_path=../../../api --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative shortener.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yury-nazarov/shorturl/internal/app/grpcserver/pb"
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+// shortenerServer - реализация pb.ShortenerServer поверх тех же Repository и LinkCompressor, что и HTTP хендлеры
+type shortenerServer struct {
+	pb.UnimplementedShortenerServer
+	db        db.Repository
+	lc        service.LinkCompressor
+	validator *service.URLValidator
+	deleter   *service.URLDeleter
+	logger    *logrus.Logger
+}
+
+// NewServer - gRPC сервер с API и interceptors: логирование, восстановление после паники, аутентификация.
+//			   tlsConfig nil - без TLS
+func NewServer(db db.Repository, lc service.LinkCompressor, validator *service.URLValidator, deleter *service.URLDeleter, verifier appMiddleware.TokenVerifier, tlsConfig *tls.Config, logger *logrus.Logger) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			loggingInterceptor(logger),
+			recoveryInterceptor(logger),
+			authInterceptor(db, verifier, logger),
+		),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(server, &shortenerServer{db: db, lc: lc, validator: validator, deleter: deleter, logger: logger})
+	logger.Info("the grpc server success init")
+	return server
+}
+
+// Shorten - как AddJSONURLHandler
+func (s *shortenerServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	originURL, err := s.validator.Validate(req.GetUrl())
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	expiresAt, err := service.ExpiresAt(time.Now(), timestamp(req.GetExpiresAt()), req.GetTtlSeconds())
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+
+	record := models.Record{OriginURL: originURL, UserID: appMiddleware.User(ctx).ID, ExpiresAt: expiresAt, RawURL: service.RawURL(req.GetUrl(), originURL)}
+	var shortURL string
+	var originURLExists bool
+	if len(req.GetAlias()) != 0 {
+		shortURL, err = s.lc.ShortenAlias(ctx, record, req.GetAlias())
+	} else {
+		shortURL, originURLExists, err = s.lc.Shorten(ctx, record)
+	}
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	return &pb.ShortenResponse{ShortUrl: shortURL, AlreadyExists: originURLExists}, nil
+}
+
+// ShortenBatch - как AddJSONURLBatchHandler: сначала проверяем все элементы, потом сохраняем пачку одной транзакцией.
+//				  Ошибку первого не прошедшего проверку элемента отдаем с ErrorInfo
+func (s *shortenerServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	items := req.GetItems()
+	if err := s.lc.CheckBatchSize(len(items)); err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	now := time.Now()
+	records := make([]models.Record, len(items))
+	userID := appMiddleware.User(ctx).ID
+	for i, item := range items {
+		originURL, err := s.validator.Validate(item.GetOriginalUrl())
+		if err != nil {
+			var validationErr *service.ValidationError
+			if errors.As(err, &validationErr) {
+				validationErr.CorrelationID = item.GetCorrelationId()
+			}
+			return nil, statusError(s.logger, err)
+		}
+		expiresAt, err := service.ExpiresAt(now, timestamp(item.GetExpiresAt()), item.GetTtlSeconds())
+		if err != nil {
+			return nil, statusError(s.logger, fmt.Errorf("item %q: %w", item.GetCorrelationId(), err))
+		}
+		records[i] = models.Record{OriginURL: originURL, UserID: userID, ExpiresAt: expiresAt, RawURL: service.RawURL(item.GetOriginalUrl(), originURL)}
+	}
+
+	shortURLs, _, err := s.lc.ShortenBatch(ctx, records, nil)
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.ShortenBatchResponse_Item, 0, len(items))}
+	for i, shortURL := range shortURLs {
+		resp.Items = append(resp.Items, &pb.ShortenBatchResponse_Item{CorrelationId: items[i].GetCorrelationId(), ShortUrl: shortURL})
+	}
+	return resp, nil
+}
+
+// Resolve - как GetURLHandler, но без редиректа и учета перехода
+func (s *shortenerServer) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	if len(req.GetShortUrl()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "short_url is empty")
+	}
+	originURL, err := s.db.Get(ctx, s.shortURL(req.GetShortUrl()), appMiddleware.User(ctx).ID)
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	return &pb.ResolveResponse{OriginalUrl: originURL}, nil
+}
+
+// ListUserURLs - как GetUserURLs, пустой список вместо 204
+func (s *shortenerServer) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	records, err := s.db.GetUserURL(ctx, appMiddleware.User(ctx).ID)
+	if err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	resp := &pb.ListUserURLsResponse{Items: make([]*pb.ListUserURLsResponse_Item, 0, len(records))}
+	for _, record := range records {
+		item := &pb.ListUserURLsResponse_Item{ShortUrl: record.ShortURL, OriginalUrl: record.OriginURL}
+		if record.ExpiresAt != nil {
+			item.ExpiresAt = timestamppb.New(*record.ExpiresAt)
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return resp, nil
+}
+
+// DeleteURLs - как DeleteURLs в HTTP API: ставит задание в очередь, ссылки удалятся в фоне.
+//				Чужие и несуществующие ссылки пропускаем, очередь заполнена - ResourceExhausted
+func (s *shortenerServer) DeleteURLs(ctx context.Context, req *pb.DeleteURLsRequest) (*pb.DeleteURLsResponse, error) {
+	if len(req.GetIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ids are empty")
+	}
+	if err := s.deleter.Enqueue(ctx, appMiddleware.User(ctx).ID, req.GetIds()); err != nil {
+		return nil, statusError(s.logger, err)
+	}
+	return &pb.DeleteURLsResponse{}, nil
+}
+
+// Ping - как PingDB
+func (s *shortenerServer) Ping(context.Context, *pb.PingRequest) (*pb.PingResponse, error) {
+	if !s.db.Ping() {
+		return nil, status.Error(codes.Unavailable, "db is unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// shortURL - короткий URL по идентификатору, короткий URL целиком оставляем как есть
+func (s *shortenerServer) shortURL(short string) string {
+	if strings.Contains(short, "/") {
+		return short
+	}
+	return fmt.Sprintf("%s/%s", s.lc.ServiceName, short)
+}
+
+// timestamp - nil, если поле не задано
+func timestamp(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
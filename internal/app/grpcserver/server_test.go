@@ -0,0 +1,203 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/yury-nazarov/shorturl/internal/app/grpcserver/pb"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+// newTestClient - сервер на inmemory БД поверх bufconn и токены API ключей пользователя "user"
+func newTestClient(t *testing.T) (pb.ShortenerClient, map[string]string) {
+	ctx := context.Background()
+	logger := logger.New()
+	cfg := config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5,
+		DeleteQueueSize: 10, DeleteBatchSize: 100, DeleteFlushInterval: 10 * time.Millisecond}
+	db := inmemorydb.NewInMemoryDB()
+	validator, err := service.NewURLValidator(cfg, nil)
+	require.NoError(t, err)
+
+	// Ключ со всеми правами и ключ только на чтение
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("user", time.Now())))
+	tokens := map[string]string{}
+	for name, scopes := range map[string][]string{"all": models.Scopes, "read": {models.ScopeRead}} {
+		key, token, err := service.NewAPIKey("user", name, scopes, time.Now())
+		require.NoError(t, err)
+		require.NoError(t, db.CreateAPIKey(ctx, key))
+		tokens[name] = token
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	deleter := service.NewURLDeleter(db, cfg, logger)
+	go deleter.Run(ctx)
+
+	lc, err := service.NewLinkCompressor(cfg, db, logger)
+	require.NoError(t, err)
+	server := NewServer(db, lc, validator, deleter, nil, nil, logger)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn), tokens
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestShortenerServer(t *testing.T) {
+	client, tokens := newTestClient(t)
+	ctx := withToken(tokens["all"])
+
+	// Ping без аутентификации
+	_, err := client.Ping(context.Background(), &pb.PingRequest{})
+	require.NoError(t, err)
+
+	shorten, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru/"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(shorten.GetShortUrl(), "http://127.0.0.1:8080/"))
+	assert.False(t, shorten.GetAlreadyExists())
+	shortURL := shorten.GetShortUrl()
+	id := strings.TrimPrefix(shortURL, "http://127.0.0.1:8080/")
+	shorten, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru/"})
+	require.NoError(t, err)
+	assert.True(t, shorten.GetAlreadyExists())
+
+	batch, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.ShortenBatchRequest_Item{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/a"},
+		{CorrelationId: "2", OriginalUrl: "https://example.com/b", TtlSeconds: 3600},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetItems(), 2)
+	assert.Equal(t, "2", batch.GetItems()[1].GetCorrelationId())
+
+	// Идентификатор и короткий URL целиком
+	resolve, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: id})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", resolve.GetOriginalUrl())
+	resolve, err = client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: shortURL})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", resolve.GetOriginalUrl())
+
+	list, err := client.ListUserURLs(withToken(tokens["read"]), &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.GetItems(), 3)
+
+	// Ссылки удаляются в фоне
+	_, err = client.DeleteURLs(ctx, &pb.DeleteURLsRequest{Ids: []string{id, "unknown"}})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err = client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: id})
+		return status.Code(err) == codes.NotFound
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestShortenerServer_Errors(t *testing.T) {
+	client, tokens := newTestClient(t)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		call func(ctx context.Context) error
+		want codes.Code
+	}{
+		{
+			name: "without token",
+			ctx:  context.Background(),
+			call: func(ctx context.Context) error {
+				_, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "unknown api key",
+			ctx:  withToken("sk_unknown"),
+			call: func(ctx context.Context) error {
+				_, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "api key without scope",
+			ctx:  withToken(tokens["read"]),
+			call: func(ctx context.Context) error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com"})
+				return err
+			},
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "invalid url",
+			ctx:  withToken(tokens["all"]),
+			call: func(ctx context.Context) error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "/relative"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "not found",
+			ctx:  withToken(tokens["all"]),
+			call: func(ctx context.Context) error {
+				_, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: "nope"})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		{
+			name: "empty delete",
+			ctx:  withToken(tokens["all"]),
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteURLs(ctx, &pb.DeleteURLsRequest{})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, status.Code(tt.call(tt.ctx)))
+		})
+	}
+
+	// Элемент пачки, который не прошел проверку, - в ErrorInfo
+	_, err := client.ShortenBatch(withToken(tokens["all"]), &pb.ShortenBatchRequest{Items: []*pb.ShortenBatchRequest_Item{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/a"},
+		{CorrelationId: "2", OriginalUrl: "javascript:alert(1)"},
+	}})
+	st := status.Convert(err)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	assert.Equal(t, service.CodeSchemeNotAllowed, info.GetReason())
+	assert.Equal(t, "2", info.GetMetadata()["correlation_id"])
+}
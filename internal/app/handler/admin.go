@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// adminSearchLimit - сколько ссылок вернет поиск без параметра limit
+const adminSearchLimit = 100
+
+// AdminSearchURLs - ищет ссылки по origin, short (идентификатор или короткий URL целиком) и user.
+//					 Вернет и удаленные, и заблокированные ссылки вместе с владельцем
+func (c *Controller) AdminSearchURLs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.URLFilter{
+		OriginURL: query.Get("origin"),
+		UserID:    query.Get("user"),
+		Limit:     adminSearchLimit,
+	}
+	if short := query.Get("short"); len(short) != 0 {
+		filter.ShortURL = c.adminShortURL(short)
+	}
+	if limit := query.Get("limit"); len(limit) != 0 {
+		var err error
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := c.db.SearchURLs(r.Context(), filter)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	if len(records) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	answer, err := json.Marshal(records)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
+	}
+}
+
+// AdminBlockURL - блокирует ссылку {id}: переход по ней отвечает 451
+func (c *Controller) AdminBlockURL(w http.ResponseWriter, r *http.Request) {
+	shortURL := c.adminShortURL(chi.URLParam(r, "id"))
+	if err := c.db.SetURLBlocked(r.Context(), shortURL, true); err != nil {
+		c.writeError(w, err)
+		return
+	}
+	c.logger.Infof("admin: block %s", shortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminUnblockURL - снимает блокировку со ссылки {id}
+func (c *Controller) AdminUnblockURL(w http.ResponseWriter, r *http.Request) {
+	shortURL := c.adminShortURL(chi.URLParam(r, "id"))
+	if err := c.db.SetURLBlocked(r.Context(), shortURL, false); err != nil {
+		c.writeError(w, err)
+		return
+	}
+	c.logger.Infof("admin: unblock %s", shortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRestoreURL - восстанавливает удаленную пользователем ссылку {id}
+func (c *Controller) AdminRestoreURL(w http.ResponseWriter, r *http.Request) {
+	shortURL := c.adminShortURL(chi.URLParam(r, "id"))
+	if err := c.db.RestoreURL(r.Context(), shortURL); err != nil {
+		c.writeError(w, err)
+		return
+	}
+	c.logger.Infof("admin: restore %s", shortURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminTransferURL - передает ссылку {id} пользователю из {"user_id": "..."}
+//					  404 - нет ссылки или пользователя
+func (c *Controller) AdminTransferURL(w http.ResponseWriter, r *http.Request) {
+	bodyData, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	var request struct {
+		UserID string `json:"user_id"`
+	}
+	if err = json.Unmarshal(bodyData, &request); err != nil || len(request.UserID) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	shortURL := c.adminShortURL(chi.URLParam(r, "id"))
+	if err = c.db.TransferURL(r.Context(), shortURL, request.UserID); err != nil {
+		c.writeError(w, err)
+		return
+	}
+	c.logger.Infof("admin: transfer %s to %s", shortURL, request.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminShortURL - короткий URL по идентификатору, короткий URL целиком оставляем как есть
+func (c *Controller) adminShortURL(short string) string {
+	if strings.Contains(short, "/") {
+		return short
+	}
+	return fmt.Sprintf("%s/%s", c.lc.ServiceName, short)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+// CreateAPIKey - выпускает API ключ текущему пользователю.
+//				  Сам ключ есть только в этом ответе, в БД хранится его хэш
+func (c *Controller) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	bodyData, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	var request models.APIKeyRequest
+	if err = json.Unmarshal(bodyData, &request); err != nil {
+		c.logger.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, token, err := service.NewAPIKey(appMiddleware.User(r.Context()).ID, request.Name, request.Scopes, time.Now())
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	if err = c.db.CreateAPIKey(r.Context(), key); err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	response := key.Response()
+	response.Key = token
+	answer, err := json.Marshal(response)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
+	}
+}
+
+// GetAPIKeys - вернет все API ключи пользователя, включая отозванные
+func (c *Controller) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := c.db.GetUserAPIKeys(r.Context(), appMiddleware.User(r.Context()).ID)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, key.Response())
+	}
+	answer, err := json.Marshal(response)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
+	}
+}
+
+// RevokeAPIKey - отзывает API ключ пользователя по {id}
+//				  204 No Content - ключ отозван, 404 - у пользователя нет такого ключа
+func (c *Controller) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := c.db.RevokeAPIKey(r.Context(), appMiddleware.User(r.Context()).ID, chi.URLParam(r, "id"), time.Now().UTC())
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
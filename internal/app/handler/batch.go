@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+// IdempotencyKeyHeader - повтор запроса с тем же ключом и телом получает сохраненный ответ, ссылки не дублируются
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader - ответ взят из сохраненного по ключу идемпотентности
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// AddJSONURLBatchHandler - добавляет пачку URL пришедших в формате JSON.
+//							Пачка сохраняется целиком или не сохраняется совсем, в ответе статус каждого элемента:
+//							201 - все элементы created или exists (ссылка на URL уже была, см. DEDUP_SCOPE),
+//							400/422 - есть invalid элементы, остальные skipped.
+//							413 - элементов больше BATCH_MAX_SIZE
+func (c *Controller) AddJSONURLBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// Читаем присланые данные
+	bodyData, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	// Проверяем пустой Body
+	if len(bodyData) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Unmarshal JSON
+	var urls []models.URLBatch
+	if err = json.Unmarshal(bodyData, &urls); err != nil {
+		c.logger.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err = c.lc.CheckBatchSize(len(urls)); err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	// Повтор запроса с ключом идемпотентности получает сохраненный ответ
+	userID := appMiddleware.User(r.Context()).ID
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) != 0 {
+		if err = service.CheckIdempotencyKey(idempotencyKey); err != nil {
+			c.writeError(w, err)
+			return
+		}
+		if c.replayBatch(r.Context(), w, userID, idempotencyKey, bodyData) {
+			return
+		}
+	}
+
+	// Проверяем URL и срок жизни всех ссылок до того как что-то добавить в БД, клиенту важен статус каждой
+	now := time.Now()
+	results := make([]models.URLBatch, len(urls))
+	records := make([]models.Record, len(urls))
+	var firstErr error
+	for i, item := range urls {
+		results[i].CorrelationID = item.CorrelationID
+		originURL, err := c.validator.Validate(item.OriginalURL)
+		var expiresAt *time.Time
+		if err == nil {
+			expiresAt, err = service.ExpiresAt(now, item.ExpiresAt, item.TTL)
+		}
+		if err != nil {
+			results[i].Status = models.BatchStatusInvalid
+			results[i].Error = batchError(err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("item %q: %w", item.CorrelationID, err)
+			}
+			continue
+		}
+		records[i] = models.Record{OriginURL: originURL, UserID: userID, ExpiresAt: expiresAt, RawURL: service.RawURL(item.OriginalURL, originURL)}
+	}
+	if firstErr != nil {
+		for i := range results {
+			if len(results[i].Status) == 0 {
+				results[i].Status = models.BatchStatusSkipped
+			}
+		}
+		c.logger.Print(firstErr)
+		c.writeBatch(w, statusCode(firstErr), results)
+		return
+	}
+
+	// Сокращаем url и добавляем в БД одной транзакцией вместе с ответом для ключа идемпотентности
+	response := func(shortURLs []string, exists []bool) ([]byte, error) {
+		for i := range results {
+			results[i].ShortURL = shortURLs[i]
+			results[i].Status = batchStatus(exists[i])
+		}
+		return json.Marshal(results)
+	}
+	var idempotency *service.Idempotency
+	if len(idempotencyKey) != 0 {
+		idempotency = c.lc.NewIdempotency(userID, idempotencyKey, bodyData, now, response)
+	}
+	shortURLs, exists, err := c.lc.ShortenBatch(r.Context(), records, idempotency)
+	// Параллельный запрос с тем же ключом успел раньше: отдаем его ответ
+	if idempotency != nil && errors.Is(err, db.ErrConflict) &&
+		c.replayBatch(r.Context(), w, userID, idempotencyKey, bodyData) {
+		return
+	}
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	answer, err := response(shortURLs, exists)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
+	}
+}
+
+// replayBatch - отвечает сохраненным по ключу идемпотентности ответом.
+//				 false - ключа нет, запрос нужно выполнить
+func (c *Controller) replayBatch(ctx context.Context, w http.ResponseWriter, userID string, key string, body []byte) bool {
+	saved, err := c.db.GetIdempotencyKey(ctx, userID, key, time.Now())
+	if errors.Is(err, db.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		c.writeError(w, err)
+		return true
+	}
+	if saved.RequestHash != service.RequestHash(body) {
+		c.writeError(w, fmt.Errorf("%w: %s", service.ErrIdempotencyKeyReused, key))
+		return true
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(saved.Response); err != nil {
+		c.logger.Print(err)
+	}
+	return true
+}
+
+// writeBatch - отвечает статусами элементов пачки
+func (c *Controller) writeBatch(w http.ResponseWriter, status int, results []models.URLBatch) {
+	answer, err := json.Marshal(results)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
+	}
+}
+
+// batchStatus - статус сохраненного элемента пачки
+func batchStatus(exists bool) string {
+	if exists {
+		return models.BatchStatusExists
+	}
+	return models.BatchStatusCreated
+}
+
+// batchError - код и описание ошибки элемента пачки
+func batchError(err error) *models.URLBatchError {
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		return &models.URLBatchError{Code: validationErr.Code, Message: validationErr.Reason}
+	}
+	return &models.URLBatchError{Code: service.CodeInvalidExpiration, Message: err.Error()}
+}
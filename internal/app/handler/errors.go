@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+// statusCode - HTTP статус для ошибки из БД или сервисного слоя
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrDeleted), errors.Is(err, db.ErrExpired):
+		return http.StatusGone
+	case errors.Is(err, db.ErrBlocked):
+		return http.StatusUnavailableForLegalReasons
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrUnavailable), errors.Is(err, service.ErrQueueFull), errors.Is(err, service.ErrShortCodeExhausted):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrInvalidExpiration),
+		errors.Is(err, service.ErrInvalidScope), errors.Is(err, service.ErrInvalidURL), errors.Is(err, db.ErrTooLong),
+		errors.Is(err, service.ErrEmptyBatch), errors.Is(err, service.ErrInvalidIdempotencyKey):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrRejectedURL), errors.Is(err, service.ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// errorResponse - тело ответа для ошибок, которые клиент может исправить сам
+type errorResponse struct {
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// writeError - логирует ошибку и отвечает соответствующим ей HTTP статусом.
+//				Для ошибок проверки URL в теле объясняем, что не так.
+//				После вызова хендлер должен сразу вернуть управление.
+func (c *Controller) writeError(w http.ResponseWriter, err error) {
+	c.logger.Print(err)
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		w.WriteHeader(statusCode(err))
+		return
+	}
+	body, marshalErr := json.Marshal(errorResponse{
+		Code:          validationErr.Code,
+		Message:       validationErr.Reason,
+		CorrelationID: validationErr.CorrelationID,
+	})
+	if marshalErr != nil {
+		c.logger.Print(marshalErr)
+		w.WriteHeader(statusCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode(err))
+	if _, err = w.Write(body); err != nil {
+		c.logger.Print(err)
+	}
+}
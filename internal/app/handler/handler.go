@@ -2,15 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
 	"io"
 	"net/http"
-	"sync"
+	"strconv"
+	"time"
 
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"github.com/yury-nazarov/shorturl/internal/app/service"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,26 +22,34 @@ import (
 type Controller struct {
 	db db.Repository
 	lc service.LinkCompressor
+	tracker *service.ClickTracker
+	validator *service.URLValidator
+	deleter *service.URLDeleter
 	logger 	*logrus.Logger
 }
 
 // NewController - вернет объект для доступа к хендлерам
-func NewController(db db.Repository, lc service.LinkCompressor, logger *logrus.Logger) *Controller {
+func NewController(db db.Repository, lc service.LinkCompressor, tracker *service.ClickTracker, validator *service.URLValidator, deleter *service.URLDeleter, logger *logrus.Logger) *Controller {
 	c := &Controller{
 		db: db,
 		lc: lc,
+		tracker: tracker,
+		validator: validator,
+		deleter: deleter,
 		logger: logger,
 	}
 	logger.Info("the controller success init")
 	return c
 }
 
-// AddJSONURLHandler - принимает URL в формате JSON
+// AddJSONURLHandler - принимает URL в формате JSON.
+//					  409 - ссылка на этот URL уже есть (см. DEDUP_SCOPE), в ответе она, а не новая
 func (c *Controller) AddJSONURLHandler(w http.ResponseWriter, r *http.Request) {
 	// Читаем присланые данные
 	bodyData, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		c.writeError(w, err)
+		return
 	}
 
 	// Проверяем пустой Body
@@ -49,28 +61,41 @@ func (c *Controller) AddJSONURLHandler(w http.ResponseWriter, r *http.Request) {
 	// Unmarshal JSON
 	var url models.URL
 	if err = json.Unmarshal(bodyData, &url); err != nil {
+		c.logger.Print(err)
 		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-	// Проверяем если в БД уже есть оригинальный URL, нуже для верной установки заголовков ответа
-	originURLExists, err := c.db.OriginURLExists(r.Context(), url.Request)
-	if err != nil {
-		c.logger.Print("OriginURLExists: ", err)
+	// Проверяем оригинальный URL: дальше работаем только с его нормализованным видом
+	rawURL := url.Request
+	if url.Request, err = c.validator.Validate(url.Request); err != nil {
+		c.writeError(w, err)
+		return
 	}
-
 	// Сокращаем url и добавляем в БД
-	shortURL := c.lc.SortURL(url.Request)
-	token, err := r.Cookie("session_token")
+	expiresAt, err := service.ExpiresAt(time.Now(), url.ExpiresAt, url.TTL)
 	if err != nil {
-		c.logger.Print("AddURLHandler: err:",err)
+		c.writeError(w, err)
+		return
 	}
-	if err = c.db.Add(r.Context(), shortURL, url.Request, token.Value); err != nil {
-		c.logger.Print(err)
+	record := models.Record{OriginURL: url.Request, UserID: appMiddleware.User(r.Context()).ID, ExpiresAt: expiresAt, RawURL: service.RawURL(rawURL, url.Request)}
+	var shortURL string
+	var originURLExists bool
+	if len(url.Alias) != 0 {
+		// alias занятый другим пользователем - 409, в дедупликации alias не участвует
+		shortURL, err = c.lc.ShortenAlias(r.Context(), record, url.Alias)
+	} else {
+		shortURL, originURLExists, err = c.lc.Shorten(r.Context(), record)
+	}
+	if err != nil {
+		c.writeError(w, err)
+		return
 	}
 
 	// Сериализуем контент
 	jsonShortURL, err := json.Marshal(models.URL{Response: shortURL})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		c.writeError(w, err)
+		return
 	}
 
 	// Указываем заголовки в зависмости от типа контента
@@ -82,18 +107,19 @@ func (c *Controller) AddJSONURLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// HTTP Response
-	_, err = w.Write(jsonShortURL)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if _, err = w.Write(jsonShortURL); err != nil {
+		c.logger.Print(err)
 	}
 }
 
-// AddURLHandler - принимает URL в текстовом формате
+// AddURLHandler - принимает URL в текстовом формате.
+//				  409 - ссылка на этот URL уже есть (см. DEDUP_SCOPE), в ответе она, а не новая
 func (c *Controller) AddURLHandler(w http.ResponseWriter, r *http.Request) {
 	// Читаем присланые данные
 	bodyData, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		c.writeError(w, err)
+		return
 	}
 
 	// Проверяем пустой Body
@@ -102,24 +128,18 @@ func (c *Controller) AddURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Проверяем если в БД уже есть оригинальный URL, ниже для верной установки заголовков ответа
-	originURL := string(bodyData)
-	originURLExists, err := c.db.OriginURLExists(r.Context(), originURL)
+	// Проверяем оригинальный URL: дальше работаем только с его нормализованным видом
+	originURL, err := c.validator.Validate(string(bodyData))
 	if err != nil {
-		c.logger.Print("OriginURLExists: ", err)
+		c.writeError(w, err)
+		return
 	}
-	// Сокращаем url и добавляем в БД: сокращенный url, оригинальный url, token идентификатор пользователя
-	shortURL := c.lc.SortURL(originURL)
-
-	// Добавляем в БД только если URL нет в БД
-	if !originURLExists {
-		token, err := r.Cookie("session_token")
-		if err != nil {
-			c.logger.Print("AddURLHandler: err:",err)
-		}
-		if err = c.db.Add(r.Context(), shortURL, originURL, token.Value); err != nil {
-			c.logger.Print(err)
-		}
+	// Сокращаем url и добавляем в БД, если ссылки на него еще нет: сокращенный url, оригинальный url, ID пользователя
+	record := models.Record{OriginURL: originURL, UserID: appMiddleware.User(r.Context()).ID, RawURL: service.RawURL(string(bodyData), originURL)}
+	shortURL, originURLExists, err := c.lc.Shorten(r.Context(), record)
+	if err != nil {
+		c.writeError(w, err)
+		return
 	}
 
 	// HTTP Response
@@ -129,64 +149,71 @@ func (c *Controller) AddURLHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.WriteHeader(http.StatusCreated)
 	}
-	_, err = w.Write([]byte(shortURL))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if _, err = w.Write([]byte(shortURL)); err != nil {
+		c.logger.Print(err)
 	}
 }
 
 // GetURLHandler по сокращенному  URL
 //				вернет оригинальный URL
 //				установит заголоко Location: originURL + HTTP 307
+//				HTTP 404 если URL нет, HTTP 410 если URL удален или истек срок его жизни
 func (c *Controller) GetURLHandler(w http.ResponseWriter, r *http.Request) {
 	// Получаем идентификатор пользователя
-	// 		Пустая строка userToken нужна для обратной совместимости с inMemory и fileDB
-	// 		т.к если куки не найдены, r.Cookie() не вернет объект токен со строковым свойством token.Value
-	var userToken string
-	token, err := r.Cookie("session_token")
-	// Если токен существует нужно объявить это в userToken
-	if err == nil {
-		userToken = token.Value
-	}
+	userID := appMiddleware.User(r.Context()).ID
 
 	// Получаем оригинальный URL из БД
 	shortURL := fmt.Sprintf("%s%s", c.lc.ServiceName, r.URL.Path)
-	originURL, err := c.db.Get(r.Context(), shortURL, userToken)
+	originURL, err := c.db.Get(r.Context(), shortURL, userID)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-	}
-	c.logger.Printf("DEBUG: User: %s get URL: %s -> %s\n", token,  shortURL, originURL)
-
-	// HTTP 410 если url помечен как удаленный
-	if len(originURL) == 0 {
-		w.WriteHeader(http.StatusGone)
+		c.writeError(w, err)
 		return
 	}
+	c.logger.Printf("DEBUG: User: %s get URL: %s -> %s\n", userID, shortURL, originURL)
+
+	// Учитываем переход асинхронно, не задерживая редирект
+	c.tracker.Track(r, shortURL)
+
 	// HTTP 307 Если url есть в БД
 	w.Header().Set("Location", originURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
-// GetUserURLs - вернет список всех пользовательских URL
-func (c *Controller) GetUserURLs(w http.ResponseWriter, r *http.Request) {
-	// Получаем токен из кук
-	token, err := r.Cookie("session_token")
-	if err != nil {
-		c.logger.Println("DEBUG 1")
-		w.WriteHeader(http.StatusNoContent)
+// GetURLStats - вернет статистику переходов по короткому URL пользователя
+//				 {id} - идентификатор короткого URL (сокращенная часть url)
+func (c *Controller) GetURLStats(w http.ResponseWriter, r *http.Request) {
+	// Статистику отдаем только владельцу URL
+	shortURL := fmt.Sprintf("%s/%s", c.lc.ServiceName, chi.URLParam(r, "id"))
+	if _, err := c.db.GetUserRecord(r.Context(), shortURL, appMiddleware.User(r.Context()).ID); err != nil {
+		c.writeError(w, err)
 		return
 	}
 
-	// Достаем из БД все записи по токену
-	userURL, err := c.db.GetUserURL(r.Context(), token.Value)
+	stats, err := c.db.GetClickStats(r.Context(), shortURL)
 	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	answer, err := json.Marshal(stats)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(answer); err != nil {
 		c.logger.Print(err)
 	}
+}
 
-	answer, err := json.Marshal(userURL)
+// GetUserURLs - вернет список всех пользовательских URL
+func (c *Controller) GetUserURLs(w http.ResponseWriter, r *http.Request) {
+	// Достаем из БД все записи пользователя
+	userURL, err := c.db.GetUserURL(r.Context(), appMiddleware.User(r.Context()).ID)
 	if err != nil {
-		c.logger.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.writeError(w, err)
+		return
 	}
 
 	if len(userURL) == 0 {
@@ -195,25 +222,32 @@ func (c *Controller) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	answer, err := json.Marshal(userURL)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+
 	// Указываем заголовки в зависмости от типа отдаваемого контента
 	w.Header().Add("Content-Type", "application/json")
 	// HTTP Response
 	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(answer)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if _, err = w.Write(answer); err != nil {
+		c.logger.Print(err)
 	}
 }
 
 // DeleteURLs помечает удаленными URL по идентификатору (сокращенная часть url)
-//			  202 Accepted - успешное выполнение запроса пользователем его создавшем
+//			  202 Accepted - задание на удаление сохранено, ссылки удалятся в фоне
+//			  503 Service Unavailable с Retry-After - очередь удаления заполнена
 func (c *Controller) DeleteURLs(w http.ResponseWriter, r *http.Request) {
 	// Читаем из body [ "a", "b", "c", "d", ...] сериализовать в JSON
 	bodyData, err := io.ReadAll(r.Body)
-	c.logger.Println("bodyData:", string(bodyData))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		c.writeError(w, err)
+		return
 	}
+	c.logger.Println("bodyData:", string(bodyData))
 
 	if len(bodyData) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -223,40 +257,20 @@ func (c *Controller) DeleteURLs(w http.ResponseWriter, r *http.Request) {
 	// Конвертируем в JSON данные из body
 	var urlIdentityList []string
 	if err = json.Unmarshal(bodyData, &urlIdentityList); err != nil {
+		c.logger.Print(err)
 		w.WriteHeader(http.StatusBadRequest)
-	}
-
-	// Получаем токен пользователя пользователя, если токена нет - удалять нечего
-	token, err := r.Cookie("session_token")
-	if err != nil {
-		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	// Получаем id записей которые нужно пометить удаленными
-	urlsID := make(chan int, len(urlIdentityList))
-
-
-	var wg sync.WaitGroup
-	for _, identity := range urlIdentityList {
-		wg.Add(1)
-		go func(identity string) {
-			id := c.db.GetShortURLByIdentityPath(r.Context(), identity, token.Value)
-			fmt.Printf("DEBUG: Prepare mark deleted URL identity: %s with ID: %d\n", identity, id)
-			urlsID <- id
-			wg.Done()
-		}(identity)
-	}
-	// Закрываем канал когда он заполнился
-	wg.Wait()
-	close(urlsID)
-
-	// Помечаем удаленными пачку записей
-	if err = c.db.URLBulkDelete(r.Context(), urlsID); err != nil {
-		c.logger.Printf("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// Удалять можно только свои URL
+	userID := appMiddleware.User(r.Context()).ID
+	if err = c.deleter.Enqueue(r.Context(), userID, urlIdentityList); err != nil {
+		if errors.Is(err, service.ErrQueueFull) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(c.deleter.RetryAfter().Seconds())))
+		}
+		c.writeError(w, err)
+		return
 	}
-	c.logger.Println("DEBUG: Stop URLBulkDelete:")
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -274,59 +288,3 @@ func (c *Controller) PingDB(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
-
-// AddJSONURLBatchHandler - добавляет пачку URL пришедших в формате JSON
-func (c *Controller) AddJSONURLBatchHandler(w http.ResponseWriter, r *http.Request) {
-	// Читаем присланые данные
-	bodyData, err := io.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	// Проверяем пустой Body
-	if len(bodyData) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Unmarshal JSON
-	var urls []models.URLBatch
-	if err = json.Unmarshal(bodyData, &urls); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-	}
-
-	// Сокращаем url и добавляем в БД, подготавливаем ответ
-	var response []models.URLBatch
-	for _, item := range urls {
-		shortURL := c.lc.SortURL(item.OriginalURL)
-		token, err := r.Cookie("session_token")
-		if err != nil {
-			c.logger.Print("AddURLHandler: err:",err)
-		}
-		if err = c.db.Add(r.Context(), shortURL, item.OriginalURL, token.Value); err != nil {
-			c.logger.Print(err)
-		}
-
-		// Сразу подготавливаем слайс для ответа пользователю
-		response = append(response, models.URLBatch{
-			CorrelationID: item.CorrelationID,
-			ShortURL: shortURL,
-		})
-	}
-
-	// Сериализуем ответ
-	jsonShortURL, err := json.Marshal(response)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	// Указываем заголовки в зависмости от типа контента
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
-	// HTTP Response
-	_, err = w.Write(jsonShortURL)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-}
\ No newline at end of file
@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
 	"github.com/yury-nazarov/shorturl/internal/logger"
@@ -15,14 +19,20 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"github.com/yury-nazarov/shorturl/internal/app/service"
 	"github.com/yury-nazarov/shorturl/internal/config"
 )
 
+// testAdminToken - токен admin API тестового сервера
+const testAdminToken = "admin-token-admin-token-admin-token"
+
 // NewTestServer - конфигурируем тестовый сервер,
 func NewTestServer(dbName string, PGConnStr string) *httptest.Server {
 	// Инициируем логгер
@@ -35,14 +45,51 @@ func NewTestServer(dbName string, PGConnStr string) *httptest.Server {
 	cfg.FileStoragePath = dbName
 	cfg.DatabaseDSN = PGConnStr
 	cfg.URLLength = 5
-
-	linkCompressor := service.NewLinkCompressor(cfg, logger)
+	cfg.CookieMaxAge = time.Hour
+	cfg.AdminToken = testAdminToken
+	cfg.DeleteQueueSize = 100
+	cfg.DeleteBatchSize = 100
+	cfg.DeleteFlushInterval = 10 * time.Millisecond
+	cfg.BatchMaxSize = 3
+	cfg.IdempotencyKeyTTL = time.Hour
+	// Тест кейсы шлют запросы без cookie, то есть каждый раз от нового пользователя
+	cfg.DedupScope = service.DedupScopeGlobal
+	cfg.URLSortQuery = true
+	cfg.URLStripParams = []string{"utm_*", "fbclid", "gclid"}
 
 	// Инициируем БД
 	db := db.New(cfg, logger)
-	controller := NewController(db, linkCompressor, logger)
 
-	r := NewRouter(controller, db, logger)
+	linkCompressor, err := service.NewLinkCompressor(cfg, db, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Переходы пишем в БД почти сразу, чтобы тесты статистики не ждали
+	tracker := service.NewClickTracker(db, &service.GeoIP{}, 100, 10*time.Millisecond, logger)
+	go tracker.Run(context.Background())
+	blocklist, err := service.NewBlocklist(cfg.URLBlocklistPath, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	validator, err := service.NewURLValidator(cfg, blocklist)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Удаление тоже почти сразу
+	deleter := service.NewURLDeleter(db, cfg, logger)
+	go deleter.Run(context.Background())
+	controller := NewController(db, linkCompressor, tracker, validator, deleter, logger)
+
+	sessions, err := appMiddleware.NewSessions(cfg, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	admin, err := appMiddleware.NewAdminAuth(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Лимиты в cfg не заданы: тесты не упираются в 429
+	r := NewRouter(controller, db, sessions, nil, admin, appMiddleware.NewRateLimiter(cfg, nil, logger), logger)
 
 	// Настраиваем адрес/порт который будут слушать тестовый сервер
 	listener, err := net.Listen("tcp", cfg.ServerAddress)
@@ -149,7 +196,7 @@ func TestController_AddJSONURLHandler(t *testing.T) {
 			},
 			want: want{
 				statusCode: http.StatusCreated,
-				body:       `{"result":"http://127.0.0.1:8080/KJYUS"}`,
+				body:       `{"result":"http://127.0.0.1:8080/xvTrr"}`,
 				headers:    map[string]string{"Content-Type": "application/json"},
 			},
 		},
@@ -166,7 +213,7 @@ func TestController_AddJSONURLHandler(t *testing.T) {
 			},
 		},
 		{
-			name: "test_3: POST: Server incoming request in the gzip format, return the text format.",
+			name: "test_3: POST: Server incoming request in the gzip format, URL already shortened.",
 			request: request{
 				httpMethod: http.MethodPost,
 				url:        "http://127.0.0.1:8080/api/shorten",
@@ -174,8 +221,8 @@ func TestController_AddJSONURLHandler(t *testing.T) {
 				headers:    map[string]string{"Content-Encoding": "gzip"},
 			},
 			want: want{
-				statusCode: http.StatusCreated,
-				body:       `{"result":"http://127.0.0.1:8080/KJYUS"}`,
+				statusCode: http.StatusConflict,
+				body:       `{"result":"http://127.0.0.1:8080/xvTrr"}`,
 				headers:    map[string]string{"Content-Type": "application/json"},
 			},
 		},
@@ -189,11 +236,73 @@ func TestController_AddJSONURLHandler(t *testing.T) {
 			},
 			want: want{
 				statusCode: http.StatusCreated,
-				body:       `{"result":"http://127.0.0.1:8080/KJYUS"}`,
+				body:       `{"result":"http://127.0.0.1:8080/xvTrr"}`,
 				// TODO: Не совсем понятно, нужно ли при этом еще ставить заголовки указывающие что внутри JSON
 				headers: map[string]string{"Content-Encoding": "gzip"},
 			},
 		},
+		{
+			name: "test_5: POST: Custom alias",
+			request: request{
+				httpMethod: http.MethodPost,
+				url:        "http://127.0.0.1:8080/api/shorten",
+				body:       `{"url":"https://example.com/promo","alias":"promo2026"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+			want: want{
+				statusCode: http.StatusCreated,
+				body:       `{"result":"http://127.0.0.1:8080/promo2026"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+		},
+		{
+			name: "test_6: POST: Custom alias taken by another user",
+			request: request{
+				httpMethod: http.MethodPost,
+				url:        "http://127.0.0.1:8080/api/shorten",
+				body:       `{"url":"https://example.com/promo","alias":"promo2026"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+			want: want{
+				statusCode: http.StatusConflict,
+			},
+		},
+		{
+			name: "test_7: POST: Reserved alias",
+			request: request{
+				httpMethod: http.MethodPost,
+				url:        "http://127.0.0.1:8080/api/shorten",
+				body:       `{"url":"https://example.com/promo","alias":"api"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "test_8: POST: Alias with invalid characters",
+			request: request{
+				httpMethod: http.MethodPost,
+				url:        "http://127.0.0.1:8080/api/shorten",
+				body:       `{"url":"https://example.com/promo","alias":"promo/2026"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "test_9: POST: Expiration date in the past",
+			request: request{
+				httpMethod: http.MethodPost,
+				url:        "http://127.0.0.1:8080/api/shorten",
+				body:       `{"url":"https://example.com/sale","expires_at":"2020-01-01T00:00:00Z"}`,
+				headers:    map[string]string{"Content-Type": "application/json"},
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
 	}
 
 	// Запускаем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
@@ -230,8 +339,10 @@ func TestController_AddJSONURLHandler(t *testing.T) {
 			})
 		}
 		ts.Close()
+		// Удаляем файлы БД, чтобы тесты не зависели от предыдущих запусков
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
 	}
-	defer os.Remove("test_db.txt")
 }
 
 func TestController_AddUrlHandler(t *testing.T) {
@@ -263,7 +374,7 @@ func TestController_AddUrlHandler(t *testing.T) {
 			},
 			want: want{
 				statusCode: http.StatusCreated,
-				body:       "http://127.0.0.1:8080/KJYUS",
+				body:       "http://127.0.0.1:8080/xvTrr",
 				headers:    map[string]string{"Content-Type": "text/plain"},
 			},
 		},
@@ -279,7 +390,7 @@ func TestController_AddUrlHandler(t *testing.T) {
 			},
 		},
 		{
-			name: "test_3: POST: Server incoming request in the gzip format, return the text format.",
+			name: "test_3: POST: Server incoming request in the gzip format, URL already shortened.",
 			request: request{
 				httpMethod: http.MethodPost,
 				url:        "http://127.0.0.1:8080",
@@ -287,8 +398,8 @@ func TestController_AddUrlHandler(t *testing.T) {
 				headers:    map[string]string{"Content-Encoding": "gzip"},
 			},
 			want: want{
-				statusCode: http.StatusCreated,
-				body:       "http://127.0.0.1:8080/KJYUS",
+				statusCode: http.StatusConflict,
+				body:       "http://127.0.0.1:8080/xvTrr",
 				headers:    map[string]string{"Content-Type": "text/plain"},
 			},
 		},
@@ -302,7 +413,7 @@ func TestController_AddUrlHandler(t *testing.T) {
 			},
 			want: want{
 				statusCode: http.StatusCreated,
-				body:       "http://127.0.0.1:8080/KJYUS",
+				body:       "http://127.0.0.1:8080/xvTrr",
 				headers:    map[string]string{"Content-Encoding": "gzip"},
 			},
 		},
@@ -342,8 +453,10 @@ func TestController_AddUrlHandler(t *testing.T) {
 			})
 		}
 		ts.Close()
+		// Удаляем файлы БД, чтобы тесты не зависели от предыдущих запусков
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
 	}
-	defer os.Remove("test_db.txt")
 }
 
 func TestController_GetUrlHandler(t *testing.T) {
@@ -376,14 +489,14 @@ func TestController_GetUrlHandler(t *testing.T) {
 			want: want{
 				headers:    map[string]string{"Content-Type": "text/plain"},
 				statusCode: 201,
-				body:       "http://127.0.0.1:8080/KJYUS",
+				body:       "http://127.0.0.1:8080/xvTrr",
 			},
 		},
 		{
 			name: "test_1: GET: Success short url from test #1",
 			request: request{
 				httpMethod: http.MethodGet,
-				url:        "http://127.0.0.1:8080/KJYUS",
+				url:        "http://127.0.0.1:8080/xvTrr",
 			},
 			want: want{
 				headers:    map[string]string{"Location": "https://www.youtube.com/watch?v=09nmlZjxRFs"},
@@ -420,8 +533,10 @@ func TestController_GetUrlHandler(t *testing.T) {
 			})
 		}
 		ts.Close()
+		// Удаляем файлы БД, чтобы тесты не зависели от предыдущих запусков
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
 	}
-	defer os.Remove("test_db.txt")
 }
 
 func TestController_DefaultHandler(t *testing.T) {
@@ -480,6 +595,523 @@ func TestController_DefaultHandler(t *testing.T) {
 			})
 		}
 		ts.Close()
+		// Удаляем файлы БД, чтобы тесты не зависели от предыдущих запусков
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_GetURLStats(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("stats: DB: %s", dbName), func(t *testing.T) {
+			// Создаем короткий URL и запоминаем куку владельца
+			resp, shortURL := testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://example.com/stats", map[string]string{})
+			defer resp.Body.Close() // go vet test
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.NotEmpty(t, resp.Cookies())
+			ownerCookie := map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value}
+			statsURL := fmt.Sprintf("http://127.0.0.1:8080/api/user/urls/%s/stats", strings.TrimPrefix(shortURL, "http://127.0.0.1:8080/"))
+
+			// Два перехода с одного клиента и один с другого
+			for _, userAgent := range []string{"agent_1", "agent_1", "agent_2"} {
+				resp, _ := testRequest(t, http.MethodGet, shortURL, "", map[string]string{"User-Agent": userAgent})
+				resp.Body.Close()
+				require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+			}
+
+			// Переходы пишутся асинхронно
+			today := time.Now().UTC().Format("2006-01-02")
+			assert.Eventually(t, func() bool {
+				resp, body := testRequest(t, http.MethodGet, statsURL, "", ownerCookie)
+				defer resp.Body.Close()
+				want := fmt.Sprintf(`{"short_url":"%s","clicks":3,"unique_visitors":2,"daily":[{"date":"%s","clicks":3,"unique_visitors":2}]}`, shortURL, today)
+				return resp.StatusCode == http.StatusOK && body == want
+			}, time.Second, 20*time.Millisecond)
+
+			// Чужому пользователю статистика недоступна
+			resp, _ = testRequest(t, http.MethodGet, statsURL, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_URLValidation(t *testing.T) {
+	ts := NewTestServer("inMemoryDB", "")
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+		// wantBody - структурированная ошибка в теле ответа
+		wantBody string
+	}{
+		{name: "empty json field", path: "/api/shorten", body: `{}`, want: http.StatusBadRequest, wantBody: `{"code":"empty_url","message":"url is empty"}`},
+		{name: "relative path", path: "/", body: "/admin", want: http.StatusBadRequest, wantBody: `"code":"relative_url"`},
+		{name: "javascript url", path: "/api/shorten", body: `{"url":"javascript:alert(1)"}`, want: http.StatusUnprocessableEntity, wantBody: `"code":"scheme_not_allowed"`},
+		{name: "redirect loop", path: "/", body: "http://127.0.0.1:8080/xvTrr", want: http.StatusUnprocessableEntity, wantBody: `"code":"self_reference"`},
+		{
+			name:     "invalid batch item",
+			path:     "/api/shorten/batch",
+			body:     `[{"correlation_id":"1","original_url":"https://example.com"},{"correlation_id":"2","original_url":"ftp://example.com"}]`,
+			want:     http.StatusUnprocessableEntity,
+			wantBody: `"correlation_id":"2"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, http.MethodPost, "http://127.0.0.1:8080"+tt.path, tt.body, map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.Contains(t, body, tt.wantBody)
+		})
+	}
+
+	// Пачка с ошибкой не сохраняет и корректные элементы
+	resp, _ := testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", map[string]string{})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestController_Batch(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("batch: DB: %s", dbName), func(t *testing.T) {
+			batchURL := "http://127.0.0.1:8080/api/shorten/batch"
+			batch := `[{"correlation_id":"1","original_url":"https://example.com/batch/1"},{"correlation_id":"2","original_url":"https://example.com/batch/2"}]`
+			resp, body := testRequest(t, http.MethodPost, batchURL, batch, map[string]string{IdempotencyKeyHeader: "retry-1"})
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.NotEmpty(t, resp.Cookies())
+			cookie := map[string]string{
+				"Cookie":             appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value,
+				IdempotencyKeyHeader: "retry-1",
+			}
+			var created []models.URLBatch
+			require.NoError(t, json.Unmarshal([]byte(body), &created))
+			require.Len(t, created, 2)
+			assert.Equal(t, "2", created[1].CorrelationID)
+			assert.Equal(t, models.BatchStatusCreated, created[1].Status)
+
+			// Повтор с тем же ключом получает тот же ответ и не создает ссылки заново
+			resp, replayed := testRequest(t, http.MethodPost, batchURL, batch, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusCreated, resp.StatusCode)
+			assert.Equal(t, "true", resp.Header.Get(idempotentReplayedHeader))
+			assert.Equal(t, body, replayed)
+			resp, body = testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", cookie)
+			defer resp.Body.Close()
+			var records []models.Record
+			require.NoError(t, json.Unmarshal([]byte(body), &records))
+			assert.Len(t, records, 2)
+
+			// Тот же ключ с другим телом - ошибка клиента
+			resp, _ = testRequest(t, http.MethodPost, batchURL, `[{"correlation_id":"1","original_url":"https://example.com/other"}]`, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+			// Статус каждого элемента, пачка с ошибкой не сохраняется
+			resp, body = testRequest(t, http.MethodPost, batchURL,
+				`[{"correlation_id":"1","original_url":"https://example.com/batch/3"},{"correlation_id":"2","original_url":"https://example.com","ttl":-1}]`,
+				map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, `[{"correlation_id":"1","status":"skipped"},{"correlation_id":"2","status":"invalid","error":{"code":"invalid_expiration","message":"invalid expiration: ttl must be positive"}}]`, body)
+
+			// Пустая пачка и пачка больше BATCH_MAX_SIZE
+			resp, _ = testRequest(t, http.MethodPost, batchURL, `[]`, map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodPost, batchURL, `[{},{},{},{}]`, map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_Dedup(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("dedup: DB: %s", dbName), func(t *testing.T) {
+			resp, body := testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/dedup"}`, map[string]string{})
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			require.NotEmpty(t, resp.Cookies())
+			cookie := map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value}
+			var created models.URL
+			require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+			// Повтор в JSON и текстом отвечает 409 с сохраненной ссылкой и не добавляет новую
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/dedup"}`, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+			assert.Equal(t, `{"result":"`+created.Response+`"}`, body)
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://example.com/dedup", cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+			assert.Equal(t, created.Response, body)
+
+			resp, body = testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", cookie)
+			defer resp.Body.Close()
+			var records []models.Record
+			require.NoError(t, json.Unmarshal([]byte(body), &records))
+			assert.Len(t, records, 1)
+			resp, _ = testRequest(t, http.MethodGet, created.Response, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			// Разные записи одного адреса сводятся нормализацией к одной ссылке
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://Example.com/a?b=1&a=2", cookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			normalized := body
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com:443/a?a=2&b=1&utm_source=mail"}`, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+			assert.Equal(t, `{"result":"`+normalized+`"}`, body)
+			resp, _ = testRequest(t, http.MethodGet, normalized, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, "https://example.com/a?a=2&b=1", resp.Header.Get("Location"))
+
+			// Алиас в дедупликации не участвует
+			resp, _ = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/dedup","alias":"dedup-alias"}`, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+			// Пачка участвует в дедупликации: уже сокращенный URL - exists с сохраненной ссылкой
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten/batch",
+				`[{"correlation_id":"1","original_url":"https://example.com/dedup"},{"correlation_id":"2","original_url":"https://example.com/dedup/batch"}]`, cookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			var batch []models.URLBatch
+			require.NoError(t, json.Unmarshal([]byte(body), &batch))
+			require.Len(t, batch, 2)
+			assert.Equal(t, models.URLBatch{CorrelationID: "1", ShortURL: created.Response, Status: models.BatchStatusExists}, batch[0])
+			assert.Equal(t, models.BatchStatusCreated, batch[1].Status)
+			resp, body = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/dedup/batch"}`, cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+			assert.Equal(t, `{"result":"`+batch[1].ShortURL+`"}`, body)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_Stream(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("stream: DB: %s", dbName), func(t *testing.T) {
+			streamURL := "http://127.0.0.1:8080/api/shorten/stream"
+			exportURL := "http://127.0.0.1:8080/api/user/urls/export"
+
+			// Пачки по BATCH_MAX_SIZE=3 строки: ответ на первую пачку приходит, пока тело запроса еще пишется
+			pr, pw := io.Pipe()
+			req, err := http.NewRequest(http.MethodPost, streamURL, pr)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", ndjsonContentType)
+			go func() {
+				_, _ = pw.Write([]byte("{\"correlation_id\":\"a\",\"original_url\":\"https://example.com/stream/1\"}\nnot json\n\n{\"original_url\":\"ftp://example.com\"}\n"))
+			}()
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, ndjsonContentType, resp.Header.Get("Content-Type"))
+			require.NotEmpty(t, resp.Cookies())
+			cookie := map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value}
+
+			lines := bufio.NewScanner(resp.Body)
+			var results []models.URLBatch
+			readResults := func(n int) {
+				for i := 0; i < n && lines.Scan(); i++ {
+					var result models.URLBatch
+					require.NoError(t, json.Unmarshal(lines.Bytes(), &result))
+					results = append(results, result)
+				}
+			}
+			readResults(3)
+			require.Len(t, results, 3)
+			assert.Equal(t, "a", results[0].CorrelationID)
+			assert.Equal(t, models.BatchStatusCreated, results[0].Status)
+			assert.NotEmpty(t, results[0].ShortURL)
+			assert.Equal(t, models.URLBatch{CorrelationID: "2", Status: models.BatchStatusInvalid,
+				Error: &models.URLBatchError{Code: codeMalformedLine, Message: "invalid character 'o' in literal null (expecting 'u')"}}, results[1])
+			assert.Equal(t, "4", results[2].CorrelationID)
+			assert.Equal(t, models.BatchStatusInvalid, results[2].Status)
+
+			_, err = pw.Write([]byte("{\"correlation_id\":\"b\",\"original_url\":\"https://example.com/stream/2\",\"ttl\":3600}\n"))
+			require.NoError(t, err)
+			require.NoError(t, pw.Close())
+			readResults(10)
+			require.Len(t, results, 4)
+			assert.Equal(t, "b", results[3].CorrelationID)
+			assert.Equal(t, models.BatchStatusCreated, results[3].Status)
+
+			// CSV: колонки по заголовку, ответ тоже CSV
+			resp, body := testRequest(t, http.MethodPost, streamURL,
+				"original_url,correlation_id\nhttps://example.com/stream/3,c\n", map[string]string{"Content-Type": csvContentType, "Cookie": cookie["Cookie"]})
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, csvContentType, resp.Header.Get("Content-Type"))
+			rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+			require.NoError(t, err)
+			require.Len(t, rows, 2)
+			assert.Equal(t, csvResultColumns, rows[0])
+			assert.Equal(t, "c", rows[1][0])
+			assert.Equal(t, models.BatchStatusCreated, rows[1][2])
+
+			// Без колонки original_url CSV не разобрать
+			resp, _ = testRequest(t, http.MethodPost, streamURL, "url\nhttps://example.com\n", map[string]string{"Content-Type": csvContentType})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			// Выгрузка в порядке добавления
+			resp, body = testRequest(t, http.MethodGet, exportURL, "", cookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, ndjsonContentType, resp.Header.Get("Content-Type"))
+			var exported []models.URLExport
+			for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+				var item models.URLExport
+				require.NoError(t, json.Unmarshal([]byte(line), &item))
+				exported = append(exported, item)
+			}
+			require.Len(t, exported, 3)
+			assert.Equal(t, models.URLExport{ShortURL: results[0].ShortURL, OriginalURL: "https://example.com/stream/1"}, exported[0])
+			assert.Equal(t, results[3].ShortURL, exported[1].ShortURL)
+			assert.NotNil(t, exported[1].ExpiresAt)
+			assert.Equal(t, "https://example.com/stream/3", exported[2].OriginalURL)
+
+			resp, body = testRequest(t, http.MethodGet, exportURL+"?format=csv", "", cookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			rows, err = csv.NewReader(strings.NewReader(body)).ReadAll()
+			require.NoError(t, err)
+			require.Len(t, rows, 4)
+			assert.Equal(t, csvExportColumns, rows[0])
+			assert.Equal(t, []string{results[0].ShortURL, "https://example.com/stream/1", "", ""}, rows[1])
+
+			// Новый пользователь получает пустую выгрузку, неизвестный формат - ошибка клиента
+			resp, body = testRequest(t, http.MethodGet, exportURL, "", map[string]string{"Accept": csvContentType})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, strings.Join(csvExportColumns, ",")+"\n", body)
+			resp, _ = testRequest(t, http.MethodGet, exportURL+"?format=xml", "", cookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_APIKeys(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("api keys: DB: %s", dbName), func(t *testing.T) {
+			keysURL := "http://127.0.0.1:8080/api/user/keys"
+			// Пользователь появляется в БД с первым изменяющим запросом
+			resp, _ := testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://example.com/owner", map[string]string{})
+			defer resp.Body.Close() // go vet test
+			require.NotEmpty(t, resp.Cookies())
+			ownerCookie := map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value}
+
+			// Неизвестное право
+			resp, _ = testRequest(t, http.MethodPost, keysURL, `{"name":"ci","scopes":["admin"]}`, ownerCookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			resp, body := testRequest(t, http.MethodPost, keysURL, `{"name":"ci","scopes":["shorten"]}`, ownerCookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			var created models.APIKeyResponse
+			require.NoError(t, json.Unmarshal([]byte(body), &created))
+			require.NotEmpty(t, created.Key)
+			bearer := map[string]string{"Authorization": "Bearer " + created.Key}
+
+			// Ключ сокращает ссылки от имени владельца, но без права read не видит их список
+			resp, _ = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/ci"}`, bearer)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusCreated, resp.StatusCode)
+			assert.Empty(t, resp.Cookies())
+			resp, _ = testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", bearer)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+			resp, body = testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", ownerCookie)
+			defer resp.Body.Close()
+			assert.Contains(t, body, "https://example.com/ci")
+
+			// Ключом нельзя управлять ключами
+			resp, _ = testRequest(t, http.MethodGet, keysURL, "", bearer)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+			// В списке ключей нет ни самого ключа, ни его хэша
+			resp, body = testRequest(t, http.MethodGet, keysURL, "", ownerCookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, body, created.ID)
+			assert.NotContains(t, body, created.Key)
+			assert.NotContains(t, body, models.HashAPIKey(created.Key))
+
+			// Отозванный ключ больше не работает
+			resp, _ = testRequest(t, http.MethodDelete, keysURL+"/"+created.ID, "", ownerCookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodPost, "http://127.0.0.1:8080/api/shorten", `{"url":"https://example.com/ci2"}`, bearer)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+			// Чужой ключ отозвать нельзя
+			resp, _ = testRequest(t, http.MethodDelete, keysURL+"/"+created.ID, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestController_Admin(t *testing.T) {
+	// Прогоняем одинаковые тесты на разной конфигурации сервера: inMemoryDB, fileDB
+	tsDBName := []string{"inMemoryDB", "fileDB"}
+	for _, dbName := range tsDBName {
+		ts := NewTestServer(dbName, "")
+		ts.Start()
+		t.Run(fmt.Sprintf("admin: DB: %s", dbName), func(t *testing.T) {
+			admin := map[string]string{"Authorization": "Bearer " + testAdminToken}
+			adminURL := "http://127.0.0.1:8080/api/admin/urls"
+
+			// Ссылка владельца и еще один пользователь, которому ее передадим
+			resp, shortURL := testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://example.com/moderation", map[string]string{})
+			defer resp.Body.Close() // go vet test
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			ownerCookie := map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + resp.Cookies()[0].Value}
+			id := strings.TrimPrefix(shortURL, "http://127.0.0.1:8080/")
+			resp, _ = testRequest(t, http.MethodPost, "http://127.0.0.1:8080", "https://example.com/other", map[string]string{})
+			defer resp.Body.Close()
+			otherCookie := resp.Cookies()[0].Value
+			otherID := strings.Split(otherCookie, ".")[0]
+
+			// Без токена администратора, в том числе с cookie пользователя, admin API недоступен
+			resp, _ = testRequest(t, http.MethodGet, adminURL, "", ownerCookie)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+			resp, body := testRequest(t, http.MethodGet, adminURL+"?short="+id, "", admin)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			var records []models.AdminRecord
+			require.NoError(t, json.Unmarshal([]byte(body), &records))
+			require.Len(t, records, 1)
+			assert.Equal(t, "https://example.com/moderation", records[0].OriginURL)
+			assert.NotEmpty(t, records[0].UserID)
+
+			// Заблокированная ссылка отвечает 451
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/"+id+"/block", "", admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodGet, shortURL, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/"+id+"/unblock", "", admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+			// Удаленную пользователем ссылку можно восстановить
+			resp, _ = testRequest(t, http.MethodDelete, "http://127.0.0.1:8080/api/user/urls", fmt.Sprintf(`["%s"]`, id), ownerCookie)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+			assert.Eventually(t, func() bool {
+				resp, _ := testRequest(t, http.MethodGet, shortURL, "", map[string]string{})
+				defer resp.Body.Close()
+				return resp.StatusCode == http.StatusGone
+			}, time.Second, 10*time.Millisecond)
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/"+id+"/restore", "", admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodGet, shortURL, "", map[string]string{})
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+			// Передача ссылки другому пользователю
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/"+id+"/transfer", `{"user_id":"nobody"}`, admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/"+id+"/transfer", fmt.Sprintf(`{"user_id":"%s"}`, otherID), admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			resp, body = testRequest(t, http.MethodGet, "http://127.0.0.1:8080/api/user/urls", "", map[string]string{"Cookie": appMiddleware.SessionCookieName + "=" + otherCookie})
+			defer resp.Body.Close()
+			assert.Contains(t, body, shortURL)
+			resp, body = testRequest(t, http.MethodGet, adminURL+"?user="+otherID, "", admin)
+			defer resp.Body.Close()
+			assert.Contains(t, body, shortURL)
+
+			resp, _ = testRequest(t, http.MethodPost, adminURL+"/missing/block", "", admin)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+		ts.Close()
+		os.Remove(dbName)
+		os.Remove(dbName + ".clicks")
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: fmt.Errorf("shorturl a: %w", db.ErrNotFound), want: http.StatusNotFound},
+		{err: fmt.Errorf("shorturl a: %w", db.ErrDeleted), want: http.StatusGone},
+		{err: fmt.Errorf("shorturl a: %w", db.ErrExpired), want: http.StatusGone},
+		{err: fmt.Errorf("shorturl a: %w", db.ErrBlocked), want: http.StatusUnavailableForLegalReasons},
+		{err: &models.CollisionError{ShortURL: "a"}, want: http.StatusConflict},
+		{err: fmt.Errorf("sql | get: %w: timeout", db.ErrUnavailable), want: http.StatusServiceUnavailable},
+		{err: service.ErrQueueFull, want: http.StatusServiceUnavailable},
+		{err: fmt.Errorf("%w after 16 attempts", service.ErrShortCodeExhausted), want: http.StatusServiceUnavailable},
+		{err: fmt.Errorf("%w: 4 items, max 3", service.ErrBatchTooLarge), want: http.StatusRequestEntityTooLarge},
+		{err: service.ErrIdempotencyKeyReused, want: http.StatusUnprocessableEntity},
+		{err: fmt.Errorf("alias: %w", service.ErrInvalidAlias), want: http.StatusBadRequest},
+		{err: fmt.Errorf("scope: %w", service.ErrInvalidScope), want: http.StatusBadRequest},
+		{err: fmt.Errorf("url: %w", service.ErrInvalidURL), want: http.StatusBadRequest},
+		{err: fmt.Errorf("url: %w", service.ErrRejectedURL), want: http.StatusUnprocessableEntity},
+		{err: io.ErrUnexpectedEOF, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.want, statusCode(tt.err))
+		})
 	}
-	defer os.Remove("test_db.txt")
 }
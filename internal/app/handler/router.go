@@ -3,6 +3,7 @@ package handler
 import (
 	"github.com/sirupsen/logrus"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -10,7 +11,9 @@ import (
 	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
 )
 
-func NewRouter(c *Controller, db db.Repository, logger *logrus.Logger) http.Handler {
+// NewRouter - admin - middleware из appMiddleware.NewAdminAuth, nil - без /api/admin.
+//			   limiter nil - без ограничения частоты запросов
+func NewRouter(c *Controller, db db.Repository, sessions *appMiddleware.Sessions, verifier appMiddleware.TokenVerifier, admin func(http.Handler) http.Handler, limiter *appMiddleware.RateLimiter, logger *logrus.Logger) http.Handler {
 	// Инициируем Router
 	r := chi.NewRouter()
 
@@ -22,23 +25,53 @@ func NewRouter(c *Controller, db db.Repository, logger *logrus.Logger) http.Hand
 	//Собственные middleware
 	r.Use(appMiddleware.HTTPResponseCompressor)
 	r.Use(appMiddleware.HTTPRequestDecompressor)
-	// Передае в middleware соеденение с БД
-	r.Use(appMiddleware.HTTPCookieAuth(db))
 	c.logger.Info("the middleware success init")
 
-	// API endpoints
-	r.HandleFunc("/", c.DefaultHandler)
-	r.Post("/", c.AddURLHandler)
-	r.Get("/{urlID}", c.GetURLHandler)
-	r.Route("/api", func(r chi.Router) {
-		r.Delete("/user/urls", c.DeleteURLs)
-		r.Get("/user/urls", c.GetUserURLs)
-		r.Route("/shorten", func(r chi.Router) {
-			r.Post("/", c.AddJSONURLHandler)
-			r.Post("/batch", c.AddJSONURLBatchHandler)
+	// Admin API со своей аутентификацией: cookie и ключи пользователей сюда не пускают
+	if admin != nil {
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/urls", c.AdminSearchURLs)
+			r.Route("/urls/{id}", func(r chi.Router) {
+				r.Post("/block", c.AdminBlockURL)
+				r.Post("/unblock", c.AdminUnblockURL)
+				r.Post("/restore", c.AdminRestoreURL)
+				r.Post("/transfer", c.AdminTransferURL)
+			})
 		})
+	}
+
+	r.Group(func(r chi.Router) {
+		// Передаем в middleware соединение с БД: по нему находим пользователей и API ключи
+		r.Use(appMiddleware.HTTPCookieAuth(db, sessions, verifier))
+
+		// API endpoints
+		r.HandleFunc("/", c.DefaultHandler)
+		r.With(appMiddleware.RequireScope(models.ScopeShorten), limiter.Create).Post("/", c.AddURLHandler)
+		r.With(limiter.Redirect).Get("/{urlID}", c.GetURLHandler)
+		r.Route("/api", func(r chi.Router) {
+			// Права API ключей, сессии в браузере разрешено все
+			r.With(appMiddleware.RequireScope(models.ScopeDelete)).Delete("/user/urls", c.DeleteURLs)
+			r.With(appMiddleware.RequireScope(models.ScopeRead)).Get("/user/urls", c.GetUserURLs)
+			r.With(appMiddleware.RequireScope(models.ScopeRead), appMiddleware.StreamDeadlines).Get("/user/urls/export", c.ExportUserURLs)
+			r.With(appMiddleware.RequireScope(models.ScopeRead)).Get("/user/urls/{id}/stats", c.GetURLStats)
+			r.Route("/shorten", func(r chi.Router) {
+				r.Use(appMiddleware.RequireScope(models.ScopeShorten))
+				r.Use(limiter.Create)
+				r.Post("/", c.AddJSONURLHandler)
+				r.Post("/batch", c.AddJSONURLBatchHandler)
+				r.With(appMiddleware.StreamDeadlines).Post("/stream", c.ShortenStreamHandler)
+			})
+			// Ключами управляет только сам пользователь из браузера
+			r.Route("/user/keys", func(r chi.Router) {
+				r.Use(appMiddleware.RequireSession)
+				r.Post("/", c.CreateAPIKey)
+				r.Get("/", c.GetAPIKeys)
+				r.Delete("/{id}", c.RevokeAPIKey)
+			})
+		})
+		r.HandleFunc("/ping", c.PingDB)
 	})
-	r.HandleFunc("/ping", c.PingDB)
 	c.logger.Info("the handler endpoint success init")
 	return r
 }
\ No newline at end of file
@@ -0,0 +1,451 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appMiddleware "github.com/yury-nazarov/shorturl/internal/app/middleware"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/app/service"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	csvContentType    = "text/csv"
+)
+
+// maxStreamLineSize - длиннее строку NDJSON не читаем: импорт останавливается
+const maxStreamLineSize = 64 << 10
+
+// Коды ошибок строк потокового импорта, в дополнение к кодам проверки URL
+const (
+	codeMalformedLine = "malformed_line" // строку не разобрать как JSON или CSV
+	codeReadError     = "read_error"     // тело запроса не дочитать, импорт остановлен
+	codeStorageError  = "storage_error"  // пачку не сохранить в БД, импорт остановлен
+	codeRateLimited   = "rate_limited"   // кончился лимит на создание ссылок, импорт остановлен
+)
+
+// errStreamRateLimited - пачку не сохраняем: кончился лимит клиента на создание ссылок
+var errStreamRateLimited = errors.New("rate limit exceeded")
+
+// csvImportColumns - колонки CSV импорта, обязательна original_url; порядок задает строка заголовка
+var csvImportColumns = []string{"correlation_id", "original_url", "expires_at", "ttl"}
+
+// csvResultColumns, csvExportColumns - колонки CSV ответа импорта и выгрузки
+var (
+	csvResultColumns = []string{"correlation_id", "short_url", "status", "error_code", "error_message"}
+	csvExportColumns = []string{"short_url", "original_url", "expires_at", "raw_url"}
+)
+
+// streamFormat - формат строк потокового импорта и выгрузки
+type streamFormat int
+
+const (
+	formatNDJSON streamFormat = iota
+	formatCSV
+)
+
+func (f streamFormat) contentType() string {
+	if f == formatCSV {
+		return csvContentType
+	}
+	return ndjsonContentType
+}
+
+// importFormat - CSV при Content-Type: text/csv, иначе NDJSON
+func importFormat(r *http.Request) streamFormat {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == csvContentType {
+		return formatCSV
+	}
+	return formatNDJSON
+}
+
+// exportFormat - формат из ?format=ndjson|csv, без него CSV при Accept: text/csv, иначе NDJSON
+func exportFormat(r *http.Request) (streamFormat, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		return formatCSV, nil
+	case "ndjson":
+		return formatNDJSON, nil
+	case "":
+	default:
+		return formatNDJSON, fmt.Errorf("unknown export format %q", format)
+	}
+	if strings.Contains(r.Header.Get("Accept"), csvContentType) {
+		return formatCSV, nil
+	}
+	return formatNDJSON, nil
+}
+
+// ShortenStreamHandler - потоковый импорт ссылок: строки NDJSON (или CSV с заголовком при Content-Type: text/csv)
+//						  разбираются по мере чтения и сохраняются пачками по StreamChunkSize.
+//						  Результат каждой строки в формате запроса уходит клиенту сразу после сохранения ее пачки,
+//						  следующие строки читаются только потом: медленная БД или клиент сдерживают чтение тела.
+//						  Лимит на создание ссылок считается по пачкам, как у batch: первую оплачивает сам запрос,
+//						  за каждую следующую забираем еще токен.
+//						  Статус 200 отправляется до разбора, ошибки строк - в их результатах:
+//						  created или exists - ссылка сохранена или уже была (см. DEDUP_SCOPE),
+//						  invalid - строка не прошла проверку, failed - пачку не сохранить или кончился лимит,
+//						  импорт остановлен
+func (c *Controller) ShortenStreamHandler(w http.ResponseWriter, r *http.Request) {
+	format := importFormat(r)
+	reader, err := newStreamReader(format, r.Body)
+	if err != nil {
+		c.logger.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// HTTP/1.x иначе не дает читать тело запроса после начала ответа, HTTP/2 так умеет всегда
+	if err = http.NewResponseController(w).EnableFullDuplex(); err != nil {
+		c.logger.Debug("enable full duplex: ", err)
+	}
+
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(http.StatusOK)
+	writer := newResultWriter(format, w)
+
+	userID := appMiddleware.User(r.Context()).ID
+	chunk := make([]models.URLBatch, 0, c.lc.StreamChunkSize())
+	var chunks int
+	for {
+		item, readErr := reader.next()
+		if readErr == nil {
+			chunk = append(chunk, item)
+			if len(chunk) < cap(chunk) {
+				continue
+			}
+		}
+		var saveErr error
+		if chunks > 0 && len(chunk) != 0 {
+			if allowed, retryAfter := appMiddleware.TakeRateLimit(r.Context()); !allowed {
+				saveErr = errStreamRateLimited
+				rateLimitChunk(chunk, retryAfter)
+			}
+		}
+		if saveErr == nil {
+			saveErr = c.saveStreamChunk(r.Context(), userID, chunk)
+		}
+		chunks++
+		if readErr != nil && !errors.Is(readErr, io.EOF) && saveErr == nil {
+			c.logger.Print("stream import: ", readErr)
+			chunk = append(chunk, models.URLBatch{
+				Status: models.BatchStatusFailed,
+				Error:  &models.URLBatchError{Code: codeReadError, Message: readErr.Error()},
+			})
+		}
+		for _, result := range chunk {
+			if err = writer.write(result); err != nil {
+				c.logger.Print("stream import: ", err)
+				return
+			}
+		}
+		if err = writer.flush(); err != nil {
+			c.logger.Print("stream import: ", err)
+			return
+		}
+		if readErr != nil || saveErr != nil {
+			return
+		}
+		chunk = chunk[:0]
+	}
+}
+
+// saveStreamChunk - проверяет строки пачки и сохраняет корректные, в строках остаются их результаты.
+//					 Ошибку БД вернет после того как отметит корректные строки failed
+func (c *Controller) saveStreamChunk(ctx context.Context, userID string, chunk []models.URLBatch) error {
+	now := time.Now()
+	var records []models.Record
+	var valid []int
+	for i := range chunk {
+		if chunk[i].Status == models.BatchStatusInvalid {
+			continue
+		}
+		originURL, err := c.validator.Validate(chunk[i].OriginalURL)
+		var expiresAt *time.Time
+		if err == nil {
+			expiresAt, err = service.ExpiresAt(now, chunk[i].ExpiresAt, chunk[i].TTL)
+		}
+		if err != nil {
+			chunk[i].Status = models.BatchStatusInvalid
+			chunk[i].Error = batchError(err)
+			continue
+		}
+		records = append(records, models.Record{OriginURL: originURL, UserID: userID, ExpiresAt: expiresAt, RawURL: service.RawURL(chunk[i].OriginalURL, originURL)})
+		valid = append(valid, i)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	shortURLs, exists, err := c.lc.ShortenBatch(ctx, records, nil)
+	if err != nil {
+		c.logger.Print("stream import: ", err)
+		for _, i := range valid {
+			chunk[i].Status = models.BatchStatusFailed
+			chunk[i].Error = &models.URLBatchError{Code: codeStorageError, Message: "links are not saved"}
+		}
+		return err
+	}
+	for n, i := range valid {
+		chunk[i].ShortURL = shortURLs[n]
+		chunk[i].Status = batchStatus(exists[n])
+	}
+	return nil
+}
+
+// rateLimitChunk - строки пачки, кроме invalid, не сохранены: кончился лимит
+func rateLimitChunk(chunk []models.URLBatch, retryAfter time.Duration) {
+	message := fmt.Sprintf("rate limit exceeded, retry after %s", retryAfter.Round(time.Second))
+	for i := range chunk {
+		if chunk[i].Status == models.BatchStatusInvalid {
+			continue
+		}
+		chunk[i].Status = models.BatchStatusFailed
+		chunk[i].Error = &models.URLBatchError{Code: codeRateLimited, Message: message}
+	}
+}
+
+// ExportUserURLs - выгрузка ссылок пользователя в NDJSON или CSV, см. exportFormat.
+//					Ссылки пишутся в ответ по мере чтения из БД, весь список в памяти не собирается
+func (c *Controller) ExportUserURLs(w http.ResponseWriter, r *http.Request) {
+	format, err := exportFormat(r)
+	if err != nil {
+		c.logger.Print(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Заголовки уходят с первой ссылкой: если БД ответит ошибкой сразу, клиент получит ее статус
+	writer := newExportWriter(format, w)
+	var started bool
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", format.contentType())
+		w.WriteHeader(http.StatusOK)
+	}
+	err = c.db.ForEachUserURL(r.Context(), appMiddleware.User(r.Context()).ID, func(record models.Record) error {
+		start()
+		return writer.write(models.URLExport{ShortURL: record.ShortURL, OriginalURL: record.OriginURL, ExpiresAt: record.ExpiresAt, RawURL: record.RawURL})
+	})
+	if err != nil && !started {
+		c.writeError(w, err)
+		return
+	}
+	if err != nil {
+		// Статус уже отправлен, клиент увидит оборванный ответ
+		c.logger.Print("export user urls: ", err)
+		return
+	}
+	start()
+	if err = writer.flush(); err != nil {
+		c.logger.Print("export user urls: ", err)
+	}
+}
+
+// streamReader - строки потокового импорта.
+//				  next вернет io.EOF, когда строки кончились, и ошибку, после которой читать дальше нельзя.
+//				  Строку, которую не разобрать, вернет со статусом invalid
+type streamReader interface {
+	next() (models.URLBatch, error)
+}
+
+// newStreamReader - для CSV сразу читает заголовок, без колонки original_url вернет ошибку
+func newStreamReader(format streamFormat, body io.Reader) (streamReader, error) {
+	if format == formatNDJSON {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 4096), maxStreamLineSize)
+		return &ndjsonReader{scanner: scanner}, nil
+	}
+
+	reader := csv.NewReader(body)
+	// Число полей проверяем сами: строке без необязательных колонок это не ошибка
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, fmt.Errorf("csv header without original_url, columns: %s", strings.Join(csvImportColumns, ","))
+	}
+	return &csvReader{reader: reader, columns: columns, line: 1}, nil
+}
+
+// ndjsonReader - одна строка - один JSON объект models.URLBatch, пустые строки пропускаются
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *ndjsonReader) next() (models.URLBatch, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := r.scanner.Bytes()
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		var item models.URLBatch
+		if err := json.Unmarshal(data, &item); err != nil {
+			return invalidLine(r.line, err), nil
+		}
+		return lineItem(r.line, item), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return models.URLBatch{}, fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+	return models.URLBatch{}, io.EOF
+}
+
+// csvReader - колонки по строке заголовка, см. csvImportColumns
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+func (r *csvReader) next() (models.URLBatch, error) {
+	fields, err := r.reader.Read()
+	r.line++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return invalidLine(r.line, err), nil
+	}
+	if err != nil {
+		return models.URLBatch{}, err
+	}
+	column := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	item := models.URLBatch{CorrelationID: column("correlation_id"), OriginalURL: column("original_url")}
+	if value := column("expires_at"); len(value) != 0 {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return invalidLine(r.line, fmt.Errorf("expires_at: %w", err)), nil
+		}
+		item.ExpiresAt = &expiresAt
+	}
+	if value := column("ttl"); len(value) != 0 {
+		if item.TTL, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return invalidLine(r.line, fmt.Errorf("ttl: %w", err)), nil
+		}
+	}
+	return lineItem(r.line, item), nil
+}
+
+// lineItem - строка без correlation_id получает в нем свой номер, чтобы клиент сопоставил результат
+func lineItem(line int, item models.URLBatch) models.URLBatch {
+	if len(item.CorrelationID) == 0 {
+		item.CorrelationID = strconv.Itoa(line)
+	}
+	return item
+}
+
+// invalidLine - результат строки, которую не разобрать
+func invalidLine(line int, err error) models.URLBatch {
+	return models.URLBatch{
+		CorrelationID: strconv.Itoa(line),
+		Status:        models.BatchStatusInvalid,
+		Error:         &models.URLBatchError{Code: codeMalformedLine, Message: err.Error()},
+	}
+}
+
+// streamWriter - строки потокового ответа, flush отдает записанное клиенту
+type streamWriter struct {
+	w   http.ResponseWriter
+	enc *json.Encoder
+	csv *csv.Writer
+	// header - строка заголовка CSV, пишется перед первой строкой
+	header []string
+}
+
+func newResultWriter(format streamFormat, w http.ResponseWriter) *streamWriter {
+	return newStreamWriter(format, w, csvResultColumns)
+}
+
+func newExportWriter(format streamFormat, w http.ResponseWriter) *streamWriter {
+	return newStreamWriter(format, w, csvExportColumns)
+}
+
+func newStreamWriter(format streamFormat, w http.ResponseWriter, header []string) *streamWriter {
+	if format == formatCSV {
+		return &streamWriter{w: w, csv: csv.NewWriter(w), header: header}
+	}
+	return &streamWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// write - models.URLBatch или models.URLExport одной строкой
+func (s *streamWriter) write(v interface{}) error {
+	if s.enc != nil {
+		return s.enc.Encode(v)
+	}
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	var fields []string
+	switch v := v.(type) {
+	case models.URLBatch:
+		var code, message string
+		if v.Error != nil {
+			code, message = v.Error.Code, v.Error.Message
+		}
+		fields = []string{v.CorrelationID, v.ShortURL, v.Status, code, message}
+	case models.URLExport:
+		var expiresAt string
+		if v.ExpiresAt != nil {
+			expiresAt = v.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		fields = []string{v.ShortURL, v.OriginalURL, expiresAt, v.RawURL}
+	default:
+		return fmt.Errorf("unexpected stream row %T", v)
+	}
+	return s.csv.Write(fields)
+}
+
+func (s *streamWriter) writeHeader() error {
+	if s.header == nil {
+		return nil
+	}
+	header := s.header
+	s.header = nil
+	return s.csv.Write(header)
+}
+
+func (s *streamWriter) flush() error {
+	if s.csv != nil {
+		if err := s.writeHeader(); err != nil {
+			return err
+		}
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	// Без Flush ответ все равно уйдет, просто позже
+	if err := http.NewResponseController(s.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
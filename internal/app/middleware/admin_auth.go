@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// NewAdminAuth - middleware для /api/admin: пускает только с "Authorization: Bearer <ADMIN_TOKEN>".
+//				  Вернет nil, если токен не задан: admin API тогда не подключаем
+func NewAdminAuth(cfg config.Config) (func(next http.Handler) http.Handler, error) {
+	if len(cfg.AdminToken) == 0 {
+		return nil, nil
+	}
+	if len(cfg.AdminToken) < minSecretLength {
+		return nil, fmt.Errorf("admin token must be at least %d bytes", minSecretLength)
+	}
+	token := []byte(cfg.AdminToken)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			scheme, value, ok := cutBearer(r.Header.Get("Authorization"))
+			if !ok || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(value), token) != 1 {
+				unauthorized(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+type apiKeyKey struct{}
+
+// APIKey - ключ, которым аутентифицирован запрос. false - запрос пришел с cookie сессии
+func APIKey(ctx context.Context) (models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey{}).(models.APIKey)
+	return key, ok
+}
+
+var (
+	// ErrUnauthenticated - нет bearer токена или он не прошел проверку: 401
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrUserBlocked - пользователь заблокирован: 403
+	ErrUserBlocked = errors.New("user is blocked")
+)
+
+// bearerAuth - аутентификация по заголовку "Authorization: Bearer <токен>": JWT или API ключ.
+//				Cookie при этом не выдаем и не читаем: машинному клиенту сессия не нужна
+func bearerAuth(db db.Repository, verifier TokenVerifier, header string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	ctx, err := AuthenticateBearer(r.Context(), db, verifier, header, isReadOnly(r.Method))
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		unauthorized(w)
+		return
+	case errors.Is(err, ErrUserBlocked):
+		w.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		writeDBError(w, err)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AuthenticateBearer - проверяет "Bearer <токен>" и вернет ctx с пользователем, см. User() и APIKey().
+//						Общая часть для HTTP и gRPC. readOnly - запрос ничего не меняет:
+//						нового пользователя из JWT тогда не сохраняем.
+//						Ошибки - ErrUnauthenticated, ErrUserBlocked или ошибка БД
+func AuthenticateBearer(ctx context.Context, db db.Repository, verifier TokenVerifier, header string, readOnly bool) (context.Context, error) {
+	scheme, token, ok := cutBearer(header)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return ctx, ErrUnauthenticated
+	}
+	// В API ключе нет точек, в JWT их ровно две
+	if verifier != nil && strings.Count(token, ".") == 2 {
+		return jwtAuth(ctx, db, verifier, token, readOnly)
+	}
+	return apiKeyAuth(ctx, db, token)
+}
+
+// apiKeyAuth - пользователь - владелец API ключа
+func apiKeyAuth(ctx context.Context, db db.Repository, token string) (context.Context, error) {
+	key, err := db.GetAPIKey(ctx, models.HashAPIKey(token))
+	if errors.Is(err, models.ErrNotFound) {
+		return ctx, ErrUnauthenticated
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if key.Revoked() {
+		return ctx, ErrUnauthenticated
+	}
+	user, err := db.GetUser(ctx, key.UserID)
+	if err != nil {
+		return ctx, err
+	}
+	if user.Status == models.UserBlocked {
+		return ctx, ErrUserBlocked
+	}
+	ctx = context.WithValue(ctx, userKey{}, user)
+	return context.WithValue(ctx, apiKeyKey{}, key), nil
+}
+
+// jwtAuth - пользователь из claims токена. Как и с cookie, в БД он попадает с первым изменяющим запросом
+func jwtAuth(ctx context.Context, db db.Repository, verifier TokenVerifier, token string, readOnly bool) (context.Context, error) {
+	now := time.Now()
+	identity, err := verifier.Verify(token, now)
+	if err != nil {
+		log.Print(err)
+		return ctx, ErrUnauthenticated
+	}
+	user := models.NewUser(identity.UserID, now)
+	user.DisplayName = identity.DisplayName
+	user, err = loadUser(ctx, readOnly, db, user)
+	if err != nil {
+		return ctx, err
+	}
+	if user.Status == models.UserBlocked {
+		return ctx, ErrUserBlocked
+	}
+	return context.WithValue(ctx, userKey{}, user), nil
+}
+
+// cutBearer - делит заголовок Authorization на схему и токен
+func cutBearer(header string) (string, string, bool) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return "", "", false
+	}
+	return header[:i], strings.TrimSpace(header[i+1:]), true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// writeDBError - ошибка БД при аутентификации: 503 если БД недоступна, иначе 500
+func writeDBError(w http.ResponseWriter, err error) {
+	log.Print(err)
+	if errors.Is(err, models.ErrUnavailable) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// RequireScope - middleware - пропускает запросы с API ключом только если ключу выдано право scope.
+//				  Сессия пользователя в браузере имеет все права
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := APIKey(r.Context()); ok && !key.HasScope(scope) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireSession - middleware - только для сессии в браузере:
+//					ключом нельзя выпустить себе новый ключ с большими правами
+func RequireSession(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := APIKey(r.Context()); ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -1,10 +1,8 @@
 package middleware
 
 import (
-	"bytes"
 	"compress/gzip"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
@@ -19,6 +17,34 @@ func (w gzipBodyWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush - отдает клиенту уже сжатое, нужно потоковым ответам
+func (w gzipBodyWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		if err := gz.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap - исходный ResponseWriter для http.ResponseController
+func (w gzipBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// gzipBodyReader - распаковывает тело запроса по мере чтения, Close закрывает и исходное тело
+type gzipBodyReader struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (r gzipBodyReader) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
+
 // HTTPResponseCompressor - от клиента пришел заголовок: "Accept-Encoding: gzip"
 //							(Данные от клиента передал одним из текстовых форматов)
 //							вернет сжатый gzip HTTP Response Body.
@@ -53,7 +79,8 @@ func HTTPResponseCompressor(next http.Handler) http.Handler {
 
 // HTTPRequestDecompressor - от клиента пришел заголовок: "Content-Encoding: gzip"
 //							 (Данные от клиента сжаты в формате gzip!)
-//  						 распаковывает сжатый gzip HTTP Request Body.
+//  						 распаковывает сжатый gzip HTTP Request Body по мере чтения,
+//							 чтобы потоковый импорт не держал весь запрос в памяти.
 //							 Добавляет в БД текстовую ссылку
 func HTTPRequestDecompressor(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -69,16 +96,10 @@ func HTTPRequestDecompressor(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		defer gz.Close()
-		data, err := io.ReadAll(gz)
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		// Записываем распакованый в текстовый формат body и передаем управление дальше
-		body := ioutil.NopCloser(bytes.NewBuffer(data))
-		r.Body = body
+		// Распакованный body читает уже хендлер, длина исходного тела ему не подходит
+		r.Body = gzipBodyReader{Reader: gz, body: r.Body}
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HSTS - middleware - заголовок Strict-Transport-Security: браузер больше не ходит к сервису по HTTP.
+//		  Ставится только в режиме HTTPS, maxAge 0 - заголовок не отдаем
+func HSTS(maxAge time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if maxAge < time.Second {
+			return next
+		}
+		value := fmt.Sprintf("max-age=%d; includeSubDomains", int64(maxAge.Seconds()))
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RedirectToHTTPS - обработчик HTTP сервера рядом с HTTPS: отправляет клиента на тот же путь по HTTPS.
+//					 Хост берем из BaseURL, а не из запроса, чтобы нельзя было увести клиента на чужой домен
+func RedirectToHTTPS(host string) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHSTS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	HSTS(365*24*time.Hour)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/xvTrr", nil))
+	assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+
+	w = httptest.NewRecorder()
+	HSTS(0)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/xvTrr", nil))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	// Host из запроса не используем
+	r := httptest.NewRequest(http.MethodPost, "http://evil.example.com/api/shorten?x=1", nil)
+	w := httptest.NewRecorder()
+	RedirectToHTTPS("short.example.com:8443").ServeHTTP(w, r)
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+	assert.Equal(t, "https://short.example.com:8443/api/shorten?x=1", resp.Header.Get("Location"))
+}
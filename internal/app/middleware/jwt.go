@@ -0,0 +1,348 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity - пользователь, которого удостоверяет bearer токен
+type Identity struct {
+	UserID      string
+	DisplayName string
+}
+
+// TokenVerifier - проверка bearer токена от внешнего провайдера (SSO шлюза).
+//				   Можно подменить своей реализацией, например проверкой через introspection endpoint
+type TokenVerifier interface {
+	// Verify вернет пользователя или ошибку, обернутую в ErrInvalidToken
+	Verify(token string, now time.Time) (Identity, error)
+}
+
+// jwtKey - публичный ключ для RS256/ES256, kid пустой у ключей из PEM
+type jwtKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// jwtVerifier - проверяет JWT с алгоритмами HS256, RS256 и ES256
+type jwtVerifier struct {
+	secret    []byte
+	keys      []jwtKey
+	issuer    string
+	audience  string
+	userClaim string
+	nameClaim string
+	skew      time.Duration
+}
+
+// NewJWTVerifier - вернет nil, если в конфиге нет ни секрета, ни файла с ключами: JWT не принимаем
+func NewJWTVerifier(cfg config.Config) (TokenVerifier, error) {
+	if len(cfg.JWTSecret) == 0 && len(cfg.JWTKeyFile) == 0 {
+		return nil, nil
+	}
+	if len(cfg.JWTSecret) != 0 && len(cfg.JWTSecret) < minSecretLength {
+		return nil, fmt.Errorf("jwt secret must be at least %d bytes", minSecretLength)
+	}
+	if len(cfg.JWTUserClaim) == 0 {
+		return nil, fmt.Errorf("jwt user claim is not set")
+	}
+	v := &jwtVerifier{
+		secret:    []byte(cfg.JWTSecret),
+		issuer:    cfg.JWTIssuer,
+		audience:  cfg.JWTAudience,
+		userClaim: cfg.JWTUserClaim,
+		nameClaim: cfg.JWTNameClaim,
+		skew:      cfg.JWTClockSkew,
+	}
+	if len(cfg.JWTKeyFile) != 0 {
+		keys, err := loadJWTKeys(cfg.JWTKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+	return v, nil
+}
+
+// Verify - проверяет подпись, срок, iss и aud токена
+func (v *jwtVerifier) Verify(token string, now time.Time) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+	if err = v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+	if err = v.verifyClaims(claims, now); err != nil {
+		return Identity{}, err
+	}
+	userID, _ := claims[v.userClaim].(string)
+	if len(userID) == 0 {
+		return Identity{}, fmt.Errorf("%w: claim %q is empty", ErrInvalidToken, v.userClaim)
+	}
+	name, _ := claims[v.nameClaim].(string)
+	return Identity{UserID: userID, DisplayName: name}, nil
+}
+
+// verifySignature - алгоритм берем из заголовка, но проверяем только ключом подходящего типа:
+//					 так RS256 токен нельзя подписать публичным ключом как секретом HS256
+func (v *jwtVerifier) verifySignature(alg string, kid string, signed string, signature []byte) error {
+	switch alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			break
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	case "RS256", "ES256":
+		digest := sha256.Sum256([]byte(signed))
+		for _, k := range v.candidates(kid) {
+			switch key := k.key.(type) {
+			case *rsa.PublicKey:
+				if alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+					return nil
+				}
+			case *ecdsa.PublicKey:
+				// Подпись ES256 - r и s по 32 байта подряд
+				if alg == "ES256" && key.Curve == elliptic.P256() && len(signature) == 64 {
+					r := new(big.Int).SetBytes(signature[:32])
+					s := new(big.Int).SetBytes(signature[32:])
+					if ecdsa.Verify(key, digest[:], r, s) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+}
+
+// candidates - ключи с kid из заголовка, если таких нет - ключи без kid
+func (v *jwtVerifier) candidates(kid string) []jwtKey {
+	var byKid, withoutKid []jwtKey
+	for _, k := range v.keys {
+		switch {
+		case len(kid) != 0 && k.kid == kid:
+			byKid = append(byKid, k)
+		case len(kid) == 0 || len(k.kid) == 0:
+			withoutKid = append(withoutKid, k)
+		}
+	}
+	if len(byKid) != 0 {
+		return byKid
+	}
+	return withoutKid
+}
+
+// verifyClaims - exp обязателен, nbf проверяем если есть. Расхождение часов до skew прощаем
+func (v *jwtVerifier) verifyClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(v.skew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.skew).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if len(v.issuer) != 0 {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, iss)
+		}
+	}
+	if len(v.audience) != 0 && !hasAudience(claims["aud"], v.audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// numericDate - дата в claim: секунды с начала эпохи
+func numericDate(value interface{}) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// hasAudience - aud может быть строкой или массивом строк
+func hasAudience(value interface{}, audience string) bool {
+	switch aud := value.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, item := range aud {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// loadJWTKeys - читает публичные ключи из JWKS (JSON) или PEM файла
+func loadJWTKeys(path string) ([]jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []jwtKey
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		keys, err = parseJWKS(data)
+	} else {
+		keys, err = parsePEM(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt keys %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwt keys %s: no RSA or P-256 public keys found", path)
+	}
+	return keys, nil
+}
+
+// parseJWKS - ключи RSA и EC P-256 из JWK Set, ключи не для подписи пропускаем
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		if len(k.Use) != 0 && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: n: %w", k.Kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: e: %w", k.Kid, err)
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: x: %w", k.Kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: y: %w", k.Kid, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %q: point is not on curve", k.Kid)
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// parsePEM - публичные ключи и сертификаты из PEM, остальные блоки пропускаем
+func parsePEM(data []byte) ([]jwtKey, error) {
+	var keys []jwtKey
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return keys, nil
+		}
+		data = rest
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, jwtKey{key: key})
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+const jwtSecret = "jwt-secret-jwt-secret-jwt-secret"
+
+// signJWT - собирает токен, key - []byte для HS256, *rsa.PrivateKey или *ecdsa.PrivateKey
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if len(kid) != 0 {
+		header["kid"] = kid
+	}
+	headerJSON, err := json.Marshal(header)
+	require.NoError(t, err)
+	claimsJSON, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// RSA ключ в PEM, EC ключ в JWKS
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pemFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+	jwksFile := filepath.Join(dir, "jwks.json")
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"ec-1","use":"sig","crv":"P-256","x":"%s","y":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))))
+	require.NoError(t, os.WriteFile(jwksFile, []byte(jwks), 0644))
+
+	newVerifier := func(keyFile string) TokenVerifier {
+		verifier, err := NewJWTVerifier(config.Config{
+			JWTSecret:    jwtSecret,
+			JWTKeyFile:   keyFile,
+			JWTIssuer:    "https://sso.example.com",
+			JWTAudience:  "shorturl",
+			JWTUserClaim: "email",
+			JWTNameClaim: "name",
+			JWTClockSkew: time.Minute,
+		})
+		require.NoError(t, err)
+		return verifier
+	}
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"email": "user@example.com",
+			"name":  "User",
+			"iss":   "https://sso.example.com",
+			"aud":   []string{"other", "shorturl"},
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		keyFile string
+		token   string
+		wantErr bool
+	}{
+		{name: "HS256", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(nil))},
+		{name: "RS256 from PEM", keyFile: pemFile, token: signJWT(t, "RS256", "", rsaKey, claims(nil))},
+		{name: "ES256 from JWKS", keyFile: jwksFile, token: signJWT(t, "ES256", "ec-1", ecKey, claims(nil))},
+		{name: "audience as string", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"aud": "shorturl"}))},
+		{name: "expired within clock skew", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}))},
+		{name: "expired", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), wantErr: true},
+		{name: "without exp", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"exp": nil})), wantErr: true},
+		{name: "not valid yet", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})), wantErr: true},
+		{name: "wrong issuer", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"iss": "https://evil.example.com"})), wantErr: true},
+		{name: "wrong audience", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"aud": "other"})), wantErr: true},
+		{name: "without user claim", token: signJWT(t, "HS256", "", []byte(jwtSecret), claims(map[string]interface{}{"email": nil})), wantErr: true},
+		{name: "wrong secret", token: signJWT(t, "HS256", "", []byte("other-secret"), claims(nil)), wantErr: true},
+		{name: "RS256 without keys", token: signJWT(t, "RS256", "", rsaKey, claims(nil)), wantErr: true},
+		{name: "ES256 signed by RSA key", keyFile: pemFile, token: signJWT(t, "ES256", "", rsaKey, claims(nil)), wantErr: true},
+		{name: "alg none", token: signJWT(t, "none", "", nil, claims(nil)), wantErr: true},
+		{name: "malformed", token: "a.b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := newVerifier(tt.keyFile).Verify(tt.token, now)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidToken), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, Identity{UserID: "user@example.com", DisplayName: "User"}, identity)
+		})
+	}
+}
+
+func TestNewJWTVerifier(t *testing.T) {
+	verifier, err := NewJWTVerifier(config.Config{JWTUserClaim: "sub"})
+	require.NoError(t, err)
+	assert.Nil(t, verifier)
+
+	_, err = NewJWTVerifier(config.Config{JWTSecret: "short", JWTUserClaim: "sub"})
+	assert.Error(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte("no keys here"), 0644))
+	_, err = NewJWTVerifier(config.Config{JWTKeyFile: keyFile, JWTUserClaim: "sub"})
+	assert.Error(t, err)
+}
+
+func TestHTTPCookieAuth_JWT(t *testing.T) {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	verifier, err := NewJWTVerifier(config.Config{JWTSecret: jwtSecret, JWTUserClaim: "sub", JWTNameClaim: "name"})
+	require.NoError(t, err)
+
+	var user models.User
+	handler := HTTPCookieAuth(db, newTestSessions(t, newSecret), verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = User(r.Context())
+	}))
+	token := signJWT(t, "HS256", "", []byte(jwtSecret), map[string]interface{}{"sub": "sso-user", "name": "SSO User", "exp": time.Now().Add(time.Hour).Unix()})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+	assert.Equal(t, "sso-user", user.ID)
+
+	// Пользователь из claims сохранился вместе с именем
+	stored, err := db.GetUser(ctx, "sso-user")
+	require.NoError(t, err)
+	assert.Equal(t, "SSO User", stored.DisplayName)
+
+	r = httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	r.Header.Set("Authorization", "Bearer "+token+"x")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	resp = w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// RateLimit - корзина токенов: Burst запросов подряд, дальше Rate запросов в секунду
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// NewRateLimit - лимит из конфига: perMinute запросов в минуту, 0 - без ограничения.
+//				  Без burst корзина вмещает минутный лимит
+func NewRateLimit(perMinute int, burst int) RateLimit {
+	if perMinute <= 0 {
+		return RateLimit{}
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return RateLimit{Rate: float64(perMinute) / 60, Burst: burst}
+}
+
+// Enabled - лимит задан
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// RateLimitResult - состояние корзины после запроса
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining - сколько запросов еще можно сделать сразу
+	Remaining int
+	// RetryAfter - через сколько появится следующий токен, 0 если запрос пропущен
+	RetryAfter time.Duration
+	// Reset - через сколько корзина наполнится целиком
+	Reset time.Duration
+}
+
+// RateLimitStore - где хранятся корзины. По умолчанию в памяти процесса,
+//					для нескольких реплик нужна общая реализация, например поверх Redis
+type RateLimitStore interface {
+	// Take - забирает токен из корзины key, если он там есть
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// bucket - токены на момент updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full - с этого момента корзина полная и ее можно забыть
+	full time.Time
+}
+
+// memoryRateLimitStore - корзины в памяти процесса.
+//						  Полные корзины удаляем раз в sweepInterval, чтобы map не росла от разовых клиентов
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+const sweepInterval = time.Minute
+
+// NewMemoryRateLimitStore - корзины в памяти, лимиты считаются отдельно на каждой реплике
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]*bucket{}}
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	// Пополняем корзину за прошедшее время
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimiter - лимиты запросов на клиента: отдельно на создание ссылок и на переходы.
+//				 Клиент - API ключ, пользователь из JWT или с действующей cookie сессией, иначе IP адрес.
+//				 Пользователь с cookie сессией платит еще и из корзины своего IP: новую сессию получает
+//				 любой запрос без cookie, и без этого каждая новая cookie давала бы полную корзину.
+//				 Ставится после HTTPCookieAuth, IP берем из RemoteAddr, который заполняет middleware.RealIP
+type RateLimiter struct {
+	store    RateLimitStore
+	create   RateLimit
+	redirect RateLimit
+	logger   *logrus.Logger
+	now      func() time.Time
+}
+
+// NewRateLimiter - store nil - корзины в памяти процесса
+func NewRateLimiter(cfg config.Config, store RateLimitStore, logger *logrus.Logger) *RateLimiter {
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	return &RateLimiter{
+		store:    store,
+		create:   NewRateLimit(cfg.RateLimitCreate, cfg.RateLimitCreateBurst),
+		redirect: NewRateLimit(cfg.RateLimitRedirect, cfg.RateLimitRedirectBurst),
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Create - middleware - лимит на создание коротких ссылок
+func (l *RateLimiter) Create(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return l.limit("create", l.create, next)
+}
+
+// Redirect - middleware - лимит на переходы по коротким ссылкам
+func (l *RateLimiter) Redirect(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return l.limit("redirect", l.redirect, next)
+}
+
+// limit - отвечает 429 с Retry-After, если корзина клиента пуста.
+//		   Заголовки RateLimit-* отдаем на каждый запрос.
+//		   Если хранилище лимитов недоступно - пропускаем запрос: сервис важнее лимитов.
+//		   Следующие токены хендлер может забрать через TakeRateLimit
+func (l *RateLimiter) limit(name string, limit RateLimit, next http.Handler) http.Handler {
+	if !limit.Enabled() {
+		return next
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		keys := clientKeys(r)
+		take := func(ctx context.Context) (bool, time.Duration) {
+			result, err := l.take(ctx, name, keys, limit)
+			if err != nil {
+				l.logger.Print("rate limit: ", err)
+				return true, 0
+			}
+			return result.Allowed, result.RetryAfter
+		}
+		r = r.WithContext(context.WithValue(r.Context(), rateLimitKey{}, take))
+
+		result, err := l.take(r.Context(), name, keys, limit)
+		if err != nil {
+			l.logger.Print("rate limit: ", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+type rateLimitKey struct{}
+
+// TakeRateLimit - еще один токен из корзин клиента по лимиту middleware, который пропустил запрос.
+//				   Для запросов, которые создают ссылки частями, например потоковый импорт.
+//				   false и через сколько появится токен - корзина пуста.
+//				   true, если лимита на запросе нет или хранилище лимитов недоступно
+func TakeRateLimit(ctx context.Context) (bool, time.Duration) {
+	take, ok := ctx.Value(rateLimitKey{}).(func(context.Context) (bool, time.Duration))
+	if !ok {
+		return true, 0
+	}
+	return take(ctx)
+}
+
+// take - забирает токен из каждой корзины keys по порядку, запрос пропускаем только если токен нашелся во всех.
+//		  На первой пустой корзине останавливаемся, чтобы отказ не списывал токены из следующих.
+//		  Результат - самой пустой корзины
+func (l *RateLimiter) take(ctx context.Context, name string, keys []string, limit RateLimit) (RateLimitResult, error) {
+	var result RateLimitResult
+	now := l.now()
+	for i, key := range keys {
+		r, err := l.store.Take(ctx, name+":"+key, limit, now)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		if !r.Allowed {
+			return r, nil
+		}
+		if i == 0 || r.Remaining < result.Remaining {
+			result.Remaining = r.Remaining
+		}
+		if r.Reset > result.Reset {
+			result.Reset = r.Reset
+		}
+		result.Allowed = true
+	}
+	return result, nil
+}
+
+// clientKeys - с кого считаем лимит. Новый пользователь без cookie получает новый ID
+//				на каждый запрос, поэтому его считаем по IP. Cookie сессию считаем и по ней, и по IP
+func clientKeys(r *http.Request) []string {
+	if key, ok := APIKey(r.Context()); ok {
+		return []string{"key:" + key.ID}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := "ip:" + host
+	user := User(r.Context())
+	switch {
+	case len(user.ID) == 0 || anonymous(r.Context()):
+		return []string{ip}
+	case cookieSession(r.Context()):
+		return []string{ip, "user:" + user.ID}
+	}
+	return []string{"user:" + user.ID}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	// 2 запроса подряд, дальше 1 в секунду
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	result, err := store.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 1, Reset: time.Second}, result)
+	result, err = store.Take(ctx, "a", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 0, Reset: 2 * time.Second}, result)
+
+	// Корзина пуста: токен появится через 0.5с
+	result, err = store.Take(ctx, "a", limit, now.Add(500*time.Millisecond))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	// У другого клиента своя корзина
+	result, err = store.Take(ctx, "b", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = store.Take(ctx, "a", limit, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// Полные корзины забываем, новая снова полная
+	result, err = store.Take(ctx, "a", limit, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Remaining)
+	assert.Len(t, store.(*memoryRateLimitStore).buckets, 1)
+}
+
+// failingStore - недоступное общее хранилище лимитов
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, RateLimit, time.Time) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("connection refused")
+}
+
+func TestRateLimiter(t *testing.T) {
+	cfg := config.Config{RateLimitCreate: 60, RateLimitCreateBurst: 1}
+	limiter := NewRateLimiter(cfg, nil, logger.New())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	handler := limiter.Create(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(remoteAddr string, ctx context.Context) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/api/shorten", nil).WithContext(ctx)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	resp := request("10.0.0.1:1234", context.Background())
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Reset"))
+
+	// Тот же IP с другого порта
+	resp = request("10.0.0.1:4321", context.Background())
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// Новый пользователь без сессии считается по IP
+	fresh := context.WithValue(context.WithValue(context.Background(), userKey{}, models.User{ID: "new"}), anonymousKey{}, true)
+	resp = request("10.0.0.1:1234", fresh)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Пользователь с cookie сессией платит и из корзины IP
+	session := context.WithValue(context.WithValue(context.Background(), userKey{}, models.User{ID: "session"}), cookieSessionKey{}, true)
+	resp = request("10.0.0.1:1234", session)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp = request("10.0.0.2:1234", session)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Пользователь из JWT и API ключ считаются отдельно от IP
+	resp = request("10.0.0.1:1234", context.WithValue(context.Background(), userKey{}, models.User{ID: "user"}))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = request("10.0.0.1:1234", context.WithValue(context.Background(), apiKeyKey{}, models.APIKey{ID: "key"}))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Лимит на переходы не задан
+	redirect := limiter.Redirect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	redirect.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/xvTrr", nil))
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	// Хранилище недоступно - пропускаем
+	limiter = NewRateLimiter(cfg, failingStore{}, logger.New())
+	handler = limiter.Create(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		resp = request("10.0.0.1:1234", context.Background())
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestRateLimiter_RotatedCookies(t *testing.T) {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	sessions := newTestSessions(t, newSecret)
+	limiter := NewRateLimiter(config.Config{RateLimitCreate: 60, RateLimitCreateBurst: 2}, nil, logger.New())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	handler := HTTPCookieAuth(db, sessions, nil)(limiter.Create(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	// Клиент каждый раз приходит с новой действующей сессией
+	var codes []int
+	for i := 0; i < 4; i++ {
+		userID := fmt.Sprintf("rotated%d", i)
+		require.NoError(t, db.CreateUser(ctx, models.NewUser(userID, now)))
+		r := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.AddCookie(sessions.Issue(userID, now))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+}
+
+func TestTakeRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(config.Config{RateLimitCreate: 60, RateLimitCreateBurst: 3}, nil, logger.New())
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	// Запрос забирает токен сам, следующие хендлер забирает по мере работы
+	var taken []bool
+	var retryAfter time.Duration
+	handler := limiter.Create(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			var allowed bool
+			allowed, retryAfter = TakeRateLimit(r.Context())
+			taken = append(taken, allowed)
+		}
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten/stream", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, []bool{true, true, false}, taken)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Без лимита на запросе токены не нужны
+	allowed, _ := TakeRateLimit(context.Background())
+	assert.True(t, allowed)
+}
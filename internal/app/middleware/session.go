@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SessionCookieName - cookie с подписанным ID пользователя
+const SessionCookieName = "session_token"
+
+// minSecretLength - секрет короче ключа HMAC-SHA256 не принимаем
+const minSecretLength = 32
+
+var ErrInvalidSession = errors.New("invalid session")
+
+// Sessions - подписанные cookie сессии вида "userID.expires.signature",
+//			  signature - base64url HMAC-SHA256 от "userID.expires".
+//			  Подписываем первым секретом, проверяем всеми: при ротации новый секрет ставим первым,
+//			  а старый убираем, когда истекут выданные им cookie.
+type Sessions struct {
+	keys   [][]byte
+	maxAge time.Duration
+	secure bool
+}
+
+// NewSessions - без секретов в конфиге генерирует случайный: сессии будут жить до рестарта
+func NewSessions(cfg config.Config, logger *logrus.Logger) (*Sessions, error) {
+	if cfg.CookieMaxAge <= 0 {
+		return nil, fmt.Errorf("cookie max age must be positive")
+	}
+	s := &Sessions{
+		maxAge: cfg.CookieMaxAge,
+		secure: cfg.CookieSecure,
+	}
+	for _, secret := range cfg.CookieSecrets {
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("cookie secret must be at least %d bytes", minSecretLength)
+		}
+		s.keys = append(s.keys, []byte(secret))
+	}
+	if len(s.keys) == 0 {
+		key := make([]byte, minSecretLength)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		s.keys = append(s.keys, key)
+		logger.Warn("COOKIE_SECRETS is not set, sessions will not survive a restart")
+	}
+	return s, nil
+}
+
+// Issue - выдает cookie сессии пользователя userID
+func (s *Sessions) Issue(userID string, now time.Time) *http.Cookie {
+	expiresAt := now.Add(s.maxAge)
+	payload := userID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    payload + "." + sign(s.keys[0], payload),
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(s.maxAge.Seconds()),
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Verify - проверяет подпись и срок cookie, вернет ID пользователя и срок сессии
+func (s *Sessions) Verify(value string, now time.Time) (string, time.Time, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, ErrInvalidSession
+	}
+	payload := parts[0] + "." + parts[1]
+	var valid bool
+	for _, key := range s.keys {
+		if hmac.Equal([]byte(sign(key, payload)), []byte(parts[2])) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", time.Time{}, ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidSession
+	}
+	expiresAt := time.Unix(expires, 0)
+	if !now.Before(expiresAt) {
+		return "", time.Time{}, fmt.Errorf("%w: expired", ErrInvalidSession)
+	}
+	return parts[0], expiresAt, nil
+}
+
+func sign(key []byte, payload string) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+type userKey struct{}
+
+// User - пользователь, которого аутентифицировал HTTPCookieAuth.
+//		  Новый пользователь попадает в БД только с первым изменяющим запросом
+func User(ctx context.Context) models.User {
+	user, _ := ctx.Value(userKey{}).(models.User)
+	return user
+}
+
+type anonymousKey struct{}
+
+// anonymous - пользователь заведен на этом запросе: клиент пришел без действующей сессии
+func anonymous(ctx context.Context) bool {
+	value, _ := ctx.Value(anonymousKey{}).(bool)
+	return value
+}
+
+type cookieSessionKey struct{}
+
+// cookieSession - пользователь из cookie сессии, а не из API ключа или JWT.
+//				   Такую сессию клиент может завести себе сам в любой момент
+func cookieSession(ctx context.Context) bool {
+	value, _ := ctx.Value(cookieSessionKey{}).(bool)
+	return value
+}
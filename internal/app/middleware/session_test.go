@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+const (
+	oldSecret = "old-secret-old-secret-old-secret"
+	newSecret = "new-secret-new-secret-new-secret"
+)
+
+func newTestSessions(t *testing.T, secrets ...string) *Sessions {
+	sessions, err := NewSessions(config.Config{CookieSecrets: secrets, CookieMaxAge: time.Hour}, logger.New())
+	require.NoError(t, err)
+	return sessions
+}
+
+func TestSessions_Verify(t *testing.T) {
+	now := time.Now()
+	old := newTestSessions(t, oldSecret)
+	rotated := newTestSessions(t, newSecret, oldSecret)
+	value := old.Issue("user", now).Value
+
+	tests := []struct {
+		name     string
+		sessions *Sessions
+		value    string
+		now      time.Time
+		wantErr  bool
+	}{
+		{name: "valid", sessions: old, value: value, now: now},
+		{name: "signed with rotated out key", sessions: rotated, value: value, now: now},
+		{name: "unknown key", sessions: newTestSessions(t, newSecret), value: value, now: now, wantErr: true},
+		{name: "tampered user id", sessions: old, value: "admin" + strings.TrimPrefix(value, "user"), now: now, wantErr: true},
+		{name: "expired", sessions: old, value: value, now: now.Add(2 * time.Hour), wantErr: true},
+		{name: "legacy token", sessions: old, value: "0123456789abcdef", now: now, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, _, err := tt.sessions.Verify(tt.value, tt.now)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidSession))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user", userID)
+		})
+	}
+}
+
+func TestSessions_Issue(t *testing.T) {
+	_, err := NewSessions(config.Config{CookieSecrets: []string{"short"}, CookieMaxAge: time.Hour}, logger.New())
+	assert.Error(t, err)
+
+	cookie := newTestSessions(t, newSecret).Issue("user", time.Now())
+	assert.Equal(t, SessionCookieName, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.Equal(t, 3600, cookie.MaxAge)
+}
+
+func TestHTTPCookieAuth(t *testing.T) {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	// Пользователь, заведенный по токену старого формата
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("legacytoken", time.Now())))
+	blocked := models.NewUser("blocked", time.Now())
+	blocked.Status = models.UserBlocked
+	require.NoError(t, db.CreateUser(ctx, blocked))
+	sessions := newTestSessions(t, newSecret)
+
+	var user models.User
+	handler := HTTPCookieAuth(db, sessions, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = User(r.Context())
+	}))
+	serve := func(method string, cookie string) *http.Response {
+		r := httptest.NewRequest(method, "/abc", nil)
+		if len(cookie) != 0 {
+			r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	// Без cookie заводим нового пользователя на любом методе, но в БД он попадает только с изменяющим запросом
+	resp := serve(http.MethodGet, "")
+	defer resp.Body.Close()
+	require.Len(t, resp.Cookies(), 1)
+	newUserID := user.ID
+	assert.NotEmpty(t, newUserID)
+	_, err := db.GetUser(ctx, newUserID)
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	// Свежая cookie не переиздается
+	cookie := resp.Cookies()[0].Value
+	resp = serve(http.MethodPost, cookie)
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Cookies())
+	assert.Equal(t, newUserID, user.ID)
+	_, err = db.GetUser(ctx, newUserID)
+	assert.NoError(t, err)
+
+	// Поддельная cookie - новый пользователь
+	resp = serve(http.MethodGet, "admin.9999999999.signature")
+	defer resp.Body.Close()
+	assert.Len(t, resp.Cookies(), 1)
+	assert.NotEqual(t, "admin", user.ID)
+
+	// Токен без подписи, даже совпадающий с ID существующего пользователя, - новый пользователь:
+	// ID виден в подписанной cookie и не должен сам по себе давать вход
+	resp = serve(http.MethodGet, "legacytoken")
+	defer resp.Body.Close()
+	require.Len(t, resp.Cookies(), 1)
+	assert.NotEqual(t, "legacytoken", user.ID)
+	verified, _, err := sessions.Verify(resp.Cookies()[0].Value, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, verified)
+	resp = serve(http.MethodPost, newUserID)
+	defer resp.Body.Close()
+	require.Len(t, resp.Cookies(), 1)
+	assert.NotEqual(t, newUserID, user.ID)
+
+	// Заблокированный пользователь
+	resp = serve(http.MethodGet, sessions.Issue("blocked", time.Now()).Value)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestHTTPCookieAuth_APIKey(t *testing.T) {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("user", time.Now())))
+	require.NoError(t, db.CreateAPIKey(ctx, models.APIKey{ID: "read", UserID: "user", Hash: models.HashAPIKey("read-key"), Scopes: []string{models.ScopeRead}}))
+	require.NoError(t, db.CreateAPIKey(ctx, models.APIKey{ID: "revoked", UserID: "user", Hash: models.HashAPIKey("revoked-key"), Scopes: []string{models.ScopeRead}}))
+	require.NoError(t, db.RevokeAPIKey(ctx, "user", "revoked", time.Now()))
+
+	var user models.User
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = User(r.Context())
+	})
+	auth := HTTPCookieAuth(db, newTestSessions(t, newSecret), nil)
+
+	tests := []struct {
+		name    string
+		header  string
+		handler http.Handler
+		want    int
+	}{
+		{name: "valid key", header: "Bearer read-key", handler: RequireScope(models.ScopeRead)(ok), want: http.StatusOK},
+		{name: "scheme is case insensitive", header: "bearer read-key", handler: ok, want: http.StatusOK},
+		{name: "missing scope", header: "Bearer read-key", handler: RequireScope(models.ScopeDelete)(ok), want: http.StatusForbidden},
+		{name: "key management", header: "Bearer read-key", handler: RequireSession(ok), want: http.StatusForbidden},
+		{name: "revoked key", header: "Bearer revoked-key", handler: ok, want: http.StatusUnauthorized},
+		{name: "unknown key", header: "Bearer other-key", handler: ok, want: http.StatusUnauthorized},
+		{name: "basic auth", header: "Basic dXNlcjpwYXNz", handler: ok, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user = models.User{}
+			r := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			r.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			auth(tt.handler).ServeHTTP(w, r)
+			resp := w.Result()
+			defer resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+			// Машинному клиенту cookie не выдаем
+			assert.Empty(t, resp.Cookies())
+			if tt.want == http.StatusOK {
+				assert.Equal(t, "user", user.ID)
+			}
+		})
+	}
+}
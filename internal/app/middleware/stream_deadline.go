@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// StreamDeadlines - middleware - для потоковых запросов: ReadTimeout и WriteTimeout сервера
+//					 считаются не от начала запроса, а от последнего чтения тела и последней записи ответа.
+//					 Иначе сервер обрывает длинный импорт или выгрузку посередине, а так медленный клиент
+//					 все равно не держит соединение дольше таймаута без движения.
+//					 Таймауты берем у http.Server, который обслуживает запрос
+func StreamDeadlines(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		server, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		controller := http.NewResponseController(w)
+		if server.ReadTimeout > 0 {
+			r.Body = &deadlineBody{ReadCloser: r.Body, deadline: deadline{timeout: server.ReadTimeout, set: controller.SetReadDeadline}}
+		}
+		if server.WriteTimeout > 0 {
+			w = &deadlineWriter{ResponseWriter: w, deadline: deadline{timeout: server.WriteTimeout, set: controller.SetWriteDeadline}}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// deadline - дедлайн соединения, который отодвигается на timeout при каждом чтении или записи
+type deadline struct {
+	timeout time.Duration
+	until   time.Time
+	set     func(time.Time) error
+}
+
+// extend - отодвигает дедлайн, но не чаще чем раз в половину таймаута
+func (d *deadline) extend() {
+	now := time.Now()
+	if d.until.Sub(now) > d.timeout/2 {
+		return
+	}
+	d.until = now.Add(d.timeout)
+	// Если соединение не умеет - остается таймаут сервера на весь запрос
+	_ = d.set(d.until)
+}
+
+type deadlineBody struct {
+	io.ReadCloser
+	deadline deadline
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	b.deadline.extend()
+	return b.ReadCloser.Read(p)
+}
+
+type deadlineWriter struct {
+	http.ResponseWriter
+	deadline deadline
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.deadline.extend()
+	return w.ResponseWriter.Write(b)
+}
+
+// FlushError - для http.ResponseController: отдает клиенту записанное
+func (w *deadlineWriter) FlushError() error {
+	w.deadline.extend()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap - исходный ResponseWriter для http.ResponseController
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamDeadlines(t *testing.T) {
+	// Эхо построчно: каждая строка тела сразу уходит в ответ
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).EnableFullDuplex()
+		lines := bufio.NewScanner(r.Body)
+		for lines.Scan() {
+			if _, err := fmt.Fprintln(w, lines.Text()); err != nil {
+				return
+			}
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				return
+			}
+		}
+	})
+	// Запрос идет 5 таймаутов, но строки приходят чаще таймаута
+	stream := func(handler http.Handler) int {
+		ts := httptest.NewUnstartedServer(handler)
+		ts.Config.ReadTimeout = 200 * time.Millisecond
+		ts.Config.WriteTimeout = 200 * time.Millisecond
+		ts.Start()
+		defer ts.Close()
+
+		pr, pw := io.Pipe()
+		go func() {
+			defer pw.Close()
+			for i := 0; i < 10; i++ {
+				if _, err := fmt.Fprintln(pw, i); err != nil {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}()
+		resp, err := http.Post(ts.URL, "text/plain", pr)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var n int
+		for lines := bufio.NewScanner(resp.Body); lines.Scan(); n++ {
+		}
+		return n
+	}
+
+	assert.Equal(t, 10, stream(StreamDeadlines(echo)))
+	// Без middleware сервер обрывает запрос по таймауту
+	assert.Less(t, stream(echo), 10)
+}
@@ -1,74 +1,100 @@
 package middleware
 
 import (
-	"crypto/hmac"
+	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"log"
 	"net/http"
+	"time"
 )
 
-// HTTPCookieAuth - middleware - устанавливает подписаный токен для клиента, ели его нет.
-func HTTPCookieAuth(db db.Repository) func(next http.Handler) http.Handler {
+// HTTPCookieAuth - middleware - проверяет подписанную cookie сессии на любом запросе
+//					и кладет пользователя в контекст запроса, см. User().
+//					Если cookie нет или она не прошла проверку - заводит нового пользователя.
+//					Токены старого формата без подписи не принимаем: такой токен - это ID пользователя,
+//					а он виден в каждой подписанной cookie, так что по нему можно войти под чужим ID.
+//					Когда прошла половина срока сессии - выдает новую cookie с тем же ID.
+//					С заголовком Authorization cookie не нужна: пользователь - владелец API ключа
+//					или из claims JWT, если verifier не nil.
+func HTTPCookieAuth(db db.Repository, sessions *Sessions, verifier TokenVerifier) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				next.ServeHTTP(w, r)
+			// Машинные клиенты и SSO шлюз приходят с API ключом или JWT вместо cookie
+			if header := r.Header.Get("Authorization"); len(header) != 0 {
+				bearerAuth(db, verifier, header, next, w, r)
 				return
 			}
+
+			now := time.Now()
+			var userID string
+			var refresh, fresh bool
+
 			// Получаем токен из Request
-			token, err := r.Cookie("session_token")
-			// Если токена нет
-			if err != nil {
-				// Генерим, шифруем и устанавливаем в куку
-				cookieToken := setCookieEncryptToken()
-				// Добавляем куку в Response для ответа клиенту
-				http.SetCookie(w, cookieToken)
-				// Добавляем куку в Request для дальнейшей обработке в хендлерах и добавления в БД
-				r.AddCookie(cookieToken)
-				next.ServeHTTP(w, r)
-				return
+			if token, err := r.Cookie(SessionCookieName); err == nil {
+				if id, expiresAt, err := sessions.Verify(token.Value, now); err == nil {
+					userID = id
+					refresh = expiresAt.Sub(now) < sessions.maxAge/2
+				}
+			}
+			// Если токена нет - заводим нового пользователя
+			if len(userID) == 0 {
+				userID = uniqueUserID()
+				refresh, fresh = true, true
 			}
 
-			// Проверяем валидность токена, найдя его в БД
-			tokenExist, err := db.GetToken(r.Context(), token.Value)
+			user, err := loadUser(r.Context(), isReadOnly(r.Method), db, models.NewUser(userID, now))
 			if err != nil {
-				log.Print(err)
+				writeDBError(w, err)
+				return
 			}
-			if !tokenExist {
-				cookieToken := setCookieEncryptToken()
-				http.SetCookie(w, cookieToken)
-				r.AddCookie(cookieToken)
-				next.ServeHTTP(w, r)
+			if user.Status == models.UserBlocked {
+				w.WriteHeader(http.StatusForbidden)
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			if refresh {
+				http.SetCookie(w, sessions.Issue(userID, now))
+			}
+			ctx := context.WithValue(context.WithValue(r.Context(), userKey{}, user), cookieSessionKey{}, true)
+			if fresh {
+				ctx = context.WithValue(ctx, anonymousKey{}, true)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(fn)
 	}
 }
 
-// setCookieEncryptToken - Генерит новый токен, шифрует, устанавливает в cookie
-func setCookieEncryptToken() *http.Cookie{
-	// Если токена нет - генерим, подписываем, добавляем в куку и передаем HTTP Request дальше
-	uuid := uniqueUserID()
-	// Подписываем его
-	sessionToken := encryptToken([]byte(uuid))
-
-	// Устанавливаем cookie
-	cookieToken := &http.Cookie{
-		Name: "session_token",
-		Value: sessionToken,
-		Secure: false,
+// loadUser - пользователь из БД, а если его там нет - user.
+//			  Сохраняем нового пользователя только когда он что-то меняет,
+//			  чтобы не заводить запись на каждый переход по короткой ссылке
+func loadUser(ctx context.Context, readOnly bool, db db.Repository, user models.User) (models.User, error) {
+	stored, err := db.GetUser(ctx, user.ID)
+	if !errors.Is(err, models.ErrNotFound) {
+		return stored, err
 	}
-	return cookieToken
+	if readOnly {
+		return user, nil
+	}
+	err = db.CreateUser(ctx, user)
+	if errors.Is(err, models.ErrConflict) {
+		// Параллельный запрос того же пользователя успел раньше
+		return db.GetUser(ctx, user.ID)
+	}
+	return user, err
+}
+
+// isReadOnly - запрос ничего не меняет
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
 }
 
-// uniqueUserID - Генерит рандомный токен для пользователя
-func  uniqueUserID() string {
+// uniqueUserID - Генерит рандомный ID для пользователя
+func uniqueUserID() string {
 	uuid := make([]byte, 16)
 	_, err := rand.Read(uuid)
 	if err != nil {
@@ -76,13 +102,3 @@ func  uniqueUserID() string {
 	}
 	return hex.EncodeToString(uuid)
 }
-
-func encryptToken(uuid []byte) string{
-	key := []byte("qwe")
-	h := hmac.New(sha256.New, key)
-	h.Write(uuid)
-	dst := h.Sum(nil)
-
-	return hex.EncodeToString(dst)
-}
-
@@ -0,0 +1,15 @@
+package db
+
+import "github.com/yury-nazarov/shorturl/internal/app/repository/models"
+
+// Ошибки, которые возвращает любая реализация Repository.
+// Проверяем через errors.Is, хендлеры отображают их в HTTP статусы.
+var (
+	ErrNotFound    = models.ErrNotFound    // 404
+	ErrDeleted     = models.ErrDeleted     // 410
+	ErrExpired     = models.ErrExpired     // 410
+	ErrBlocked     = models.ErrBlocked     // 451
+	ErrConflict    = models.ErrConflict    // 409
+	ErrUnavailable = models.ErrUnavailable // 503
+	ErrTooLong     = models.ErrTooLong     // 400
+)
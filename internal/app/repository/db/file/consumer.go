@@ -1,7 +1,9 @@
 package filedb
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"os"
 )
@@ -9,7 +11,11 @@ import (
 // Чтение данных из файла
 
 type consumer struct {
-	file    *os.File
+	file   *os.File
+	reader *bufio.Reader
+	// format - formatFrames или formatFramesV1, для formatJSON читаем через decoder
+	format int
+	// decoder - только для файлов в старом формате JSON строк
 	decoder *json.Decoder
 }
 
@@ -18,18 +24,52 @@ func newConsumer(fileName string) (*consumer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &consumer{
-		file:    file,
-		decoder: json.NewDecoder(file),
-	}, nil
+	c := &consumer{
+		file:   file,
+		reader: bufio.NewReader(file),
+	}
+	if c.format, err = detectFormat(c.reader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if c.format == formatJSON {
+		c.decoder = json.NewDecoder(c.reader)
+	}
+	return c, nil
+}
+
+// next - читает следующую запись в v.
+//		  Записи с неверным CRC пропускаем: о них уже сообщил recoverFile при старте
+func (c *consumer) next(v interface{}) error {
+	if c.decoder != nil {
+		return c.decoder.Decode(v)
+	}
+	for {
+		payload, err := readFrame(c.reader, c.format)
+		if errors.Is(err, errChecksum) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(payload, v)
+	}
+}
+
+func (c *consumer) read() (*entry, error) {
+	e := &entry{}
+	if err := c.next(e); err != nil {
+		return nil, err
+	}
+	return e, nil
 }
 
-func (c *consumer) read() (*models.Record, error) {
-	record := &models.Record{}
-	if err := c.decoder.Decode(&record); err != nil {
+func (c *consumer) readClick() (*models.Click, error) {
+	click := &models.Click{}
+	if err := c.next(click); err != nil {
 		return nil, err
 	}
-	return record, nil
+	return click, nil
 }
 
 func (c *consumer) close() error {
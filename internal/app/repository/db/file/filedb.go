@@ -5,150 +5,793 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 )
 
-// Хранение данных в файле
+// Хранение данных в файле: журнал с добавлением в конец и индекс в памяти.
+// Файл читается целиком только при старте, дальше чтения идут из индекса,
+// а изменения (добавление, удаление, tombstone) дописываются в журнал.
 
+// unavailable - ошибки чтения и записи файла для вызывающего кода означают недоступность БД
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %s", models.ErrUnavailable, err)
+}
 
+// Options - настройки журнала
+type Options struct {
+	// SyncPolicy - SyncAlways, SyncInterval или SyncNever. Пустая политика - SyncAlways
+	SyncPolicy string
+	// SyncInterval - период fsync для SyncInterval
+	SyncInterval time.Duration
+	// CompactInterval - как часто переписывать журнал без лишних строк, 0 - не компактить
+	CompactInterval time.Duration
+}
 
 type fileDB struct {
-	name string
+	// mu - индекс и журнал меняются атомарно
+	mu    sync.RWMutex
+	index *index
+	log   *producer
+	// clicksMu - запись переходов идет в отдельный файл
+	clicksMu sync.Mutex
+	// clicks - агрегаты переходов по shortURL, строятся из файла переходов при старте
+	clicks   map[string]*models.ClickCounter
+	name     string
+	opts     Options
+	done     chan struct{}
+	wg       sync.WaitGroup
 }
 
 // NewFileDB - 	возвращает объект для записи и чтения из файла БД
-//			   	Метод Add() открывает файл, записывает новую строчку
-// 				Метод Get() ищет нужное значение в файле и возвращает его
-func NewFileDB(fileName string) *fileDB {
+//			   	При создании восстанавливает файлы после падения, читает журнал и строит индекс,
+//				открывает журнал на запись и запускает фоновые fsync и компакцию
+func NewFileDB(fileName string, opts Options) (*fileDB, error) {
+	if len(opts.SyncPolicy) == 0 {
+		opts.SyncPolicy = SyncAlways
+	}
 	f := &fileDB{
-		name: fileName,
+		index:  newIndex(),
+		clicks: map[string]*models.ClickCounter{},
+		name:   fileName,
+		opts:  opts,
+		done:  make(chan struct{}),
+	}
+	report, err := recoverFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("file db %s: %w", fileName, err)
+	}
+	logRecovery(fileName, report)
+	if err = f.load(); err != nil {
+		return nil, fmt.Errorf("file db %s: %w", fileName, err)
+	}
+	if report.legacy {
+		// Дописывать записи с заголовками в файл из JSON строк нельзя, поэтому сразу переписываем его
+		err = f.rewrite()
+	} else {
+		f.log, err = newProducer(fileName, opts.SyncPolicy)
 	}
-	return f
+	if err != nil {
+		return nil, fmt.Errorf("file db %s: %w", fileName, err)
+	}
+	if err = f.recoverClicks(); err != nil {
+		return nil, fmt.Errorf("file db %s: %w", f.clicksFileName(), err)
+	}
+	if err = f.loadClicks(); err != nil {
+		return nil, fmt.Errorf("file db %s: %w", f.clicksFileName(), err)
+	}
+	f.wg.Add(1)
+	go f.run()
+	return f, nil
 }
 
-// Add - добавляем запись в БД
-func (f *fileDB) Add(ctx context.Context, shortURL string, originURL string, token string) error {
-	// Создаем новую запись как JSON объект
-	data := &models.Record{
-		ShortURL:  shortURL,
-		OriginURL: originURL,
-		Token: token,
+// logRecovery - сообщает о потерянных при падении записях
+func logRecovery(fileName string, report recoveryReport) {
+	if report.corrupted == 0 && report.truncated == 0 {
+		return
 	}
-	// Открываем файл на запись
-	p, err := newProducer(f.name)
+	log.Printf("file db %s recovered: %d records, %d corrupted records skipped, %d bytes of torn tail truncated",
+		fileName, report.records, report.corrupted, report.truncated)
+}
+
+// load - восстанавливает индекс из журнала
+func (f *fileDB) load() error {
+	c, err := newConsumer(f.name)
 	if err != nil {
 		return err
 	}
-	// go vet test: should check returned error before deferring p.Close()
-	defer func() {
-		if err = p.close(); err != nil {
-			log.Print(err)
+	defer c.close()
+	for {
+		e, err := c.read()
+		// io.ErrUnexpectedEOF - оборванная последняя строка файла в старом формате, она потеряна
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
 		}
-	}()
-	// Записываем новую строку
-	if err = p.write(data); err != nil {
-		return err
+		if err != nil {
+			return err
+		}
+		f.index.apply(e)
 	}
-	return nil
 }
 
-// Get Поиск в БД
-func (f *fileDB) Get(ctx context.Context, shortURL string, token string) (string, error) {
-	// Открываем файл на чтение
-	c, err := newConsumer(f.name)
+// recoverClicks - восстанавливает файл переходов, старый формат переписывает в новый
+func (f *fileDB) recoverClicks() error {
+	report, err := recoverFile(f.clicksFileName())
+	if err != nil {
+		return err
+	}
+	logRecovery(f.clicksFileName(), report)
+	if !report.legacy {
+		return nil
+	}
+
+	c, err := newConsumer(f.clicksFileName())
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer c.close()
-	// В цикле читаем каждую запись
+	tmpName := f.clicksFileName() + ".tmp"
+	os.Remove(tmpName)
+	p, err := newProducer(tmpName, SyncAlways)
+	if err != nil {
+		return err
+	}
 	for {
-		r, err := c.read()
-
-		if err == io.EOF {
-			return "", fmt.Errorf("the URL not found")
+		click, err := c.readClick()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
 		}
-		if r.ShortURL == shortURL {
-			return r.OriginURL, nil
+		if err == nil {
+			err = p.writeClick(click)
 		}
 		if err != nil {
-			return "", err
+			p.close()
+			os.Remove(tmpName)
+			return err
 		}
 	}
+	if err = p.close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, f.clicksFileName())
 }
 
-func (f *fileDB) GetToken(ctx context.Context, token string) (bool, error) {
-	// Открываем файл на чтение
-	c, err := newConsumer(f.name)
+// loadClicks - считает агрегаты переходов по файлу переходов, сами переходы в памяти не держим
+func (f *fileDB) loadClicks() error {
+	c, err := newConsumer(f.clicksFileName())
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer c.close()
-	// В цикле читаем каждую запись
 	for {
-		r, err := c.read()
+		click, err := c.readClick()
 		if err == io.EOF {
-			return false, fmt.Errorf("the URL not found")
-		}
-		if r.Token == token {
-			return true, nil
+			return nil
 		}
 		if err != nil {
-			return false, err
+			return err
 		}
+		f.countClick(*click)
 	}
 }
 
+// countClick - учитывает переход в агрегатах, вызывается под clicksMu или до запуска БД
+func (f *fileDB) countClick(click models.Click) {
+	counter, ok := f.clicks[click.ShortURL]
+	if !ok {
+		counter = models.NewClickCounter()
+		f.clicks[click.ShortURL] = counter
+	}
+	counter.Add(click)
+}
 
+// run - фоновый fsync для SyncInterval и периодическая компакция
+func (f *fileDB) run() {
+	defer f.wg.Done()
+	var syncC, compactC <-chan time.Time
+	if f.opts.SyncPolicy == SyncInterval && f.opts.SyncInterval > 0 {
+		ticker := time.NewTicker(f.opts.SyncInterval)
+		defer ticker.Stop()
+		syncC = ticker.C
+	}
+	if f.opts.CompactInterval > 0 {
+		ticker := time.NewTicker(f.opts.CompactInterval)
+		defer ticker.Stop()
+		compactC = ticker.C
+	}
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-syncC:
+			f.mu.Lock()
+			err := f.log.sync()
+			f.mu.Unlock()
+			if err != nil {
+				log.Printf("file db sync err: %s", err)
+			}
+		case <-compactC:
+			if err := f.compact(); err != nil {
+				log.Printf("file db compaction err: %s", err)
+			}
+		}
+	}
+}
 
-// GetUserURL - вернет слайс из структур со всем URL пользователя
-func (f *fileDB) GetUserURL(ctx context.Context, token string) ([]models.Record, error) {
-	// Открываем файл на чтение
-	c, err := newConsumer(f.name)
+// compact - переписывает журнал, если в нем есть лишние строки
+func (f *fileDB) compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.index.garbage == 0 {
+		return nil
+	}
+	return f.rewrite()
+}
+
+// rewrite - переписывает журнал из индекса рядом и атомарно подменяет им старый через rename.
+//			 Вызывать под mu.Lock
+func (f *fileDB) rewrite() error {
+	// Producer дописывает в конец файла, поэтому убираем остатки прошлой неудачной попытки
+	tmpName := f.name + ".tmp"
+	os.Remove(tmpName)
+	p, err := newProducer(tmpName, SyncNever)
 	if err != nil {
-		return []models.Record{}, err
+		return err
 	}
-	defer c.close()
-	// В цикле читаем каждую запись
+	// Пользователи идут первыми, чтобы ссылки при чтении не заводили их заново
+	for _, user := range f.index.usersList() {
+		user := user
+		if err = p.write(&entry{Op: opUser, User: &user}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	for _, key := range f.index.apiKeysList() {
+		key := key
+		if err = p.write(&entry{Op: opAPIKey, APIKey: &key}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	for _, job := range f.index.deletionJobsList() {
+		job := job
+		if err = p.write(&entry{Op: opDeletionJob, DeletionJob: &job}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	for _, key := range f.index.idempotencyKeysList() {
+		key := key
+		if err = p.write(&entry{Op: opIdempotencyKey, IdempotencyKey: &key}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	for _, seq := range f.index.sequencesList() {
+		seq := seq
+		if err = p.write(&entry{Op: opSequence, Sequence: &seq}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	for _, ie := range f.index.entries() {
+		if err = p.write(&entry{Record: ie.record, Deleted: ie.deleted, Blocked: ie.blocked, DedupOwner: ie.dedupOwner}); err != nil {
+			p.close()
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	// Новый файл должен оказаться на диске до rename
+	if err = p.sync(); err != nil {
+		p.close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err = p.close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, f.name); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	// Старый журнал уже подменен, все его состояние есть в новом файле
+	if f.log != nil {
+		if err = f.log.close(); err != nil {
+			log.Printf("file db close old log err: %s", err)
+		}
+	}
+	f.log, err = newProducer(f.name, f.opts.SyncPolicy)
+	if err != nil {
+		return err
+	}
+	f.index.garbage = 0
+	return nil
+}
+
+// Close - останавливает фоновые задачи и сбрасывает журнал на диск
+func (f *fileDB) Close() error {
+	close(f.done)
+	f.wg.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.log.close()
+}
+
+// AddAlias - резервирует выбранный пользователем shortURL: под мьютексом проверяет что он свободен,
+//			  дописывает запись в журнал и в индекс.
+//			  Вернет *models.CollisionError если shortURL уже занят другим URL или другим пользователем
+func (f *fileDB) AddAlias(ctx context.Context, record models.Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Проверяем что короткий URL не занят другой ссылкой
+	if exist, ok := f.index.byShort[record.ShortURL]; ok {
+		if exist.record.OriginURL != record.OriginURL || exist.record.UserID != record.UserID {
+			return &models.CollisionError{ShortURL: record.ShortURL}
+		}
+		// Такая запись уже есть
+		return nil
+	}
+	// В индекс попадает только то, что удалось записать в журнал
+	if err := f.log.write(&entry{Record: record}); err != nil {
+		return unavailable(err)
+	}
+	f.index.put(record, false)
+	return nil
+}
+
+// Upsert - под мьютексом ищет живую ссылку владельца дедупликации на тот же URL,
+//			если ее нет - дописывает запись с владельцем в журнал и в индекс
+func (f *fileDB) Upsert(ctx context.Context, record models.Record, dedupOwner string, now time.Time) (models.Record, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if exist, ok := f.index.byDedup[dedupID{dedupOwner, record.OriginURL}]; ok {
+		// Истекшую ссылку заменит новая, см. index.dedup
+		if !exist.record.Expired(now) {
+			return exist.record, false, nil
+		}
+	}
+	if _, ok := f.index.byShort[record.ShortURL]; ok {
+		return models.Record{}, false, &models.CollisionError{ShortURL: record.ShortURL}
+	}
+	if err := f.log.write(&entry{Record: record, DedupOwner: &dedupOwner}); err != nil {
+		return models.Record{}, false, unavailable(err)
+	}
+	f.index.dedup(f.index.put(record, false), dedupOwner)
+	return record, true, nil
+}
+
+// AddBatch - под мьютексом проверяет всю пачку и дописывает новые ссылки вместе с ключом идемпотентности
+//			  одной записью журнала. Каждая запись проходит дедупликацию как в Upsert, повтор URL в пачке
+//			  получает ту же ссылку. Вернет *models.CollisionError если shortURL новой записи занят в БД или в самой пачке
+func (f *fileDB) AddBatch(ctx context.Context, records []models.Record, dedupOwner string, now time.Time, key models.IdempotencyKeyFunc) ([]models.Record, []bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	saved := make([]models.Record, len(records))
+	created := make([]bool, len(records))
+	// fresh - оригинальный URL -> новая запись пачки, taken - shortURL новых записей
+	fresh := map[string]int{}
+	taken := map[string]bool{}
+	var batch []models.Record
+	for i, record := range records {
+		// Истекшую ссылку заменит новая, см. index.dedup
+		if exist, ok := f.index.byDedup[dedupID{dedupOwner, record.OriginURL}]; ok && !exist.record.Expired(now) {
+			saved[i] = exist.record
+			continue
+		}
+		if j, ok := fresh[record.OriginURL]; ok {
+			saved[i] = saved[j]
+			continue
+		}
+		if _, ok := f.index.byShort[record.ShortURL]; ok || taken[record.ShortURL] {
+			return nil, nil, &models.CollisionError{ShortURL: record.ShortURL}
+		}
+		fresh[record.OriginURL] = i
+		taken[record.ShortURL] = true
+		saved[i], created[i] = record, true
+		batch = append(batch, record)
+	}
+	var idempotencyKey *models.IdempotencyKey
+	if key != nil {
+		var err error
+		if idempotencyKey, err = key(saved, created); err != nil {
+			return nil, nil, err
+		}
+		if exist, ok := f.index.idempotencyKeys[idempotencyID{idempotencyKey.UserID, idempotencyKey.Key}]; ok && !exist.Expired(idempotencyKey.CreatedAt) {
+			return nil, nil, fmt.Errorf("idempotency key %s: %w", idempotencyKey.Key, models.ErrConflict)
+		}
+	}
+	if len(batch) == 0 && idempotencyKey == nil {
+		return saved, created, nil
+	}
+	e := &entry{Op: opBatch, Batch: batch, DedupOwner: &dedupOwner, IdempotencyKey: idempotencyKey}
+	if err := f.log.write(e); err != nil {
+		return nil, nil, unavailable(err)
+	}
+	f.index.apply(e)
+	return saved, created, nil
+}
+
+// GetIdempotencyKey - вернет ключ идемпотентности пользователя, если он не истек
+func (f *fileDB) GetIdempotencyKey(ctx context.Context, userID string, key string, now time.Time) (models.IdempotencyKey, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	exist, ok := f.index.idempotencyKeys[idempotencyID{userID, key}]
+	if !ok || exist.Expired(now) {
+		return models.IdempotencyKey{}, fmt.Errorf("idempotency key %s: %w", key, models.ErrNotFound)
+	}
+	return exist, nil
+}
+
+// DeleteIdempotencyKeys - убирает истекшие ключи из индекса. В журнал ничего не пишем:
+//						   после рестарта истекший ключ все равно не найдется, а из файла его уберет компакция
+func (f *fileDB) DeleteIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deleted int
+	for id, key := range f.index.idempotencyKeys {
+		if key.Expired(now) {
+			delete(f.index.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	f.index.garbage += deleted
+	return deleted, nil
+}
+
+// Get Поиск в БД
+//			 Вернет models.ErrNotFound если URL нет, models.ErrBlocked если его заблокировал модератор,
+//	models.ErrDeleted если он удален и models.ErrExpired если истек срок его жизни
+func (f *fileDB) Get(ctx context.Context, shortURL string, userID string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ie, ok := f.index.byShort[shortURL]
+	if !ok {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrNotFound)
+	}
+	if ie.blocked {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrBlocked)
+	}
+	if ie.deleted {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrDeleted)
+	}
+	if ie.record.Expired(time.Now()) {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrExpired)
+	}
+	return ie.record.OriginURL, nil
+}
+
+// CreateUser - сохраняет нового пользователя
+func (f *fileDB) CreateUser(ctx context.Context, user models.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.index.users[user.ID]; ok {
+		return fmt.Errorf("user %s: %w", user.ID, models.ErrConflict)
+	}
+	if err := f.log.write(&entry{Op: opUser, User: &user}); err != nil {
+		return unavailable(err)
+	}
+	f.index.users[user.ID] = user
+	return nil
+}
+
+// ReserveSequence - сдвигает счетчик name на size и пишет новое значение в журнал.
+//					 Счетчика еще нет - начинаем после ссылок, добавленных до его появления
+func (f *fileDB) ReserveSequence(ctx context.Context, name string, size uint64) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, ok := f.index.sequences[name]
+	if !ok {
+		current = uint64(f.index.nextID)
+	}
+	e := &entry{Op: opSequence, Sequence: &sequence{Name: name, Value: current + size}}
+	if err := f.log.write(e); err != nil {
+		return 0, unavailable(err)
+	}
+	f.index.apply(e)
+	return current + 1, nil
+}
+
+// GetUser - вернет пользователя по ID
+func (f *fileDB) GetUser(ctx context.Context, userID string) (models.User, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	user, ok := f.index.users[userID]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %s: %w", userID, models.ErrNotFound)
+	}
+	return user, nil
+}
+
+// CreateAPIKey - сохраняет новый API ключ
+func (f *fileDB) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.index.apiKeys[key.ID]; ok {
+		return fmt.Errorf("api key %s: %w", key.ID, models.ErrConflict)
+	}
+	if _, ok := f.index.apiKeyByHash[key.Hash]; ok {
+		return fmt.Errorf("api key %s hash: %w", key.ID, models.ErrConflict)
+	}
+	if err := f.log.write(&entry{Op: opAPIKey, APIKey: &key}); err != nil {
+		return unavailable(err)
+	}
+	f.index.putAPIKey(key)
+	return nil
+}
+
+// GetAPIKey - вернет API ключ по хэшу
+func (f *fileDB) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	id, ok := f.index.apiKeyByHash[hash]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key: %w", models.ErrNotFound)
+	}
+	return f.index.apiKeys[id], nil
+}
+
+// GetUserAPIKeys - вернет все API ключи пользователя в порядке создания
+func (f *fileDB) GetUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var result []models.APIKey
+	for _, key := range f.index.apiKeysList() {
+		if key.UserID == userID {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// RevokeAPIKey - отзывает API ключ пользователя: дописывает в журнал ключ с датой отзыва
+func (f *fileDB) RevokeAPIKey(ctx context.Context, userID string, keyID string, now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.index.apiKeys[keyID]
+	if !ok || key.UserID != userID {
+		return fmt.Errorf("api key %s: %w", keyID, models.ErrNotFound)
+	}
+	if key.Revoked() {
+		return nil
+	}
+	key.RevokedAt = &now
+	if err := f.log.write(&entry{Op: opAPIKey, APIKey: &key}); err != nil {
+		return unavailable(err)
+	}
+	f.index.putAPIKey(key)
+	f.index.garbage++
+	return nil
+}
+
+// GetUserURL - вернет слайс из структур со всем URL пользователя
+func (f *fileDB) GetUserURL(ctx context.Context, userID string) ([]models.Record, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	var result []models.Record
-	for {
-		r, err := c.read()
+	for _, ie := range f.index.byOwner[userID] {
+		result = append(result, models.Record{ShortURL: ie.record.ShortURL, OriginURL: ie.record.OriginURL, ExpiresAt: ie.record.ExpiresAt, RawURL: ie.record.RawURL})
+	}
+	return result, nil
+}
 
-		if err == io.EOF {
-			break
+// GetUserRecord - вернет URL пользователя по shortURL, чужой URL не найдется
+func (f *fileDB) GetUserRecord(ctx context.Context, shortURL string, userID string) (models.Record, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ie, ok := f.index.byOwner[userID][shortURL]
+	if !ok {
+		return models.Record{}, fmt.Errorf("user url %s: %w", shortURL, models.ErrNotFound)
+	}
+	return ie.record, nil
+}
+
+// ForEachUserURL - вызывает fn для URL пользователя в порядке добавления.
+//					fn вызывается без блокировки, чтобы медленный клиент выгрузки не держал запись в журнал
+func (f *fileDB) ForEachUserURL(ctx context.Context, userID string, fn func(models.Record) error) error {
+	f.mu.RLock()
+	owned := f.index.ownerEntries(userID)
+	f.mu.RUnlock()
+	for _, ie := range owned {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(models.Record{ShortURL: ie.record.ShortURL, OriginURL: ie.record.OriginURL, ExpiresAt: ie.record.ExpiresAt, RawURL: ie.record.RawURL}); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if r.Token == token {
-			result = append(result, models.Record{ShortURL: r.ShortURL, OriginURL: r.OriginURL})
+// SearchURLs - ищет ссылки по фильтру в порядке добавления
+func (f *fileDB) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.AdminRecord, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var result []models.AdminRecord
+	for _, ie := range f.index.entries() {
+		if !filter.Match(ie.record) {
+			continue
+		}
+		result = append(result, models.AdminRecord{Record: ie.record, Deleted: ie.deleted, Blocked: ie.blocked})
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
 		}
 	}
 	return result, nil
 }
 
+// SetURLBlocked - блокирует или разблокирует ссылку
+func (f *fileDB) SetURLBlocked(ctx context.Context, shortURL string, blocked bool) error {
+	op := opUnblock
+	if blocked {
+		op = opBlock
+	}
+	return f.change(&entry{Record: models.Record{ShortURL: shortURL}, Op: op})
+}
+
+// RestoreURL - снимает с ссылки пометку удаленной
+func (f *fileDB) RestoreURL(ctx context.Context, shortURL string) error {
+	return f.change(&entry{Record: models.Record{ShortURL: shortURL}, Op: opRestore})
+}
+
+// TransferURL - передает ссылку другому пользователю
+func (f *fileDB) TransferURL(ctx context.Context, shortURL string, userID string) error {
+	return f.change(&entry{Record: models.Record{ShortURL: shortURL, UserID: userID}, Op: opTransfer})
+}
+
+// change - дописывает в журнал операцию над существующей ссылкой и применяет ее к индексу
+func (f *fileDB) change(e *entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.index.byShort[e.ShortURL]; !ok {
+		return fmt.Errorf("shorturl %s: %w", e.ShortURL, models.ErrNotFound)
+	}
+	if _, ok := f.index.users[e.UserID]; e.Op == opTransfer && !ok {
+		return fmt.Errorf("user %s: %w", e.UserID, models.ErrNotFound)
+	}
+	if err := f.log.write(e); err != nil {
+		return unavailable(err)
+	}
+	f.index.apply(e)
+	return nil
+}
+
+// DeleteExpired - удаляет ссылки с истекшим сроком жизни: пишет в журнал purge и убирает их из индекса.
+//			 Место в файле освободит компакция.
+func (f *fileDB) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var deleted int
+	for _, ie := range f.index.entries() {
+		if !ie.record.Expired(now) {
+			continue
+		}
+		if err := f.log.write(&entry{Record: models.Record{ShortURL: ie.record.ShortURL}, Op: opPurge}); err != nil {
+			return deleted, unavailable(err)
+		}
+		f.index.remove(ie)
+		// Строка ссылки и строка purge больше не нужны
+		f.index.garbage += 2
+		deleted++
+	}
+	return deleted, nil
+}
+
+// clicksFileName - переходы по коротким URL храним в отдельном файле рядом с БД
+func (f *fileDB) clicksFileName() string {
+	return f.name + ".clicks"
+}
+
+// AddClicks - дописывает пачку переходов в файл
+func (f *fileDB) AddClicks(ctx context.Context, clicks []models.Click) error {
+	f.clicksMu.Lock()
+	defer f.clicksMu.Unlock()
+
+	p, err := newProducer(f.clicksFileName(), SyncNever)
+	if err != nil {
+		return unavailable(err)
+	}
+	for i := range clicks {
+		if err = p.writeClick(&clicks[i]); err != nil {
+			p.close()
+			return unavailable(err)
+		}
+		f.countClick(clicks[i])
+	}
+	return p.close()
+}
+
+// GetClickStats - вернет статистику переходов по shortURL из агрегатов в памяти, файл переходов не читает
+func (f *fileDB) GetClickStats(ctx context.Context, shortURL string) (models.LinkStats, error) {
+	f.clicksMu.Lock()
+	defer f.clicksMu.Unlock()
+	counter, ok := f.clicks[shortURL]
+	if !ok {
+		return models.NewLinkStats(shortURL, nil), nil
+	}
+	return counter.Stats(shortURL), nil
+}
+
 // Ping Для обратной совместимости с Postgres
 func (f *fileDB) Ping() bool {
 	return true
 }
 
-// OriginURLExists Для обратной совместимости с Postgres
-func (f *fileDB) OriginURLExists(ctx context.Context, originURL string) (bool, error) {
-	return false, nil
+// GetShortURLByIdentityPath вернет id записи пользователя по идентификатору короткого URL
+func (f *fileDB) GetShortURLByIdentityPath(ctx context.Context, identityPath string, userID string) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ie, ok := f.index.byIdentity[identityPath]
+	if !ok || ie.record.UserID != userID {
+		return 0, fmt.Errorf("identity path %s: %w", identityPath, models.ErrNotFound)
+	}
+	return ie.id, nil
 }
 
-//// GetOwnerToken Для обратной совместимости с Postgres
-//func (f *fileDB) GetOwnerToken(ctx context.Context, token string) repository.Owner {
-//	owner := repository.Owner{}
-//	return owner
-//}
-
-// GetShortURLByIdentityPath Для обратной совместимости с Postgres
-func (f *fileDB) GetShortURLByIdentityPath(ctx context.Context, identityPath string, token string) int {
-	return 0
+// URLBulkDelete помечает удаленными записи с id из urlsID: пишет tombstone в журнал и обновляет индекс
+func (f *fileDB) URLBulkDelete(ctx context.Context, urlsID []int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range urlsID {
+		ie, ok := f.index.byID[id]
+		if !ok || ie.deleted {
+			continue
+		}
+		if err := f.log.write(&entry{Record: models.Record{ShortURL: ie.record.ShortURL}, Op: opDelete}); err != nil {
+			return unavailable(err)
+		}
+		ie.deleted = true
+		f.index.release(ie)
+		f.index.garbage++
+	}
+	return nil
 }
 
-// URLBulkDelete Для обратной совместимости с Postgres
-//func (f *fileDB) URLBulkDelete(ctx context.Context, idList []int) error {
-func (f *fileDB) URLBulkDelete(ctx context.Context,  urlsID chan int) error {
+// AddDeletionJob - сохраняет задание на удаление ссылок
+func (f *fileDB) AddDeletionJob(ctx context.Context, job models.DeletionJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.index.deletionJobs[job.ID]; ok {
+		return fmt.Errorf("deletion job %s: %w", job.ID, models.ErrConflict)
+	}
+	if err := f.log.write(&entry{Op: opDeletionJob, DeletionJob: &job}); err != nil {
+		return unavailable(err)
+	}
+	f.index.deletionJobs[job.ID] = job
 	return nil
 }
 
+// GetDeletionJobs - вернет невыполненные задания на удаление в порядке создания
+func (f *fileDB) GetDeletionJobs(ctx context.Context) ([]models.DeletionJob, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.index.deletionJobsList(), nil
+}
+
+// CompleteDeletionJobs - отмечает задания выполненными: пишет в журнал отметку по каждому и убирает их из индекса
+func (f *fileDB) CompleteDeletionJobs(ctx context.Context, ids []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := f.index.deletionJobs[id]; !ok {
+			continue
+		}
+		if err := f.log.write(&entry{Op: opDeletionDone, DeletionJob: &models.DeletionJob{ID: id}}); err != nil {
+			return unavailable(err)
+		}
+		delete(f.index.deletionJobs, id)
+		f.index.garbage += 2
+	}
+	return nil
+}
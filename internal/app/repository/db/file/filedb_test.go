@@ -0,0 +1,364 @@
+package filedb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+func openTestDB(t *testing.T, name string) *fileDB {
+	db, err := NewFileDB(name, Options{SyncPolicy: SyncAlways})
+	require.NoError(t, err)
+	return db
+}
+
+func TestFileDB_Reopen(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	past := time.Now().Add(-time.Minute)
+
+	user := models.NewUser("user", time.Now())
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.CreateUser(ctx, user))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/old", OriginURL: "https://example.com/3", UserID: "user", ExpiresAt: &past}))
+
+	id, err := db.GetShortURLByIdentityPath(ctx, "abc", "user")
+	require.NoError(t, err)
+	require.NoError(t, db.URLBulkDelete(ctx, []int{id}))
+	deleted, err := db.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	require.NoError(t, db.Close())
+
+	// Индекс после рестарта восстанавливается из журнала вместе с tombstone
+	db = openTestDB(t, name)
+	defer db.Close()
+	_, err = db.Get(ctx, "http://localhost/abc", "")
+	assert.True(t, errors.Is(err, models.ErrDeleted))
+	_, err = db.Get(ctx, "http://localhost/old", "")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+	origin, err := db.Get(ctx, "http://localhost/def", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/2", origin)
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	got, err := db.GetUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+}
+
+func TestFileDB_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	key := models.APIKey{ID: "key", UserID: "user", Hash: models.HashAPIKey("secret"), Scopes: []string{models.ScopeRead}, CreatedAt: time.Now().UTC()}
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.CreateAPIKey(ctx, key))
+	assert.True(t, errors.Is(db.CreateAPIKey(ctx, key), models.ErrConflict))
+	assert.True(t, errors.Is(db.RevokeAPIKey(ctx, "user-2", "key", time.Now()), models.ErrNotFound))
+	require.NoError(t, db.RevokeAPIKey(ctx, "user", "key", time.Now()))
+	require.NoError(t, db.Close())
+
+	// Отзыв ключа переживает рестарт и компакцию
+	db = openTestDB(t, name)
+	got, err := db.GetAPIKey(ctx, models.HashAPIKey("secret"))
+	require.NoError(t, err)
+	assert.True(t, got.Revoked())
+	assert.Equal(t, 1, db.index.garbage)
+	require.NoError(t, db.compact())
+	require.NoError(t, db.Close())
+
+	db = openTestDB(t, name)
+	defer db.Close()
+	keys, err := db.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].Revoked())
+	_, err = db.GetAPIKey(ctx, models.HashAPIKey("other"))
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+func TestFileDB_Moderation(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("user-2", time.Now())))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"}))
+	require.NoError(t, db.SetURLBlocked(ctx, "http://localhost/abc", true))
+	assert.True(t, errors.Is(db.TransferURL(ctx, "http://localhost/def", "nobody"), models.ErrNotFound))
+	require.NoError(t, db.TransferURL(ctx, "http://localhost/def", "user-2"))
+	id, err := db.GetShortURLByIdentityPath(ctx, "def", "user-2")
+	require.NoError(t, err)
+	require.NoError(t, db.URLBulkDelete(ctx, []int{id}))
+	require.NoError(t, db.RestoreURL(ctx, "http://localhost/def"))
+	assert.True(t, errors.Is(db.RestoreURL(ctx, "http://localhost/xyz"), models.ErrNotFound))
+	require.NoError(t, db.Close())
+
+	// Решения модератора переживают рестарт и компакцию
+	for i := 0; i < 2; i++ {
+		db = openTestDB(t, name)
+		_, err = db.Get(ctx, "http://localhost/abc", "")
+		assert.True(t, errors.Is(err, models.ErrBlocked))
+		_, err = db.Get(ctx, "http://localhost/def", "")
+		assert.NoError(t, err)
+		found, err := db.SearchURLs(ctx, models.URLFilter{UserID: "user-2"})
+		require.NoError(t, err)
+		assert.Equal(t, []models.AdminRecord{{Record: models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user-2"}}}, found)
+		require.NoError(t, db.compact())
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestFileDB_Compact(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	past := time.Now().Add(-time.Minute)
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	for _, short := range []string{"http://localhost/a", "http://localhost/b", "http://localhost/c"} {
+		require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: short, OriginURL: short, UserID: "user", ExpiresAt: &past}))
+	}
+	_, err := db.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+	before, err := os.Stat(name)
+	require.NoError(t, err)
+
+	require.NoError(t, db.compact())
+	after, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+	assert.Equal(t, 0, db.index.garbage)
+
+	// Журнал после компакции продолжает принимать записи
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"}))
+	require.NoError(t, db.Close())
+
+	db = openTestDB(t, name)
+	defer db.Close()
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 0, db.index.garbage)
+}
+
+func TestFileDB_LegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	// Старый формат JSON строк, последняя строка оборвана при падении
+	legacy := `{"short_url":"http://localhost/abc","original_url":"https://example.com/1","token":"user"}` + "\n" +
+		`{"short_url":"http://localhost/de`
+	require.NoError(t, os.WriteFile(name, []byte(legacy), 0644))
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"}))
+	require.NoError(t, db.Close())
+
+	// Файл переписан в новый формат: пользователь и две ссылки
+	report, err := recoverFile(name)
+	require.NoError(t, err)
+	assert.False(t, report.legacy)
+	assert.Equal(t, 3, report.records)
+
+	db = openTestDB(t, name)
+	defer db.Close()
+	origin, err := db.Get(ctx, "http://localhost/abc", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/1", origin)
+	// Владелец из поля token стал пользователем
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	_, err = db.GetUser(ctx, "user")
+	assert.NoError(t, err)
+}
+
+func TestFileDB_DeletionJobs(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	now := time.Now().UTC()
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.AddDeletionJob(ctx, models.DeletionJob{ID: "job-1", UserID: "user", Identities: []string{"abc"}, CreatedAt: now}))
+	require.NoError(t, db.AddDeletionJob(ctx, models.DeletionJob{ID: "job-2", UserID: "user", Identities: []string{"def", "xyz"}, CreatedAt: now.Add(time.Second)}))
+	assert.True(t, errors.Is(db.AddDeletionJob(ctx, models.DeletionJob{ID: "job-1"}), models.ErrConflict))
+	require.NoError(t, db.CompleteDeletionJobs(ctx, []string{"job-1", "unknown"}))
+	require.NoError(t, db.Close())
+
+	// Невыполненное задание переживает рестарт и компакцию, выполненное - нет
+	for i := 0; i < 2; i++ {
+		db = openTestDB(t, name)
+		jobs, err := db.GetDeletionJobs(ctx)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "job-2", jobs[0].ID)
+		assert.Equal(t, []string{"def", "xyz"}, jobs[0].Identities)
+		require.NoError(t, db.compact())
+		assert.Equal(t, 0, db.index.garbage)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestFileDB_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	now := time.Now().UTC()
+
+	db := openTestDB(t, name)
+	_, _, err := db.Upsert(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	_, _, err = db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"},
+		{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/3", UserID: "user"},
+	}, "user", now, nil)
+	var collision *models.CollisionError
+	require.True(t, errors.As(err, &collision))
+	// Тот же URL у другого владельца дедупликации, а short занят чужой ссылкой - коллизия
+	_, _, err = db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user-2"},
+	}, "user-2", now, nil)
+	require.True(t, errors.As(err, &collision))
+
+	key := func(saved []models.Record, created []bool) (*models.IdempotencyKey, error) {
+		return &models.IdempotencyKey{UserID: "user", Key: "key", RequestHash: "hash", Response: []byte("[]"), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, nil
+	}
+	saved, created, err := db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/new", OriginURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"},
+	}, "user", now, key)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, created)
+	assert.Equal(t, "http://localhost/abc", saved[0].ShortURL)
+	_, _, err = db.AddBatch(ctx, nil, "user", now, key)
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	require.NoError(t, db.Close())
+
+	// Пачка, ее дедупликация и ключ переживают рестарт и компакцию
+	for i := 0; i < 2; i++ {
+		db = openTestDB(t, name)
+		records, err := db.GetUserURL(ctx, "user")
+		require.NoError(t, err)
+		assert.Len(t, records, 2)
+		exist, created, err := db.Upsert(ctx, models.Record{ShortURL: "http://localhost/xyz", OriginURL: "https://example.com/2", UserID: "user"}, "user", now)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "http://localhost/def", exist.ShortURL)
+		savedKey, err := db.GetIdempotencyKey(ctx, "user", "key", now)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("[]"), savedKey.Response)
+		// Мусора в журнале нет, поэтому переписываем его напрямую
+		db.mu.Lock()
+		require.NoError(t, db.rewrite())
+		db.mu.Unlock()
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestFileDB_Upsert(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	db := openTestDB(t, name)
+	record := models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}
+	saved, created, err := db.Upsert(ctx, record, "user", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, record, saved)
+
+	// Тот же URL у того же владельца дедупликации - существующая ссылка, у другого - новая
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, record, saved)
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/1", UserID: "user-2"}, "user-2", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	_, _, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/2", UserID: "user"}, "user", now)
+	var collision *models.CollisionError
+	assert.True(t, errors.As(err, &collision))
+
+	// Истекшую ссылку заменяет новая, удаленная выходит из дедупликации
+	_, _, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/old", OriginURL: "https://example.com/3", UserID: "user", ExpiresAt: &past}, "", now)
+	require.NoError(t, err)
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/new", OriginURL: "https://example.com/3", UserID: "user-2"}, "", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	id, err := db.GetShortURLByIdentityPath(ctx, "def", "user-2")
+	require.NoError(t, err)
+	require.NoError(t, db.URLBulkDelete(ctx, []int{id}))
+	require.NoError(t, db.Close())
+
+	// После рестарта дедупликация восстанавливается из журнала
+	db = openTestDB(t, name)
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/xyz", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "http://localhost/abc", saved.ShortURL)
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/xyz", OriginURL: "https://example.com/3", UserID: "user"}, "", now)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "http://localhost/new", saved.ShortURL)
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/xyz", OriginURL: "https://example.com/1", UserID: "user-2"}, "user-2", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Компакция сохраняет владельцев дедупликации
+	db.mu.Lock()
+	require.NoError(t, db.rewrite())
+	db.mu.Unlock()
+	require.NoError(t, db.Close())
+	db = openTestDB(t, name)
+	defer db.Close()
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/qwe", OriginURL: "https://example.com/1", UserID: "user-2"}, "user-2", now)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "http://localhost/xyz", saved.ShortURL)
+}
+
+func TestFileDB_ClickStats(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db.json")
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	db := openTestDB(t, name)
+	require.NoError(t, db.AddClicks(ctx, []models.Click{
+		{ShortURL: "http://localhost/abc", Time: day, VisitorID: "a"},
+		{ShortURL: "http://localhost/abc", Time: day, VisitorID: "a"},
+		{ShortURL: "http://localhost/def", Time: day, VisitorID: "a"},
+	}))
+	require.NoError(t, db.AddClicks(ctx, []models.Click{{ShortURL: "http://localhost/abc", Time: day.Add(24 * time.Hour), VisitorID: "b"}}))
+	want := models.LinkStats{ShortURL: "http://localhost/abc", Clicks: 3, UniqueVisitors: 2, Daily: []models.DailyClicks{
+		{Date: "2024-05-01", Clicks: 2, UniqueVisitors: 1},
+		{Date: "2024-05-02", Clicks: 1, UniqueVisitors: 1},
+	}}
+	stats, err := db.GetClickStats(ctx, "http://localhost/abc")
+	require.NoError(t, err)
+	assert.Equal(t, want, stats)
+	require.NoError(t, db.Close())
+
+	// Агрегаты после рестарта строятся из файла переходов
+	db = openTestDB(t, name)
+	defer db.Close()
+	stats, err = db.GetClickStats(ctx, "http://localhost/abc")
+	require.NoError(t, err)
+	assert.Equal(t, want, stats)
+	stats, err = db.GetClickStats(ctx, "http://localhost/none")
+	require.NoError(t, err)
+	assert.Equal(t, models.LinkStats{ShortURL: "http://localhost/none", Daily: []models.DailyClicks{}}, stats)
+}
@@ -0,0 +1,240 @@
+package filedb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// Формат файла: fileMagic и последовательность записей
+//		[длина payload: uint32 BE][CRC32-C payload: uint32 BE][CRC32-C первых 8 байт заголовка: uint32 BE][payload: JSON]
+// Запись уходит в файл одним Write, поэтому при падении может оборваться только хвост файла.
+// Длина под своим CRC: испорченную длину в середине файла не спутать с оборванной последней записью.
+// Файлы без fileMagic - записи без CRC заголовка (v1) или JSON строки, их читаем и сразу переписываем.
+
+const (
+	// fileMagic - начало файла в текущем формате
+	fileMagic       = "SHURLDB2"
+	frameHeaderSize = 12
+	// v1FrameHeaderSize - заголовок записи v1: длина и CRC payload, без CRC заголовка
+	v1FrameHeaderSize = 8
+	// maxFrameSize - больше не бывает даже у пачки ссылок с длинными URL, такая длина значит что заголовок испорчен
+	maxFrameSize = 16 << 20
+)
+
+// Форматы файла, см. detectFormat
+const (
+	formatFrames   = iota // fileMagic и записи с CRC заголовка
+	formatFramesV1        // записи без CRC заголовка, до появления fileMagic
+	formatJSON            // JSON строки
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// errChecksum - запись прочитана целиком, но CRC payload не совпал. Следующая запись читается как обычно
+	errChecksum = errors.New("frame checksum mismatch")
+	// errFrameHeader - заголовок испорчен, границу следующей записи найти нельзя
+	errFrameHeader = errors.New("invalid frame header")
+)
+
+// writeFrame - пишет payload с заголовком одним Write
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	binary.BigEndian.PutUint32(frame[8:12], crc32.Checksum(frame[0:8], crcTable))
+	copy(frame[frameHeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame - читает одну запись в формате format: formatFrames или formatFramesV1.
+//			   io.EOF - файл закончился ровно на границе записи, io.ErrUnexpectedEOF - запись оборвана
+func readFrame(r io.Reader, format int) ([]byte, error) {
+	headerSize := frameHeaderSize
+	if format == formatFramesV1 {
+		headerSize = v1FrameHeaderSize
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if format != formatFramesV1 && crc32.Checksum(header[0:8], crcTable) != binary.BigEndian.Uint32(header[8:12]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errFrameHeader)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length == 0 || length > maxFrameSize {
+		return nil, fmt.Errorf("%w: length %d", errFrameHeader, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errChecksum
+	}
+	return payload, nil
+}
+
+// detectFormat - формат файла по его началу, fileMagic из r вычитывает. Пустой файл - formatFrames
+func detectFormat(r *bufio.Reader) (int, error) {
+	b, err := r.Peek(len(fileMagic))
+	switch {
+	case string(b) == fileMagic:
+		_, err = r.Discard(len(fileMagic))
+		return formatFrames, err
+	case len(b) == 0 && err == io.EOF:
+		return formatFrames, nil
+	case len(b) != 0 && b[0] == '{':
+		return formatJSON, nil
+	case err != nil && err != io.EOF:
+		return 0, err
+	}
+	return formatFramesV1, nil
+}
+
+// recoveryReport - итог проверки файла при старте
+type recoveryReport struct {
+	// records - целые записи
+	records int
+	// corrupted - записи с неверным CRC в середине файла, при чтении пропускаются
+	corrupted int
+	// truncated - сколько байт оборванной записи отрезано с конца файла
+	truncated int64
+	// legacy - файл в старом формате (JSON строки или записи v1), его нужно переписать
+	legacy bool
+}
+
+// countingReader - считает прочитанные байты, чтобы знать смещение начала каждой записи
+type countingReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+// recoverFile - проходит по всем записям файла и отрезает оборванный при падении хвост.
+//				 Хвостом считаем неполную или испорченную запись, после которой в файле нет ни одной целой записи:
+//				 оборванный заголовок или payload, неверный CRC последней записи, заполненный нулями остаток
+//				 (место выделено, но данные не успели записаться). Если после испорченной записи есть целые,
+//				 это порча в середине файла: ничего не отрезаем и возвращаем ошибку.
+func recoverFile(name string) (recoveryReport, error) {
+	var report recoveryReport
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0777)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+	size := info.Size()
+
+	reader := bufio.NewReader(file)
+	format, err := detectFormat(reader)
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case format == formatJSON:
+		report.legacy = true
+		return report, nil
+	case format == formatFramesV1 && size < int64(len(fileMagic)) && strings.HasPrefix(fileMagic, string(peek(reader, int(size)))):
+		// Падение во время записи fileMagic нового файла
+		return report, truncate(file, &report, 0, size)
+	}
+	report.legacy = format == formatFramesV1
+	cr := &countingReader{r: reader}
+	if format == formatFrames {
+		cr.offset = int64(len(fileMagic))
+	}
+	for {
+		start := cr.offset
+		_, err := readFrame(cr, format)
+		switch {
+		case err == nil:
+			report.records++
+			continue
+		case err == io.EOF:
+			return report, nil
+		case errors.Is(err, errChecksum) && cr.offset < size:
+			report.corrupted++
+			continue
+		case err != io.ErrUnexpectedEOF && !errors.Is(err, errChecksum) && !errors.Is(err, errFrameHeader):
+			return report, err
+		}
+		// Испорченная запись: хвост, только если за ней нет целых записей
+		next, err := nextFrame(file, start, size, format)
+		if err != nil {
+			return report, err
+		}
+		if next >= 0 {
+			return report, fmt.Errorf("corrupted frame at offset %d, valid frame follows at offset %d", start, next)
+		}
+		return report, truncate(file, &report, start, size)
+	}
+}
+
+// truncate - отрезает хвост файла с offset
+func truncate(file *os.File, report *recoveryReport, offset int64, size int64) error {
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	report.truncated = size - offset
+	return file.Sync()
+}
+
+// peek - первые n байт, уже прочитанные в буфер r
+func peek(r *bufio.Reader, n int) []byte {
+	b, _ := r.Peek(n)
+	return b
+}
+
+// nextFrame - смещение первой целой записи после испорченной записи с offset, -1 если таких нет.
+//			   Проверяем каждое смещение: остаток файла читается в память, но только при порче
+func nextFrame(file *os.File, offset int64, size int64, format int) (int64, error) {
+	data := make([]byte, size-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i := 1; i < len(data); i++ {
+		if isFrame(data[i:], format) {
+			return offset + int64(i), nil
+		}
+	}
+	return -1, nil
+}
+
+// isFrame - data начинается с целой записи, как ее проверил бы readFrame
+func isFrame(data []byte, format int) bool {
+	headerSize := frameHeaderSize
+	if format == formatFramesV1 {
+		headerSize = v1FrameHeaderSize
+	}
+	if len(data) < headerSize {
+		return false
+	}
+	if format != formatFramesV1 && crc32.Checksum(data[0:8], crcTable) != binary.BigEndian.Uint32(data[8:12]) {
+		return false
+	}
+	length := binary.BigEndian.Uint32(data[0:4])
+	if length == 0 || length > maxFrameSize || int(length) > len(data)-headerSize {
+		return false
+	}
+	payload := data[headerSize : headerSize+int(length)]
+	return crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(data[4:8])
+}
@@ -0,0 +1,222 @@
+package filedb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// writeTestRecords - пишет count ссылок через БД и возвращает содержимое файла и смещения начала записей
+func writeTestRecords(t *testing.T, name string, count int) ([]byte, []int) {
+	ctx := context.Background()
+	db := openTestDB(t, name)
+	for i := 0; i < count; i++ {
+		short := fmt.Sprintf("http://localhost/%d", i)
+		require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: short, OriginURL: short, UserID: "user"}))
+	}
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, fileMagic, string(data[:len(fileMagic)]))
+	var offsets []int
+	r := bytes.NewReader(data[len(fileMagic):])
+	for {
+		offset := len(data) - r.Len()
+		if _, err := readFrame(r, formatFrames); err == io.EOF {
+			break
+		}
+		offsets = append(offsets, offset)
+	}
+	require.Len(t, offsets, count)
+	return data, offsets
+}
+
+func TestRecoverFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// crash - портит содержимое файла так, как его оставило бы падение
+		crash         func(data []byte, offsets []int) []byte
+		wantRecords   []int
+		wantCorrupted int
+		wantTruncated bool
+	}{
+		{
+			name: "torn header",
+			crash: func(data []byte, offsets []int) []byte {
+				return append(data, data[offsets[1]:offsets[1]+5]...)
+			},
+			wantRecords:   []int{0, 1, 2},
+			wantTruncated: true,
+		},
+		{
+			name: "torn payload",
+			crash: func(data []byte, offsets []int) []byte {
+				return data[:len(data)-3]
+			},
+			wantRecords:   []int{0, 1},
+			wantTruncated: true,
+		},
+		{
+			name: "zero filled tail",
+			crash: func(data []byte, offsets []int) []byte {
+				return append(data, make([]byte, 64)...)
+			},
+			wantRecords:   []int{0, 1, 2},
+			wantTruncated: true,
+		},
+		{
+			name: "last record not persisted",
+			crash: func(data []byte, offsets []int) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			wantRecords:   []int{0, 1},
+			wantTruncated: true,
+		},
+		{
+			name: "corrupted record in the middle",
+			crash: func(data []byte, offsets []int) []byte {
+				data[offsets[1]+frameHeaderSize+2] ^= 0xff
+				return data
+			},
+			wantRecords:   []int{0, 2},
+			wantCorrupted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			name := filepath.Join(t.TempDir(), "db")
+			data, offsets := writeTestRecords(t, name, 3)
+			require.NoError(t, os.WriteFile(name, tt.crash(data, offsets), 0644))
+
+			report, err := recoverFile(name)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCorrupted, report.corrupted)
+			assert.Equal(t, tt.wantTruncated, report.truncated > 0)
+
+			// После восстановления файл читается, а новые записи не теряются за испорченным хвостом
+			db := openTestDB(t, name)
+			require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/new", OriginURL: "https://example.com", UserID: "user"}))
+			require.NoError(t, db.Close())
+			db = openTestDB(t, name)
+			defer db.Close()
+			for i := 0; i < 3; i++ {
+				short := fmt.Sprintf("http://localhost/%d", i)
+				_, err := db.Get(ctx, short, "")
+				if contains(tt.wantRecords, i) {
+					assert.NoError(t, err, short)
+				} else {
+					assert.True(t, errors.Is(err, models.ErrNotFound), short)
+				}
+			}
+			_, err = db.Get(ctx, "http://localhost/new", "")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRecoverFile_CorruptedHeader(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	data, offsets := writeTestRecords(t, name, 3)
+	// Длина записи в середине файла испорчена: где начинается следующая запись неизвестно
+	data[offsets[1]] = 0xff
+	require.NoError(t, os.WriteFile(name, data, 0644))
+
+	_, err := NewFileDB(name, Options{})
+	assert.Error(t, err)
+}
+
+func TestRecoverFile_CorruptedLength(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	data, offsets := writeTestRecords(t, name, 5)
+	// Один бит в длине первой записи: она "выходит" за конец файла, но это не оборванный хвост
+	data[offsets[0]+1] ^= 0x01
+	require.NoError(t, os.WriteFile(name, data, 0644))
+
+	_, err := recoverFile(name)
+	assert.Error(t, err)
+	_, err = NewFileDB(name, Options{})
+	assert.Error(t, err)
+	// Файл не тронут, данные можно восстановить вручную
+	after, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, data, after)
+}
+
+func TestRecoverFile_TornMagic(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, os.WriteFile(name, []byte(fileMagic[:3]), 0644))
+
+	report, err := recoverFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), report.truncated)
+	db := openTestDB(t, name)
+	assert.NoError(t, db.Close())
+}
+
+// writeV1Frame - запись в формате до fileMagic: заголовок без CRC
+func writeV1Frame(t *testing.T, buf *bytes.Buffer, e entry) {
+	payload, err := json.Marshal(e)
+	require.NoError(t, err)
+	header := make([]byte, v1FrameHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	buf.Write(header)
+	buf.Write(payload)
+}
+
+func TestFileDB_V1Frames(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "db")
+	var buf bytes.Buffer
+	var offsets []int
+	for i := 0; i < 3; i++ {
+		offsets = append(offsets, buf.Len())
+		short := fmt.Sprintf("http://localhost/%d", i)
+		writeV1Frame(t, &buf, entry{Record: models.Record{ShortURL: short, OriginURL: short, UserID: "user"}})
+	}
+	data := buf.Bytes()
+
+	// Испорченная длина в середине файла v1 тоже не считается хвостом
+	corrupted := append([]byte(nil), data...)
+	corrupted[offsets[1]+1] ^= 0x01
+	require.NoError(t, os.WriteFile(name, corrupted, 0644))
+	_, err := NewFileDB(name, Options{})
+	assert.Error(t, err)
+
+	// Оборванный хвост отрезается, файл переписывается в текущий формат
+	require.NoError(t, os.WriteFile(name, data[:len(data)-3], 0644))
+	db := openTestDB(t, name)
+	for i := 0; i < 2; i++ {
+		_, err = db.Get(ctx, fmt.Sprintf("http://localhost/%d", i), "")
+		assert.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+	after, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, fileMagic, string(after[:len(fileMagic)]))
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
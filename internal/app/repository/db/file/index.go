@@ -0,0 +1,411 @@
+package filedb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// Индекс журнала в памяти: состояние всех ссылок, восстановленное из файла при старте
+
+// Операции журнала
+const (
+	// opDelete - пользователь удалил ссылку, Get вернет ErrDeleted
+	opDelete = "delete"
+	// opPurge - ссылка удалена насовсем, например по истечении срока жизни
+	opPurge = "purge"
+	// opUser - новый пользователь в поле User
+	opUser = "user"
+	// opBlock, opUnblock - модератор заблокировал или разблокировал ссылку
+	opBlock   = "block"
+	opUnblock = "unblock"
+	// opRestore - модератор снял с ссылки пометку удаленной
+	opRestore = "restore"
+	// opTransfer - ссылка передана пользователю из поля UserID
+	opTransfer = "transfer"
+	// opAPIKey - API ключ в поле APIKey, следующая запись того же ключа (отзыв) заменяет предыдущую
+	opAPIKey = "api_key"
+	// opDeletionJob - задание на удаление ссылок в поле DeletionJob, opDeletionDone - задание с этим ID выполнено
+	opDeletionJob  = "deletion_job"
+	opDeletionDone = "deletion_done"
+	// opBatch - пачка новых ссылок в поле Batch и ключ идемпотентности в поле IdempotencyKey одной записью:
+	//			 при падении пачка теряется целиком, а не частично
+	opBatch = "batch"
+	// opIdempotencyKey - ключ идемпотентности в поле IdempotencyKey, пишется при компакции
+	opIdempotencyKey = "idempotency_key"
+	// opSequence - новое значение счетчика в поле Sequence, следующая запись того же счетчика заменяет предыдущую
+	opSequence = "sequence"
+)
+
+// entry - строка журнала. Без Op это добавление ссылки,
+//			 поэтому файлы в старом формате из одних models.Record читаются как есть
+type entry struct {
+	models.Record
+	Op          string              `json:"op,omitempty"`
+	Deleted     bool                `json:"deleted,omitempty"`
+	Blocked     bool                `json:"blocked,omitempty"`
+	User        *models.User        `json:"user,omitempty"`
+	APIKey      *models.APIKey      `json:"api_key,omitempty"`
+	DeletionJob *models.DeletionJob `json:"deletion_job,omitempty"`
+	Batch       []models.Record     `json:"batch,omitempty"`
+	// IdempotencyKey - ключ идемпотентности запроса, сохранившего пачку
+	IdempotencyKey *models.IdempotencyKey `json:"idempotency_key,omitempty"`
+	// Sequence - счетчик для opSequence
+	Sequence *sequence `json:"sequence,omitempty"`
+	// DedupOwner - ссылка (или все ссылки opBatch) добавлена через Upsert и отвечает за свой URL у этого владельца дедупликации
+	DedupOwner *string `json:"dedup_owner,omitempty"`
+	// LegacyToken - владелец ссылки в файлах до появления пользователей, теперь это его ID
+	LegacyToken string `json:"token,omitempty"`
+}
+
+// sequence - последнее зарезервированное значение счетчика, см. fileDB.ReserveSequence
+type sequence struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// indexEntry - текущее состояние одной ссылки
+type indexEntry struct {
+	id      int
+	record  models.Record
+	deleted bool
+	blocked bool
+	// dedupOwner - владелец дедупликации, за которого ссылка отвечает, nil - не участвует
+	dedupOwner *string
+}
+
+type index struct {
+	// nextID - id выдаются по порядку записей в журнале и живут до рестарта
+	nextID     int
+	byShort    map[string]*indexEntry
+	byID       map[int]*indexEntry
+	byIdentity map[string]*indexEntry
+	byOwner    map[string]map[string]*indexEntry
+	// byDedup - ссылка, которую Upsert вернет для владельца дедупликации и URL
+	byDedup    map[dedupID]*indexEntry
+	users      map[string]models.User
+	apiKeys    map[string]models.APIKey
+	// apiKeyByHash - ID ключа по его хэшу
+	apiKeyByHash map[string]string
+	// deletionJobs - невыполненные задания на удаление по ID
+	deletionJobs map[string]models.DeletionJob
+	// idempotencyKeys - ключи идемпотентности по пользователю и ключу, истекшие убирает DeleteIdempotencyKeys
+	idempotencyKeys map[idempotencyID]models.IdempotencyKey
+	// sequences - последнее зарезервированное значение счетчика по имени
+	sequences map[string]uint64
+	// garbage - строки журнала, без которых индекс восстанавливается так же: повод для компакции
+	garbage int
+}
+
+func newIndex() *index {
+	return &index{
+		byShort:         map[string]*indexEntry{},
+		byID:            map[int]*indexEntry{},
+		byIdentity:      map[string]*indexEntry{},
+		byOwner:         map[string]map[string]*indexEntry{},
+		byDedup:         map[dedupID]*indexEntry{},
+		users:           map[string]models.User{},
+		apiKeys:         map[string]models.APIKey{},
+		apiKeyByHash:    map[string]string{},
+		deletionJobs:    map[string]models.DeletionJob{},
+		idempotencyKeys: map[idempotencyID]models.IdempotencyKey{},
+		sequences:       map[string]uint64{},
+	}
+}
+
+// idempotencyID - ключ идемпотентности уникален в рамках пользователя
+type idempotencyID struct {
+	userID string
+	key    string
+}
+
+// dedupID - владелец дедупликации (ID пользователя или "" для всех) и оригинальный URL
+type dedupID struct {
+	owner  string
+	origin string
+}
+
+// apply - применяет строку журнала к индексу
+func (i *index) apply(e *entry) {
+	ie, ok := i.byShort[e.ShortURL]
+	switch e.Op {
+	case opDelete:
+		// Удаленная ссылка выходит из дедупликации и после восстановления в нее не возвращается
+		if ok {
+			ie.deleted = true
+			i.release(ie)
+		}
+		i.garbage++
+	case opPurge:
+		if ok {
+			i.remove(ie)
+			i.garbage++
+		}
+		i.garbage++
+	case opBlock, opUnblock:
+		if ok {
+			ie.blocked = e.Op == opBlock
+		}
+		i.garbage++
+	case opRestore:
+		if ok {
+			ie.deleted = false
+		}
+		i.garbage++
+	case opTransfer:
+		if ok {
+			i.transfer(ie, e.UserID)
+		}
+		i.garbage++
+	case opUser:
+		if e.User != nil {
+			i.users[e.User.ID] = *e.User
+		}
+	case opAPIKey:
+		if e.APIKey != nil {
+			if _, ok := i.apiKeys[e.APIKey.ID]; ok {
+				i.garbage++
+			}
+			i.putAPIKey(*e.APIKey)
+		}
+	case opDeletionJob:
+		if e.DeletionJob != nil {
+			i.deletionJobs[e.DeletionJob.ID] = *e.DeletionJob
+		}
+	case opDeletionDone:
+		// Выполненное задание и отметка о нем при компакции не переносятся
+		if e.DeletionJob != nil {
+			if _, ok := i.deletionJobs[e.DeletionJob.ID]; ok {
+				delete(i.deletionJobs, e.DeletionJob.ID)
+				i.garbage++
+			}
+		}
+		i.garbage++
+	case opBatch:
+		// Ссылки пачки уже проверены на коллизии при записи, существующие пропускаем.
+		// Пачки до дедупликации записаны без DedupOwner и в ней не участвуют
+		for _, record := range e.Batch {
+			if _, ok := i.byShort[record.ShortURL]; ok {
+				continue
+			}
+			ie := i.put(record, false)
+			if e.DedupOwner != nil {
+				i.dedup(ie, *e.DedupOwner)
+			}
+		}
+		if e.IdempotencyKey != nil {
+			i.putIdempotencyKey(*e.IdempotencyKey)
+		}
+	case opIdempotencyKey:
+		if e.IdempotencyKey != nil {
+			i.putIdempotencyKey(*e.IdempotencyKey)
+		}
+	case opSequence:
+		if e.Sequence != nil {
+			if _, ok := i.sequences[e.Sequence.Name]; ok {
+				i.garbage++
+			}
+			i.sequences[e.Sequence.Name] = e.Sequence.Value
+		}
+	default:
+		if ok {
+			i.garbage++
+			return
+		}
+		if len(e.UserID) == 0 {
+			e.UserID = e.LegacyToken
+		}
+		// У ссылок из старых файлов нет записи пользователя, заводим его по владельцу
+		if _, ok := i.users[e.UserID]; !ok {
+			i.users[e.UserID] = models.User{ID: e.UserID, Status: models.UserActive}
+		}
+		ie = i.put(e.Record, e.Deleted)
+		ie.blocked = e.Blocked
+		if e.DedupOwner != nil && !e.Deleted {
+			i.dedup(ie, *e.DedupOwner)
+		}
+	}
+}
+
+// put - добавляет ссылку во все индексы
+func (i *index) put(record models.Record, deleted bool) *indexEntry {
+	i.nextID++
+	ie := &indexEntry{id: i.nextID, record: record, deleted: deleted}
+	i.byShort[record.ShortURL] = ie
+	i.byID[ie.id] = ie
+	i.byIdentity[identityPath(record.ShortURL)] = ie
+	if _, ok := i.byOwner[record.UserID]; !ok {
+		i.byOwner[record.UserID] = map[string]*indexEntry{}
+	}
+	i.byOwner[record.UserID][record.ShortURL] = ie
+	return ie
+}
+
+// dedup - ссылка начинает отвечать за свой URL у owner, прежняя (истекшая) выходит из дедупликации
+func (i *index) dedup(ie *indexEntry, owner string) {
+	id := dedupID{owner, ie.record.OriginURL}
+	if prev, ok := i.byDedup[id]; ok {
+		prev.dedupOwner = nil
+	}
+	ie.dedupOwner = &owner
+	i.byDedup[id] = ie
+}
+
+// release - ссылка больше не участвует в дедупликации
+func (i *index) release(ie *indexEntry) {
+	if ie.dedupOwner == nil {
+		return
+	}
+	id := dedupID{*ie.dedupOwner, ie.record.OriginURL}
+	if i.byDedup[id] == ie {
+		delete(i.byDedup, id)
+	}
+	ie.dedupOwner = nil
+}
+
+// transfer - меняет владельца ссылки, переданная ссылка выходит из дедупликации
+func (i *index) transfer(ie *indexEntry, userID string) {
+	i.release(ie)
+	record := ie.record
+	delete(i.byOwner[record.UserID], record.ShortURL)
+	if len(i.byOwner[record.UserID]) == 0 {
+		delete(i.byOwner, record.UserID)
+	}
+	ie.record.UserID = userID
+	if _, ok := i.byOwner[userID]; !ok {
+		i.byOwner[userID] = map[string]*indexEntry{}
+	}
+	i.byOwner[userID][record.ShortURL] = ie
+}
+
+// putAPIKey - добавляет или заменяет API ключ
+func (i *index) putAPIKey(key models.APIKey) {
+	i.apiKeys[key.ID] = key
+	i.apiKeyByHash[key.Hash] = key.ID
+}
+
+// putIdempotencyKey - добавляет или заменяет ключ идемпотентности
+func (i *index) putIdempotencyKey(key models.IdempotencyKey) {
+	id := idempotencyID{key.UserID, key.Key}
+	if _, ok := i.idempotencyKeys[id]; ok {
+		i.garbage++
+	}
+	i.idempotencyKeys[id] = key
+}
+
+// idempotencyKeysList - все ключи идемпотентности в порядке создания
+func (i *index) idempotencyKeysList() []models.IdempotencyKey {
+	result := make([]models.IdempotencyKey, 0, len(i.idempotencyKeys))
+	for _, key := range i.idempotencyKeys {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].CreatedAt.Equal(result[b].CreatedAt) {
+			return result[a].Key < result[b].Key
+		}
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result
+}
+
+// remove - удаляет ссылку из всех индексов
+func (i *index) remove(ie *indexEntry) {
+	record := ie.record
+	delete(i.byShort, record.ShortURL)
+	delete(i.byID, ie.id)
+	if i.byIdentity[identityPath(record.ShortURL)] == ie {
+		delete(i.byIdentity, identityPath(record.ShortURL))
+	}
+	delete(i.byOwner[record.UserID], record.ShortURL)
+	if len(i.byOwner[record.UserID]) == 0 {
+		delete(i.byOwner, record.UserID)
+	}
+	i.release(ie)
+}
+
+// entries - все ссылки в порядке добавления, компакция сохраняет этот порядок
+func (i *index) entries() []*indexEntry {
+	result := make([]*indexEntry, 0, len(i.byID))
+	for _, ie := range i.byID {
+		result = append(result, ie)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].id < result[b].id
+	})
+	return result
+}
+
+// ownerEntries - копии ссылок пользователя в порядке добавления, их можно читать без блокировки
+func (i *index) ownerEntries(userID string) []indexEntry {
+	result := make([]indexEntry, 0, len(i.byOwner[userID]))
+	for _, ie := range i.byOwner[userID] {
+		result = append(result, *ie)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].id < result[b].id
+	})
+	return result
+}
+
+// sequencesList - все счетчики по имени
+func (i *index) sequencesList() []sequence {
+	result := make([]sequence, 0, len(i.sequences))
+	for name, value := range i.sequences {
+		result = append(result, sequence{Name: name, Value: value})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Name < result[b].Name
+	})
+	return result
+}
+
+// usersList - все пользователи в порядке регистрации
+func (i *index) usersList() []models.User {
+	result := make([]models.User, 0, len(i.users))
+	for _, user := range i.users {
+		result = append(result, user)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].CreatedAt.Equal(result[b].CreatedAt) {
+			return result[a].ID < result[b].ID
+		}
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result
+}
+
+// apiKeysList - все API ключи в порядке создания
+func (i *index) apiKeysList() []models.APIKey {
+	result := make([]models.APIKey, 0, len(i.apiKeys))
+	for _, key := range i.apiKeys {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].CreatedAt.Equal(result[b].CreatedAt) {
+			return result[a].ID < result[b].ID
+		}
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result
+}
+
+// deletionJobsList - невыполненные задания на удаление в порядке создания
+func (i *index) deletionJobsList() []models.DeletionJob {
+	result := make([]models.DeletionJob, 0, len(i.deletionJobs))
+	for _, job := range i.deletionJobs {
+		result = append(result, job)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].CreatedAt.Equal(result[b].CreatedAt) {
+			return result[a].ID < result[b].ID
+		}
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result
+}
+
+// identityPath - сокращенная часть url: все после последнего "/"
+func identityPath(shortURL string) string {
+	return shortURL[strings.LastIndex(shortURL, "/")+1:]
+}
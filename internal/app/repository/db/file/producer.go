@@ -1,33 +1,102 @@
 package filedb
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"os"
 )
 
 // Запись данных в файл
 
+// Политики fsync журнала
+const (
+	// SyncAlways - fsync после каждой записи, ничего не теряем при падении
+	SyncAlways = "always"
+	// SyncInterval - буферизуем записи и делаем fsync раз в Options.SyncInterval
+	SyncInterval = "interval"
+	// SyncNever - сбрасываем буфер только когда он заполнен и при закрытии, fsync оставляем ОС
+	SyncNever = "never"
+)
+
 type producer struct {
-	file    *os.File
-	encoder *json.Encoder
+	file   *os.File
+	writer *bufio.Writer
+	policy string
 }
 
-func newProducer(fileName string) (*producer, error) {
+func newProducer(fileName string, policy string) (*producer, error) {
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown file sync policy: %s", policy)
+	}
 	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
 	if err != nil {
 		return nil, err
 	}
-	return &producer{
-		file:    file,
-		encoder: json.NewEncoder(file),
-	}, nil
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	p := &producer{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		policy: policy,
+	}
+	// Новый файл начинается с fileMagic, он попадет на диск вместе с первой записью
+	if info.Size() == 0 {
+		if _, err = p.writer.WriteString(fileMagic); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
-func (p *producer) write(record *models.Record) error {
-	return p.encoder.Encode(&record)
+// encode - пишет v в буфер отдельной записью с длиной и CRC
+func (p *producer) encode(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(p.writer, payload)
+}
+
+func (p *producer) write(e *entry) error {
+	if err := p.encode(e); err != nil {
+		return err
+	}
+	if p.policy == SyncAlways {
+		return p.sync()
+	}
+	return nil
+}
+
+func (p *producer) writeClick(click *models.Click) error {
+	return p.encode(click)
+}
+
+// sync - сбрасывает буфер в файл и делает fsync
+func (p *producer) sync() error {
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	return p.file.Sync()
 }
 
 func (p *producer) close() error {
+	if err := p.writer.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if p.policy != SyncNever {
+		if err := p.file.Sync(); err != nil {
+			p.file.Close()
+			return err
+		}
+	}
 	return p.file.Close()
 }
@@ -4,91 +4,606 @@ import (
 	"context"
 	"fmt"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // InMemoryDB - БД для URL
 
 type URLInfo struct {
+	id int
+	shortURL string
 	longURL string
-	token string
+	userID string
+	expiresAt *time.Time
+	// rawURL - строка пользователя, если longURL - ее нормализованный вид
+	rawURL string
+	deleted bool
+	blocked bool
+	// dedup - ссылка отвечает за свой URL в дедупликации, nil - не участвует: алиасы, удаленные
+	dedup *dedupID
 }
 
+// inMemoryDB - все записи хранятся по shortURL, остальные map - вторичные индексы на те же записи.
+//				Любое изменение записи или индексов идет под mu.Lock, чтение под mu.RLock
 type inMemoryDB struct {
-	db map[string]URLInfo
+	mu sync.RWMutex
+	db map[string]*URLInfo
+	// nextID - аналог serial id в Postgres, нужен для URLBulkDelete
+	nextID int
+	byID map[int]*URLInfo
+	// byIdentity - по сокращенной части url (после последнего "/"), для удаления пользователем
+	byIdentity map[string]*URLInfo
+	// byOwner - ID пользователя -> shortURL -> запись
+	byOwner map[string]map[string]*URLInfo
+	// byDedup - ссылка, которую Upsert вернет для владельца дедупликации и URL
+	byDedup map[dedupID]*URLInfo
+	// clicks - переходы по коротким URL
+	clicks map[string][]models.Click
+	// users - пользователи по ID
+	users map[string]models.User
+	// apiKeys - API ключи по ID, apiKeyByHash - ID ключа по его хэшу
+	apiKeys map[string]models.APIKey
+	apiKeyByHash map[string]string
+	// deletionJobs - невыполненные задания на удаление по ID
+	deletionJobs map[string]models.DeletionJob
+	// idempotencyKeys - ключи идемпотентности по пользователю и ключу
+	idempotencyKeys map[idempotencyID]models.IdempotencyKey
+	// sequences - последнее зарезервированное значение счетчика по имени
+	sequences map[string]uint64
+}
+
+// idempotencyID - ключ идемпотентности уникален в рамках пользователя
+type idempotencyID struct {
+	userID string
+	key    string
+}
+
+// dedupID - владелец дедупликации (ID пользователя или "" для всех) и оригинальный URL
+type dedupID struct {
+	owner  string
+	origin string
 }
 
 
 func NewInMemoryDB() *inMemoryDB {
 	db := &inMemoryDB{
-		db: map[string]URLInfo{},
+		db: map[string]*URLInfo{},
+		byID: map[int]*URLInfo{},
+		byIdentity: map[string]*URLInfo{},
+		byOwner: map[string]map[string]*URLInfo{},
+		byDedup: map[dedupID]*URLInfo{},
+		clicks: map[string][]models.Click{},
+		users: map[string]models.User{},
+		apiKeys: map[string]models.APIKey{},
+		apiKeyByHash: map[string]string{},
+		deletionJobs: map[string]models.DeletionJob{},
+		idempotencyKeys: map[idempotencyID]models.IdempotencyKey{},
+		sequences: map[string]uint64{},
 	}
 	return db
 }
 
-// Add Добавляет новый url в БД
-func (u *inMemoryDB) Add(ctx context.Context, shortURL string, longURL string, token string) error {
-	u.db[shortURL] = URLInfo{longURL: longURL, token: token}
+// Close - данные живут только в памяти, закрывать нечего
+func (u *inMemoryDB) Close() error {
+	return nil
+}
+
+// AddAlias резервирует выбранный пользователем shortURL: добавляет запись если он свободен.
+//			Вернет *models.CollisionError если shortURL уже занят другим URL или другим пользователем
+func (u *inMemoryDB) AddAlias(ctx context.Context, record models.Record) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if urlInfo, ok := u.db[record.ShortURL]; ok {
+		if urlInfo.longURL != record.OriginURL || urlInfo.userID != record.UserID {
+			return &models.CollisionError{ShortURL: record.ShortURL}
+		}
+		return nil
+	}
+	u.nextID++
+	u.index(&URLInfo{
+		id: u.nextID,
+		shortURL: record.ShortURL,
+		longURL: record.OriginURL,
+		userID: record.UserID,
+		expiresAt: record.ExpiresAt,
+		rawURL: record.RawURL,
+	})
 	return nil
 }
 
+// Upsert добавляет запись, если у владельца дедупликации нет живой ссылки на тот же URL, иначе вернет ее.
+//		  Проверка и добавление идут под одним mu.Lock
+func (u *inMemoryDB) Upsert(ctx context.Context, record models.Record, dedupOwner string, now time.Time) (models.Record, bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	id := dedupID{dedupOwner, record.OriginURL}
+	if exist, ok := u.byDedup[id]; ok {
+		if !exist.record().Expired(now) {
+			return exist.record(), false, nil
+		}
+		// Истекшая ссылка больше не отвечает за свой URL
+		u.release(exist)
+	}
+	if _, ok := u.db[record.ShortURL]; ok {
+		return models.Record{}, false, &models.CollisionError{ShortURL: record.ShortURL}
+	}
+	u.nextID++
+	u.index(&URLInfo{
+		id: u.nextID,
+		shortURL: record.ShortURL,
+		longURL: record.OriginURL,
+		userID: record.UserID,
+		expiresAt: record.ExpiresAt,
+		rawURL: record.RawURL,
+		dedup: &id,
+	})
+	return record, true, nil
+}
+
+// AddBatch добавляет пачку записей и ключ идемпотентности: сначала проверяем всю пачку, потом пишем.
+//			Каждая запись проходит дедупликацию как в Upsert, повтор URL в пачке получает ту же ссылку.
+//			Вернет *models.CollisionError если shortURL новой записи занят в БД или в самой пачке
+func (u *inMemoryDB) AddBatch(ctx context.Context, records []models.Record, dedupOwner string, now time.Time, key models.IdempotencyKeyFunc) ([]models.Record, []bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	saved := make([]models.Record, len(records))
+	created := make([]bool, len(records))
+	// fresh - оригинальный URL -> новая запись пачки, taken - shortURL новых записей
+	fresh := map[string]int{}
+	taken := map[string]bool{}
+	for i, record := range records {
+		if exist, ok := u.byDedup[dedupID{dedupOwner, record.OriginURL}]; ok && !exist.record().Expired(now) {
+			saved[i] = exist.record()
+			continue
+		}
+		if j, ok := fresh[record.OriginURL]; ok {
+			saved[i] = saved[j]
+			continue
+		}
+		if _, ok := u.db[record.ShortURL]; ok || taken[record.ShortURL] {
+			return nil, nil, &models.CollisionError{ShortURL: record.ShortURL}
+		}
+		fresh[record.OriginURL] = i
+		taken[record.ShortURL] = true
+		saved[i], created[i] = record, true
+	}
+	var idempotencyKey *models.IdempotencyKey
+	if key != nil {
+		var err error
+		if idempotencyKey, err = key(saved, created); err != nil {
+			return nil, nil, err
+		}
+		if exist, ok := u.idempotencyKeys[idempotencyID{idempotencyKey.UserID, idempotencyKey.Key}]; ok && !exist.Expired(idempotencyKey.CreatedAt) {
+			return nil, nil, fmt.Errorf("idempotency key %s: %w", idempotencyKey.Key, models.ErrConflict)
+		}
+	}
+	for i, record := range saved {
+		if !created[i] {
+			continue
+		}
+		id := dedupID{dedupOwner, record.OriginURL}
+		// Истекшая ссылка больше не отвечает за свой URL
+		if exist, ok := u.byDedup[id]; ok {
+			u.release(exist)
+		}
+		u.nextID++
+		u.index(&URLInfo{
+			id: u.nextID,
+			shortURL: record.ShortURL,
+			longURL: record.OriginURL,
+			userID: record.UserID,
+			expiresAt: record.ExpiresAt,
+			rawURL: record.RawURL,
+			dedup: &id,
+		})
+	}
+	if idempotencyKey != nil {
+		u.idempotencyKeys[idempotencyID{idempotencyKey.UserID, idempotencyKey.Key}] = *idempotencyKey
+	}
+	return saved, created, nil
+}
+
+// GetIdempotencyKey вернет ключ идемпотентности пользователя, если он не истек
+func (u *inMemoryDB) GetIdempotencyKey(ctx context.Context, userID string, key string, now time.Time) (models.IdempotencyKey, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	exist, ok := u.idempotencyKeys[idempotencyID{userID, key}]
+	if !ok || exist.Expired(now) {
+		return models.IdempotencyKey{}, fmt.Errorf("idempotency key %s: %w", key, models.ErrNotFound)
+	}
+	return exist, nil
+}
+
+// DeleteIdempotencyKeys удаляет истекшие ключи идемпотентности
+func (u *inMemoryDB) DeleteIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var deleted int
+	for id, key := range u.idempotencyKeys {
+		if key.Expired(now) {
+			delete(u.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// index - добавляет запись в основную map и во все индексы, вызывать под mu.Lock
+func (u *inMemoryDB) index(urlInfo *URLInfo) {
+	u.db[urlInfo.shortURL] = urlInfo
+	u.byID[urlInfo.id] = urlInfo
+	u.byIdentity[identityPath(urlInfo.shortURL)] = urlInfo
+	if _, ok := u.byOwner[urlInfo.userID]; !ok {
+		u.byOwner[urlInfo.userID] = map[string]*URLInfo{}
+	}
+	u.byOwner[urlInfo.userID][urlInfo.shortURL] = urlInfo
+	if urlInfo.dedup != nil {
+		u.byDedup[*urlInfo.dedup] = urlInfo
+	}
+}
+
+// unindex - удаляет запись из основной map и всех индексов, вызывать под mu.Lock
+func (u *inMemoryDB) unindex(urlInfo *URLInfo) {
+	delete(u.db, urlInfo.shortURL)
+	delete(u.byID, urlInfo.id)
+	if u.byIdentity[identityPath(urlInfo.shortURL)] == urlInfo {
+		delete(u.byIdentity, identityPath(urlInfo.shortURL))
+	}
+	delete(u.byOwner[urlInfo.userID], urlInfo.shortURL)
+	if len(u.byOwner[urlInfo.userID]) == 0 {
+		delete(u.byOwner, urlInfo.userID)
+	}
+	if urlInfo.dedup != nil && u.byDedup[*urlInfo.dedup] == urlInfo {
+		delete(u.byDedup, *urlInfo.dedup)
+	}
+}
+
+// release - ссылка больше не участвует в дедупликации, вызывать под mu.Lock
+func (u *inMemoryDB) release(urlInfo *URLInfo) {
+	if urlInfo.dedup != nil && u.byDedup[*urlInfo.dedup] == urlInfo {
+		delete(u.byDedup, *urlInfo.dedup)
+	}
+	urlInfo.dedup = nil
+}
+
+// identityPath - сокращенная часть url: все после последнего "/"
+func identityPath(shortURL string) string {
+	return shortURL[strings.LastIndex(shortURL, "/")+1:]
+}
+
 // Get Достает из БД URL
-func (u *inMemoryDB) Get(ctx context.Context, shortURL string, token string) (string, error) {
+//	   Вернет models.ErrNotFound если URL нет, models.ErrBlocked если его заблокировал модератор,
+//	   models.ErrDeleted если он помечен удаленным и models.ErrExpired если истек срок его жизни
+func (u *inMemoryDB) Get(ctx context.Context, shortURL string, userID string) (string, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
 	urlInfo, ok := u.db[shortURL]
 	if !ok {
-		return "", fmt.Errorf("shorturl %s not found", shortURL)
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrNotFound)
+	}
+	if urlInfo.blocked {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrBlocked)
+	}
+	if urlInfo.deleted {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrDeleted)
+	}
+	if urlInfo.expiresAt != nil && !time.Now().Before(*urlInfo.expiresAt) {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrExpired)
 	}
 	return urlInfo.longURL, nil
 }
 
-// GetToken за O(n) ищет первую подходящую запись с токеном
-func (u *inMemoryDB) GetToken(ctx context.Context, token string) (bool, error) {
-	for _, urlInfo := range u.db {
-		if strings.Contains(token, urlInfo.token) {
-			return true, nil
+// CreateUser сохраняет нового пользователя
+func (u *inMemoryDB) CreateUser(ctx context.Context, user models.User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.users[user.ID]; ok {
+		return fmt.Errorf("user %s: %w", user.ID, models.ErrConflict)
+	}
+	u.users[user.ID] = user
+	return nil
+}
+
+// GetUser вернет пользователя по ID
+func (u *inMemoryDB) GetUser(ctx context.Context, userID string) (models.User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user, ok := u.users[userID]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %s: %w", userID, models.ErrNotFound)
+	}
+	return user, nil
+}
+
+// CreateAPIKey сохраняет новый API ключ
+func (u *inMemoryDB) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.apiKeys[key.ID]; ok {
+		return fmt.Errorf("api key %s: %w", key.ID, models.ErrConflict)
+	}
+	if _, ok := u.apiKeyByHash[key.Hash]; ok {
+		return fmt.Errorf("api key %s hash: %w", key.ID, models.ErrConflict)
+	}
+	u.apiKeys[key.ID] = key
+	u.apiKeyByHash[key.Hash] = key.ID
+	return nil
+}
+
+// GetAPIKey вернет API ключ по хэшу
+func (u *inMemoryDB) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	id, ok := u.apiKeyByHash[hash]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key: %w", models.ErrNotFound)
+	}
+	return u.apiKeys[id], nil
+}
+
+// GetUserAPIKeys вернет все API ключи пользователя в порядке создания
+func (u *inMemoryDB) GetUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	var result []models.APIKey
+	for _, key := range u.apiKeys {
+		if key.UserID == userID {
+			result = append(result, key)
 		}
 	}
-	return false, nil
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result, nil
 }
 
+// RevokeAPIKey отзывает API ключ пользователя
+func (u *inMemoryDB) RevokeAPIKey(ctx context.Context, userID string, keyID string, now time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	key, ok := u.apiKeys[keyID]
+	if !ok || key.UserID != userID {
+		return fmt.Errorf("api key %s: %w", keyID, models.ErrNotFound)
+	}
+	if !key.Revoked() {
+		key.RevokedAt = &now
+		u.apiKeys[keyID] = key
+	}
+	return nil
+}
 
 // GetUserURL - вернет все url для пользователя
-func (u *inMemoryDB) GetUserURL(ctx context.Context, token string) ([]models.Record, error) {
+func (u *inMemoryDB) GetUserURL(ctx context.Context, userID string) ([]models.Record, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
 	var result []models.Record
-	for k, urlInfo := range u.db {
-		if strings.Contains(token, urlInfo.token) {
-			result = append(result, models.Record{ShortURL: k, OriginURL: urlInfo.longURL})
+	for _, urlInfo := range u.byOwner[userID] {
+		result = append(result, models.Record{ShortURL: urlInfo.shortURL, OriginURL: urlInfo.longURL, ExpiresAt: urlInfo.expiresAt, RawURL: urlInfo.rawURL})
+	}
+	return result, nil
+}
+
+// GetUserRecord - вернет url пользователя по shortURL, чужой url не найдется
+func (u *inMemoryDB) GetUserRecord(ctx context.Context, shortURL string, userID string) (models.Record, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	urlInfo, ok := u.byOwner[userID][shortURL]
+	if !ok {
+		return models.Record{}, fmt.Errorf("user url %s: %w", shortURL, models.ErrNotFound)
+	}
+	return models.Record{ShortURL: urlInfo.shortURL, OriginURL: urlInfo.longURL, UserID: userID, ExpiresAt: urlInfo.expiresAt, RawURL: urlInfo.rawURL}, nil
+}
+
+// ForEachUserURL - вызывает fn для url пользователя в порядке добавления.
+//					fn вызывается без блокировки, чтобы медленный клиент выгрузки не держал запись в БД
+func (u *inMemoryDB) ForEachUserURL(ctx context.Context, userID string, fn func(models.Record) error) error {
+	u.mu.RLock()
+	owned := make([]URLInfo, 0, len(u.byOwner[userID]))
+	for _, urlInfo := range u.byOwner[userID] {
+		owned = append(owned, *urlInfo)
+	}
+	u.mu.RUnlock()
+	sort.Slice(owned, func(a, b int) bool {
+		return owned[a].id < owned[b].id
+	})
+	for _, urlInfo := range owned {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(models.Record{ShortURL: urlInfo.shortURL, OriginURL: urlInfo.longURL, ExpiresAt: urlInfo.expiresAt, RawURL: urlInfo.rawURL}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchURLs ищет ссылки по фильтру в порядке добавления
+func (u *inMemoryDB) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.AdminRecord, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	// Самые частые запросы - по shortURL и владельцу, их берем из индексов
+	candidates := u.db
+	if len(filter.ShortURL) != 0 {
+		candidates = map[string]*URLInfo{}
+		if urlInfo, ok := u.db[filter.ShortURL]; ok {
+			candidates[filter.ShortURL] = urlInfo
 		}
+	} else if len(filter.UserID) != 0 {
+		candidates = u.byOwner[filter.UserID]
+	}
+	var result []models.AdminRecord
+	for _, urlInfo := range candidates {
+		record := urlInfo.record()
+		if filter.Match(record) {
+			result = append(result, models.AdminRecord{Record: record, Deleted: urlInfo.deleted, Blocked: urlInfo.blocked})
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return u.db[result[a].ShortURL].id < u.db[result[b].ShortURL].id
+	})
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
 	}
 	return result, nil
 }
 
+// SetURLBlocked блокирует или разблокирует ссылку
+func (u *inMemoryDB) SetURLBlocked(ctx context.Context, shortURL string, blocked bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	urlInfo, ok := u.db[shortURL]
+	if !ok {
+		return fmt.Errorf("shorturl %s: %w", shortURL, models.ErrNotFound)
+	}
+	urlInfo.blocked = blocked
+	return nil
+}
+
+// RestoreURL снимает пометку удаленной
+func (u *inMemoryDB) RestoreURL(ctx context.Context, shortURL string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	urlInfo, ok := u.db[shortURL]
+	if !ok {
+		return fmt.Errorf("shorturl %s: %w", shortURL, models.ErrNotFound)
+	}
+	urlInfo.deleted = false
+	return nil
+}
+
+// TransferURL передает ссылку другому пользователю: переносим ее в индексе владельцев.
+//			   Переданная ссылка выходит из дедупликации
+func (u *inMemoryDB) TransferURL(ctx context.Context, shortURL string, userID string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	urlInfo, ok := u.db[shortURL]
+	if !ok {
+		return fmt.Errorf("shorturl %s: %w", shortURL, models.ErrNotFound)
+	}
+	if _, ok = u.users[userID]; !ok {
+		return fmt.Errorf("user %s: %w", userID, models.ErrNotFound)
+	}
+	u.unindex(urlInfo)
+	urlInfo.userID = userID
+	urlInfo.dedup = nil
+	u.index(urlInfo)
+	return nil
+}
+
+// record - ссылка в виде models.Record
+func (urlInfo *URLInfo) record() models.Record {
+	return models.Record{ShortURL: urlInfo.shortURL, OriginURL: urlInfo.longURL, UserID: urlInfo.userID, ExpiresAt: urlInfo.expiresAt, RawURL: urlInfo.rawURL}
+}
+
+// DeleteExpired удаляет ссылки с истекшим сроком жизни
+func (u *inMemoryDB) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var deleted int
+	for _, urlInfo := range u.db {
+		if urlInfo.expiresAt != nil && !now.Before(*urlInfo.expiresAt) {
+			u.unindex(urlInfo)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// AddClicks сохраняет пачку переходов
+func (u *inMemoryDB) AddClicks(ctx context.Context, clicks []models.Click) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, click := range clicks {
+		u.clicks[click.ShortURL] = append(u.clicks[click.ShortURL], click)
+	}
+	return nil
+}
+
+// GetClickStats вернет статистику переходов по shortURL
+func (u *inMemoryDB) GetClickStats(ctx context.Context, shortURL string) (models.LinkStats, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return models.NewLinkStats(shortURL, u.clicks[shortURL]), nil
+}
+
 // Ping Для обратной совместимости с Postgres
 func (u *inMemoryDB) Ping() bool {
 	return true
 }
 
-// OriginURLExists Для обратной совместимости с Postgres
-func (u *inMemoryDB) OriginURLExists(ctx context.Context, originURL string) (bool, error) {
-	return false, nil
+// GetShortURLByIdentityPath вернет id записи пользователя по идентификатору короткого URL
+func (u *inMemoryDB) GetShortURLByIdentityPath(ctx context.Context, identityPath string, userID string) (int, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	urlInfo, ok := u.byIdentity[identityPath]
+	if !ok || urlInfo.userID != userID {
+		return 0, fmt.Errorf("identity path %s: %w", identityPath, models.ErrNotFound)
+	}
+	return urlInfo.id, nil
 }
 
-//// GetOwnerToken Для обратной совместимости с Postgres
-//func (u *inMemoryDB) GetOwnerToken(ctx context.Context, token string) repository.Owner {
-//	owner := repository.Owner{}
-//	return owner
-//}
+// ReserveSequence сдвигает счетчик name на size, новый счетчик начинается после уже добавленных ссылок
+func (u *inMemoryDB) ReserveSequence(ctx context.Context, name string, size uint64) (uint64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	current, ok := u.sequences[name]
+	if !ok {
+		current = uint64(u.nextID)
+	}
+	u.sequences[name] = current + size
+	return current + 1, nil
+}
 
-// GetShortURLByIdentityPath Для обратной совместимости с Postgres
-func (u *inMemoryDB) GetShortURLByIdentityPath(ctx context.Context,identityPath string, token string) int {
-	return 0
+// URLBulkDelete помечает удаленными записи с id из urlsID, удаленные выходят из дедупликации
+func (u *inMemoryDB) URLBulkDelete(ctx context.Context, urlsID []int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, id := range urlsID {
+		if urlInfo, ok := u.byID[id]; ok {
+			urlInfo.deleted = true
+			u.release(urlInfo)
+		}
+	}
+	return nil
 }
 
-// URLMarkDeleted Для обратной совместимости с Postgres
-func (u *inMemoryDB) URLMarkDeleted(ctx context.Context, id int) {}
+// AddDeletionJob сохраняет задание на удаление ссылок
+func (u *inMemoryDB) AddDeletionJob(ctx context.Context, job models.DeletionJob) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.deletionJobs[job.ID]; ok {
+		return fmt.Errorf("deletion job %s: %w", job.ID, models.ErrConflict)
+	}
+	u.deletionJobs[job.ID] = job
+	return nil
+}
+
+// GetDeletionJobs вернет невыполненные задания на удаление в порядке создания
+func (u *inMemoryDB) GetDeletionJobs(ctx context.Context) ([]models.DeletionJob, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	result := make([]models.DeletionJob, 0, len(u.deletionJobs))
+	for _, job := range u.deletionJobs {
+		result = append(result, job)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].CreatedAt.Equal(result[b].CreatedAt) {
+			return result[a].ID < result[b].ID
+		}
+		return result[a].CreatedAt.Before(result[b].CreatedAt)
+	})
+	return result, nil
+}
 
-// URLBulkDelete Для обратной совместимости с Postgres
-//func (u *inMemoryDB) URLBulkDelete(ctx context.Context, idList []int) error {
-func (u *inMemoryDB) URLBulkDelete(ctx context.Context,  urlsID chan int) error {
+// CompleteDeletionJobs убирает выполненные задания
+func (u *inMemoryDB) CompleteDeletionJobs(ctx context.Context, ids []string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, id := range ids {
+		delete(u.deletionJobs, id)
+	}
 	return nil
-}
\ No newline at end of file
+}
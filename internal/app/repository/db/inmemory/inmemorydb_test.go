@@ -0,0 +1,288 @@
+package inmemorydb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+func TestInMemoryDB_Indexes(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user-2"}))
+
+	// ID пользователя сравнивается целиком, а не по вхождению подстроки
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Record{{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1"}}, records)
+	records, err = db.GetUserURL(ctx, "use")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	// Чужая ссылка не найдется
+	record, err := db.GetUserRecord(ctx, "http://localhost/abc", "user")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/1", record.OriginURL)
+	_, err = db.GetUserRecord(ctx, "http://localhost/def", "user")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+func TestInMemoryDB_Upsert(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("user-3", now)))
+
+	record := models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}
+	saved, created, err := db.Upsert(ctx, record, "user", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, record, saved)
+
+	// Тот же URL у того же владельца дедупликации - существующая ссылка, у другого - новая
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, record, saved)
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/1", UserID: "user-2"}, "user-2", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	_, _, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/2", UserID: "user"}, "user", now)
+	var collision *models.CollisionError
+	assert.True(t, errors.As(err, &collision))
+
+	// Ссылки из Add в дедупликации не участвуют
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/add", OriginURL: "https://example.com/2", UserID: "user"}))
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/ghi", OriginURL: "https://example.com/2", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Истекшую ссылку заменяет новая
+	_, _, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/old", OriginURL: "https://example.com/3", UserID: "user", ExpiresAt: &past}, "", now)
+	require.NoError(t, err)
+	saved, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/new", OriginURL: "https://example.com/3", UserID: "user-2"}, "", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "http://localhost/new", saved.ShortURL)
+
+	// Удаленная и переданная ссылки выходят из дедупликации
+	id, err := db.GetShortURLByIdentityPath(ctx, "def", "user-2")
+	require.NoError(t, err)
+	require.NoError(t, db.URLBulkDelete(ctx, []int{id}))
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/jkl", OriginURL: "https://example.com/1", UserID: "user-2"}, "user-2", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	require.NoError(t, db.TransferURL(ctx, "http://localhost/abc", "user-3"))
+	_, created, err = db.Upsert(ctx, models.Record{ShortURL: "http://localhost/mno", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.True(t, created)
+}
+
+func TestInMemoryDB_Users(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	user := models.NewUser("user", time.Now())
+	require.NoError(t, db.CreateUser(ctx, user))
+	assert.True(t, errors.Is(db.CreateUser(ctx, user), models.ErrConflict))
+
+	got, err := db.GetUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+	_, err = db.GetUser(ctx, "user-2")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+func TestInMemoryDB_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	key := models.APIKey{ID: "key", UserID: "user", Hash: models.HashAPIKey("secret"), Scopes: []string{models.ScopeRead}}
+	require.NoError(t, db.CreateAPIKey(ctx, key))
+
+	got, err := db.GetAPIKey(ctx, models.HashAPIKey("secret"))
+	require.NoError(t, err)
+	assert.False(t, got.Revoked())
+
+	// Отозвать можно только свой ключ
+	assert.True(t, errors.Is(db.RevokeAPIKey(ctx, "user-2", "key", time.Now()), models.ErrNotFound))
+	require.NoError(t, db.RevokeAPIKey(ctx, "user", "key", time.Now()))
+	keys, err := db.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].Revoked())
+}
+
+func TestInMemoryDB_URLBulkDelete(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+
+	// Удалить чужую ссылку нельзя
+	_, err := db.GetShortURLByIdentityPath(ctx, "abc", "user-2")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	id, err := db.GetShortURLByIdentityPath(ctx, "abc", "user")
+	require.NoError(t, err)
+	require.NoError(t, db.URLBulkDelete(ctx, []int{id}))
+
+	_, err = db.Get(ctx, "http://localhost/abc", "")
+	assert.True(t, errors.Is(err, models.ErrDeleted))
+}
+
+func TestInMemoryDB_Moderation(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	require.NoError(t, db.CreateUser(ctx, models.NewUser("user-2", time.Now())))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"}))
+
+	require.NoError(t, db.SetURLBlocked(ctx, "http://localhost/abc", true))
+	_, err := db.Get(ctx, "http://localhost/abc", "")
+	assert.True(t, errors.Is(err, models.ErrBlocked))
+	assert.True(t, errors.Is(db.SetURLBlocked(ctx, "http://localhost/xyz", true), models.ErrNotFound))
+
+	// Передать ссылку можно только существующему пользователю
+	assert.True(t, errors.Is(db.TransferURL(ctx, "http://localhost/def", "nobody"), models.ErrNotFound))
+	require.NoError(t, db.TransferURL(ctx, "http://localhost/def", "user-2"))
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	_, err = db.GetShortURLByIdentityPath(ctx, "def", "user-2")
+	assert.NoError(t, err)
+
+	found, err := db.SearchURLs(ctx, models.URLFilter{UserID: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, []models.AdminRecord{{Record: models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}, Blocked: true}}, found)
+	found, err = db.SearchURLs(ctx, models.URLFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "http://localhost/abc", found[0].ShortURL)
+}
+
+func TestInMemoryDB_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shortURL := fmt.Sprintf("http://localhost/%d", i)
+			assert.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: shortURL, OriginURL: shortURL, UserID: "user"}))
+			_, err := db.Get(ctx, shortURL, "")
+			assert.NoError(t, err)
+			_, err = db.GetUserURL(ctx, "user")
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 50)
+}
+
+func TestInMemoryDB_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	now := time.Now()
+	_, _, err := db.Upsert(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+
+	// Коллизия в любой новой записи пачки - не сохраняется ничего
+	_, _, err = db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"},
+		{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/3", UserID: "user"},
+	}, "user", now, nil)
+	var collision *models.CollisionError
+	require.True(t, errors.As(err, &collision))
+	assert.Equal(t, "http://localhost/abc", collision.ShortURL)
+	_, err = db.Get(ctx, "http://localhost/def", "")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	// Тот же URL у другого владельца дедупликации, а short занят чужой ссылкой - коллизия
+	_, _, err = db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user-2"},
+	}, "user-2", now, nil)
+	assert.True(t, errors.As(err, &collision))
+
+	// Коллизия внутри пачки
+	_, _, err = db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"},
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/3", UserID: "user"},
+	}, "user", now, nil)
+	assert.True(t, errors.As(err, &collision))
+
+	// Ссылка владельца дедупликации и повтор URL в пачке - существующие ссылки, ответ для ключа строится по ним
+	key := func(saved []models.Record, created []bool) (*models.IdempotencyKey, error) {
+		return &models.IdempotencyKey{UserID: "user", Key: "key", RequestHash: "hash", Response: []byte(fmt.Sprint(created)), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, nil
+	}
+	saved, created, err := db.AddBatch(ctx, []models.Record{
+		{ShortURL: "http://localhost/new", OriginURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user"},
+		{ShortURL: "http://localhost/ghi", OriginURL: "https://example.com/2", UserID: "user"},
+	}, "user", now, key)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, created)
+	assert.Equal(t, "http://localhost/abc", saved[0].ShortURL)
+	assert.Equal(t, "http://localhost/def", saved[2].ShortURL)
+	records, err := db.GetUserURL(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	_, _, err = db.AddBatch(ctx, nil, "user", now, key)
+	assert.True(t, errors.Is(err, models.ErrConflict))
+
+	// Ссылки пачки участвуют в дедупликации
+	exist, created2, err := db.Upsert(ctx, models.Record{ShortURL: "http://localhost/xyz", OriginURL: "https://example.com/2", UserID: "user"}, "user", now)
+	require.NoError(t, err)
+	assert.False(t, created2)
+	assert.Equal(t, "http://localhost/def", exist.ShortURL)
+
+	// Истекший ключ не находится и удаляется
+	savedKey, err := db.GetIdempotencyKey(ctx, "user", "key", now)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[false true false]"), savedKey.Response)
+	_, err = db.GetIdempotencyKey(ctx, "user", "key", now.Add(time.Hour))
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+	deleted, err := db.DeleteIdempotencyKeys(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestInMemoryDB_ForEachUserURL(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemoryDB()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: fmt.Sprintf("http://localhost/%d", i), OriginURL: fmt.Sprintf("https://example.com/%d", i), UserID: "user"}))
+	}
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/other", OriginURL: "https://example.com/other", UserID: "user-2"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/raw", OriginURL: "https://example.com/raw", UserID: "user", RawURL: "https://Example.com:443/raw"}))
+
+	// Ссылки пользователя в порядке добавления, вместе с присланной пользователем строкой
+	var shortURLs, rawURLs []string
+	require.NoError(t, db.ForEachUserURL(ctx, "user", func(record models.Record) error {
+		shortURLs = append(shortURLs, record.ShortURL)
+		rawURLs = append(rawURLs, record.RawURL)
+		return nil
+	}))
+	assert.Equal(t, []string{"http://localhost/0", "http://localhost/1", "http://localhost/2", "http://localhost/3", "http://localhost/4", "http://localhost/raw"}, shortURLs)
+	assert.Equal(t, []string{"", "", "", "", "", "https://Example.com:443/raw"}, rawURLs)
+
+	// Ошибка fn прерывает обход
+	stop := errors.New("stop")
+	var n int
+	err := db.ForEachUserURL(ctx, "user", func(record models.Record) error {
+		n++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop))
+	assert.Equal(t, 1, n)
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"time"
+
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db/file"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
 	"github.com/yury-nazarov/shorturl/internal/app/repository/db/pg"
@@ -14,17 +16,76 @@ import (
 
 // Repository - общее представление интерфейса для работы с БД
 // 				имплементируем его для каждой реализации
+//				Ошибки реализаций сводятся к ErrNotFound, ErrDeleted, ErrExpired, ErrBlocked, ErrConflict, ErrUnavailable
 type Repository interface {
-	// TODO: В Add и Get можно передавать объект:
-
-	Add(ctx context.Context, shortURL string, longURL string, token string) error
-	Get(ctx context.Context, shortURL string, token string) (string, error)
-	GetToken(ctx context.Context, token string) (bool, error)
-	GetUserURL(ctx context.Context, token string) ([]models.Record, error)
-	GetShortURLByIdentityPath(ctx context.Context, identityPath string, token string) int
-	URLBulkDelete(ctx context.Context, urlsID chan int) error
+	// TODO: В Get можно передавать объект:
+
+	// AddAlias сохраняет запись с выбранным пользователем short, в дедупликации она не участвует.
+	//			Вернет *models.CollisionError если short занят другим URL или другим пользователем
+	AddAlias(ctx context.Context, record models.Record) error
+	// Upsert сохраняет запись, если у dedupOwner еще нет живой ссылки на тот же record.OriginURL, иначе вернет ее.
+	//		  dedupOwner - ID пользователя или "" для общей на всех дедупликации, истекшая на now ссылка заменяется.
+	//		  created=false - вернули существующую ссылку. Вернет *models.CollisionError если short занят
+	Upsert(ctx context.Context, record models.Record, dedupOwner string, now time.Time) (models.Record, bool, error)
+	// AddBatch сохраняет пачку записей, как Upsert каждую, и ключ идемпотентности одной транзакцией: все или ничего.
+	//			Вернет записи в порядке records, created[i]=false - вернули существующую ссылку dedupOwner,
+	//			в том числе на URL, который повторяется в самой пачке. key nil - без ключа.
+	//			Вернет *models.CollisionError если short одной из новых записей занят,
+	//			ErrConflict если у пользователя уже есть такой ключ
+	AddBatch(ctx context.Context, records []models.Record, dedupOwner string, now time.Time, key models.IdempotencyKeyFunc) (saved []models.Record, created []bool, err error)
+	// GetIdempotencyKey вернет ключ идемпотентности пользователя или ErrNotFound, если его нет или он истек на now
+	GetIdempotencyKey(ctx context.Context, userID string, key string, now time.Time) (models.IdempotencyKey, error)
+	// DeleteIdempotencyKeys удаляет ключи идемпотентности, истекшие на now, вернет их количество
+	DeleteIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+	// Get вернет оригинальный URL или ErrNotFound, ErrBlocked, ErrDeleted, ErrExpired
+	Get(ctx context.Context, shortURL string, userID string) (string, error)
+	GetUserURL(ctx context.Context, userID string) ([]models.Record, error)
+	// GetUserRecord вернет ссылку shortURL пользователя userID или ErrNotFound, если ее нет или она чужая
+	GetUserRecord(ctx context.Context, shortURL string, userID string) (models.Record, error)
+	// ForEachUserURL вызывает fn для каждой ссылки пользователя в порядке добавления, не собирая их в слайс.
+	//				  Ошибка fn прерывает обход и возвращается как есть
+	ForEachUserURL(ctx context.Context, userID string, fn func(models.Record) error) error
+	GetShortURLByIdentityPath(ctx context.Context, identityPath string, userID string) (int, error)
+	// ReserveSequence сдвигает счетчик name на size и вернет первое значение зарезервированного блока [start, start+size).
+	//				   Счетчик переживает рестарт, новый начинается после уже сохраненных ссылок
+	ReserveSequence(ctx context.Context, name string, size uint64) (uint64, error)
+	// URLBulkDelete помечает удаленными записи с id из urlsID, уже удаленные и несуществующие пропускает
+	URLBulkDelete(ctx context.Context, urlsID []int) error
+	// AddDeletionJob сохраняет задание на удаление ссылок, вернет ErrConflict если ID уже занят
+	AddDeletionJob(ctx context.Context, job models.DeletionJob) error
+	// GetDeletionJobs вернет невыполненные задания на удаление в порядке создания
+	GetDeletionJobs(ctx context.Context) ([]models.DeletionJob, error)
+	// CompleteDeletionJobs убирает выполненные задания, неизвестные ID пропускает
+	CompleteDeletionJobs(ctx context.Context, ids []string) error
+	// CreateUser сохраняет нового пользователя, вернет ErrConflict если ID уже занят
+	CreateUser(ctx context.Context, user models.User) error
+	// GetUser вернет пользователя или ErrNotFound
+	GetUser(ctx context.Context, userID string) (models.User, error)
+	// CreateAPIKey сохраняет новый API ключ пользователя
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+	// GetAPIKey вернет ключ по хэшу, см. models.HashAPIKey, или ErrNotFound. Отозванные ключи тоже возвращаются
+	GetAPIKey(ctx context.Context, hash string) (models.APIKey, error)
+	// GetUserAPIKeys вернет все ключи пользователя, включая отозванные
+	GetUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	// RevokeAPIKey отзывает ключ пользователя, вернет ErrNotFound если у пользователя нет такого ключа
+	RevokeAPIKey(ctx context.Context, userID string, keyID string, now time.Time) error
 	Ping() bool
-	OriginURLExists(ctx context.Context, originURL string) (bool, error)
+	// SearchURLs ищет ссылки для модерации, включая удаленные и заблокированные
+	SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.AdminRecord, error)
+	// SetURLBlocked блокирует или разблокирует ссылку, вернет ErrNotFound если ее нет
+	SetURLBlocked(ctx context.Context, shortURL string, blocked bool) error
+	// RestoreURL снимает с ссылки пометку удаленной, вернет ErrNotFound если ее нет
+	RestoreURL(ctx context.Context, shortURL string) error
+	// TransferURL передает ссылку пользователю userID, вернет ErrNotFound если нет ссылки или пользователя
+	TransferURL(ctx context.Context, shortURL string, userID string) error
+	// DeleteExpired удаляет (или архивирует) ссылки с истекшим сроком жизни, вернет их количество
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+	// AddClicks сохраняет пачку переходов по коротким URL
+	AddClicks(ctx context.Context, clicks []models.Click) error
+	// GetClickStats вернет статистику переходов по короткому URL с агрегатами по дням
+	GetClickStats(ctx context.Context, shortURL string) (models.LinkStats, error)
+	// Close сбрасывает данные на диск и освобождает соединения, после него БД не используется
+	Close() error
 }
 
 // TODO: Это же фабрика!
@@ -35,17 +96,25 @@ type Repository interface {
 //		 3. Inmemory
 func New(cfg config.Config, logger *logrus.Logger) Repository {
 	if len(cfg.DatabaseDSN) != 0 {
-		// Создаем экземпляр подключения к БД и инициируем схему, если её нет
+		// Создаем экземпляр подключения к БД и применяем новые миграции схемы
 		db := pg.New(cfg.DatabaseDSN)
-		if err := db.SchemeInit(); err != nil {
+		if err := db.MigrateUp(context.Background()); err != nil {
 			logger.Fatal(err)
 		}
 		logger.Println("DB Postgres is connecting")
 		return db
 	}
 	if len(cfg.FileStoragePath) != 0 {
+		db, err := filedb.NewFileDB(cfg.FileStoragePath, filedb.Options{
+			SyncPolicy:      cfg.FileSyncPolicy,
+			SyncInterval:    cfg.FileSyncInterval,
+			CompactInterval: cfg.FileCompactInterval,
+		})
+		if err != nil {
+			logger.Fatal(err)
+		}
 		logger.Println("DB File is connecting")
-		return filedb.NewFileDB(cfg.FileStoragePath)
+		return db
 	}
 	logger.Println("DB InMemory is connecting")
 	return inmemorydb.NewInMemoryDB()
@@ -0,0 +1,51 @@
+package pg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// SQLSTATE ошибок, которые сводим к ошибкам из models
+const (
+	// uniqueViolation - нарушение уникального индекса
+	uniqueViolation = "23505"
+	// foreignKeyViolation - ссылка на несуществующую запись, например на пользователя
+	foreignKeyViolation = "23503"
+	// stringDataRightTruncation - строка длиннее VARCHAR колонки
+	stringDataRightTruncation = "22001"
+)
+
+// shortUniqueIndex - уникальный индекс short, его нарушение - коллизия короткого URL
+const shortUniqueIndex = "url_service_short_key"
+
+// isUniqueViolation - err нарушает уникальный индекс index
+func isUniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == index
+}
+
+// dbErr - сводит ошибки драйвера к ошибкам из models, сохраняя текст исходной ошибки.
+//		   Все, что не является ответом сервера Postgres (соединение, таймаут), считаем недоступностью БД.
+func dbErr(op string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("sql | %s: %w", op, models.ErrNotFound)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return fmt.Errorf("sql | %s: %w: %s", op, models.ErrConflict, pgErr.Message)
+		case foreignKeyViolation:
+			return fmt.Errorf("sql | %s: %w: %s", op, models.ErrNotFound, pgErr.Message)
+		case stringDataRightTruncation:
+			return fmt.Errorf("sql | %s: %w: %s", op, models.ErrTooLong, pgErr.Message)
+		}
+		return fmt.Errorf("sql | %s err: %w", op, err)
+	}
+	return fmt.Errorf("sql | %s: %w: %s", op, models.ErrUnavailable, err)
+}
@@ -0,0 +1,31 @@
+package pg
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+func TestDBErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "no rows", err: sql.ErrNoRows, want: models.ErrNotFound},
+		{name: "unique violation", err: &pgconn.PgError{Code: uniqueViolation}, want: models.ErrConflict},
+		{name: "foreign key violation", err: &pgconn.PgError{Code: foreignKeyViolation}, want: models.ErrNotFound},
+		{name: "value too long", err: &pgconn.PgError{Code: stringDataRightTruncation}, want: models.ErrTooLong},
+		{name: "connection", err: errors.New("connection refused"), want: models.ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, errors.Is(dbErr("op", tt.err), tt.want))
+		})
+	}
+}
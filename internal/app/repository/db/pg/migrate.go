@@ -0,0 +1,224 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Версионные миграции схемы БД.
+// Файлы migrations/NNNN_name.up.sql и migrations/NNNN_name.down.sql вшиты в бинарник,
+// примененные версии храним в таблице schema_migrations.
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockKey - ключ advisory lock, чтобы несколько реплик не мигрировали схему одновременно
+const migrationLockKey = 7428001
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus - состояние одной миграции для команды `shortener migrate status`
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations - читает миграции из embed.FS отсортированными по версии
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s: file name must be NNNN_name.(up|down).sql", base)
+		}
+		data, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			m.name = strings.TrimSuffix(parts[1], ".up.sql")
+			m.up = string(data)
+		case strings.HasSuffix(base, ".down.sql"):
+			m.down = string(data)
+		default:
+			return nil, fmt.Errorf("migration %s: file name must be NNNN_name.(up|down).sql", base)
+		}
+	}
+	var result []migration
+	for _, m := range byVersion {
+		if len(m.up) == 0 || len(m.down) == 0 {
+			return nil, fmt.Errorf("migration %04d: both up and down files are required", m.version)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	return result, nil
+}
+
+// withMigrationLock - выполняет fn на отдельном соединении под advisory lock.
+//					   Lock сессионный, поэтому все запросы миграций идут через одно соединение.
+func (p *pg) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("sql | migration conn err: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("sql | migration lock err: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Printf("sql | migration unlock err: %s", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+                          version BIGINT PRIMARY KEY,
+						  name VARCHAR (255) NOT NULL,
+						  applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`)
+	if err != nil {
+		return fmt.Errorf("create table `schema_migrations`: %w", err)
+	}
+	return fn(conn)
+}
+
+// appliedMigrations - вернет время применения для каждой примененной версии
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("sql | select schema_migrations err: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp - применяет все еще не примененные миграции, каждую в своей транзакции
+func (p *pg) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			err = runInTx(ctx, conn, m.up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s up: %w", m.version, m.name, err)
+			}
+			log.Printf("migration %04d_%s applied", m.version, m.name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown - откатывает последнюю примененную миграцию
+func (p *pg) MigrateDown(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			err = runInTx(ctx, conn, m.down, `DELETE FROM schema_migrations WHERE version=$1`, m.version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", m.version, m.name, err)
+			}
+			log.Printf("migration %04d_%s rolled back", m.version, m.name)
+			return nil
+		}
+		log.Print("no applied migrations")
+		return nil
+	})
+}
+
+// MigrationStatus - вернет список всех миграций и время применения
+func (p *pg) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var result []MigrationStatus
+	err = p.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := MigrationStatus{Version: m.version, Name: m.name}
+			if appliedAt, ok := applied[m.version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			result = append(result, status)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// runInTx - выполняет SQL миграции и запись в schema_migrations в одной транзакции
+func runInTx(ctx context.Context, conn *sql.Conn, query string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
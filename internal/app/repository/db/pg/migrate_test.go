@@ -0,0 +1,25 @@
+package pg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// Версии идут по порядку без пропусков, у каждой есть up и down
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.up)
+		assert.NotEmpty(t, m.down)
+		// Миграция не удаляет ссылки пользователей, в том числе при наведении порядка перед индексом
+		assert.NotContains(t, strings.ToUpper(m.up), "DELETE FROM URL_SERVICE", "migration %04d_%s", m.version, m.name)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS url_clicks;
+DROP TABLE IF EXISTS url_service_archive;
+DROP TABLE IF EXISTS url_service;
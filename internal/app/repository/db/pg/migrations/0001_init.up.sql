@@ -0,0 +1,32 @@
+-- Начальная схема: то, что раньше создавал pg.SchemeInit.
+-- IF NOT EXISTS - чтобы миграция применялась и к БД созданным до появления миграций.
+CREATE TABLE IF NOT EXISTS url_service (
+    id serial PRIMARY KEY,
+    origin VARCHAR (255) NOT NULL,
+    short VARCHAR (255) NOT NULL,
+    owner VARCHAR (255) NOT NULL,
+    delete BOOLEAN DEFAULT FALSE
+);
+
+ALTER TABLE url_service ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS url_service_archive (
+    id INTEGER PRIMARY KEY,
+    origin VARCHAR (255) NOT NULL,
+    short VARCHAR (255) NOT NULL,
+    owner VARCHAR (255) NOT NULL,
+    expires_at TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS url_clicks (
+    id bigserial PRIMARY KEY,
+    short VARCHAR (255) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    country VARCHAR (2) NOT NULL DEFAULT '',
+    visitor VARCHAR (64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS url_clicks_short_idx ON url_clicks (short, clicked_at);
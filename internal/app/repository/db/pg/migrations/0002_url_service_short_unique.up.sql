@@ -0,0 +1,21 @@
+DROP INDEX IF EXISTS url_service_short_key;
+-- До уникального индекса один short мог попасть в таблицу несколько раз: одинаковый URL от разных пользователей.
+-- Каждая такая строка - ссылка своего пользователя, поэтому молча их не удаляем:
+-- миграция остановится со списком дубликатов, решить, какие строки оставить, должен администратор.
+DO $$
+DECLARE
+    total INT;
+    duplicates TEXT;
+BEGIN
+    SELECT count(*), string_agg(format('%s (id: %s)', short, ids), '; ')
+    INTO total, duplicates
+    FROM (SELECT short, string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+          FROM url_service GROUP BY short HAVING count(*) > 1
+          ORDER BY short LIMIT 100) d;
+    IF total > 0 THEN
+        RAISE EXCEPTION 'url_service has duplicate short urls, unique index can not be created: %', duplicates
+            USING HINT = 'keep one row per short (the earliest one is served on redirect) and run the migration again, at most 100 duplicates are listed';
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS url_service_short_key ON url_service (short);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS url_service_owner_idx;
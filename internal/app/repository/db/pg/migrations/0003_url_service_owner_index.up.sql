@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS url_service_owner_idx ON url_service (owner);
@@ -0,0 +1,2 @@
+ALTER TABLE url_service_archive DROP COLUMN IF EXISTS created_at;
+ALTER TABLE url_service DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,2 @@
+ALTER TABLE url_service ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE url_service_archive ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
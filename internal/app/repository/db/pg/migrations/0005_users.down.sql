@@ -0,0 +1,5 @@
+ALTER TABLE url_service_archive RENAME COLUMN user_id TO owner;
+ALTER INDEX IF EXISTS url_service_user_id_idx RENAME TO url_service_owner_idx;
+ALTER TABLE url_service DROP CONSTRAINT IF EXISTS url_service_user_id_fkey;
+ALTER TABLE url_service RENAME COLUMN user_id TO owner;
+DROP TABLE IF EXISTS users;
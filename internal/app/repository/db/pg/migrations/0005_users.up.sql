@@ -0,0 +1,17 @@
+-- Пользователи: раньше владельцем ссылки был токен сессии, теперь это ID пользователя.
+-- Токены существующих владельцев становятся ID пользователей (вход по неподписанному токену не поддерживается).
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR (255) PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    display_name VARCHAR (255) NOT NULL DEFAULT '',
+    status VARCHAR (16) NOT NULL DEFAULT 'active'
+);
+
+INSERT INTO users (id)
+SELECT DISTINCT owner FROM url_service
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE url_service RENAME COLUMN owner TO user_id;
+ALTER TABLE url_service ADD CONSTRAINT url_service_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id);
+ALTER INDEX IF EXISTS url_service_owner_idx RENAME TO url_service_user_id_idx;
+ALTER TABLE url_service_archive RENAME COLUMN owner TO user_id;
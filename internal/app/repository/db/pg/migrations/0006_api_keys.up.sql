@@ -0,0 +1,12 @@
+-- API ключи машинных клиентов: храним только sha256 ключа, права - через запятую
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR (32) PRIMARY KEY,
+    user_id VARCHAR (255) NOT NULL REFERENCES users (id),
+    name VARCHAR (255) NOT NULL DEFAULT '',
+    key_hash CHAR (64) NOT NULL UNIQUE,
+    scopes VARCHAR (255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
@@ -0,0 +1 @@
+ALTER TABLE url_service DROP COLUMN IF EXISTS blocked;
@@ -0,0 +1,2 @@
+-- Блокировка ссылок модератором: редирект по заблокированной ссылке отвечает 451
+ALTER TABLE url_service ADD COLUMN IF NOT EXISTS blocked BOOLEAN NOT NULL DEFAULT false;
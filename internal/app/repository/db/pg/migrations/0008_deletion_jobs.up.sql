@@ -0,0 +1,7 @@
+-- Задания на удаление ссылок пользователем: хранятся до выполнения фоновым удалением, идентификаторы - JSON массивом
+CREATE TABLE IF NOT EXISTS deletion_jobs (
+    id VARCHAR (32) PRIMARY KEY,
+    user_id VARCHAR (255) NOT NULL,
+    identities JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+-- Ответы на запросы с заголовком Idempotency-Key: повтор запроса получает сохраненный ответ
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id VARCHAR (255) NOT NULL,
+    key VARCHAR (255) NOT NULL,
+    request_hash CHAR (64) NOT NULL,
+    response BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
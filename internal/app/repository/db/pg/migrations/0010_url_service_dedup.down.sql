@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS url_service_dedup_key;
+ALTER TABLE url_service DROP COLUMN IF EXISTS dedup_owner;
@@ -0,0 +1,5 @@
+-- Дедупликация оригинальных URL, см. DEDUP_SCOPE: dedup_owner - ID пользователя или '' для общей на всех.
+-- NULL - ссылка не участвует: алиасы, удаленные и переданные ссылки, а также все ссылки до этой миграции
+-- и пачки, сохраненные до того, как AddBatch стал заполнять dedup_owner.
+ALTER TABLE url_service ADD COLUMN IF NOT EXISTS dedup_owner VARCHAR (255);
+CREATE UNIQUE INDEX IF NOT EXISTS url_service_dedup_key ON url_service (dedup_owner, origin) WHERE dedup_owner IS NOT NULL;
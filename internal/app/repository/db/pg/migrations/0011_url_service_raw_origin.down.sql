@@ -0,0 +1,2 @@
+ALTER TABLE url_service_archive DROP COLUMN IF EXISTS raw_origin;
+ALTER TABLE url_service DROP COLUMN IF EXISTS raw_origin;
@@ -0,0 +1,4 @@
+-- origin хранится нормализованным (см. service.URLNormalizer), raw_origin - строка, которую прислал пользователь.
+-- Пустая - совпала с origin или ссылка добавлена до этой миграции.
+ALTER TABLE url_service ADD COLUMN IF NOT EXISTS raw_origin TEXT NOT NULL DEFAULT '';
+ALTER TABLE url_service_archive ADD COLUMN IF NOT EXISTS raw_origin TEXT NOT NULL DEFAULT '';
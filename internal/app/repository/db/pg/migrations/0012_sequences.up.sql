@@ -0,0 +1,5 @@
+-- Счетчики генераторов коротких кодов: value - последнее зарезервированное значение, см. ReserveSequence
+CREATE TABLE IF NOT EXISTS sequences (
+    name VARCHAR (255) PRIMARY KEY,
+    value BIGINT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Не применится, пока в таблицах есть origin длиннее 255 символов
+ALTER TABLE url_service_archive ALTER COLUMN origin TYPE VARCHAR (255);
+ALTER TABLE url_service ALTER COLUMN origin TYPE VARCHAR (255);
@@ -0,0 +1,4 @@
+-- origin был VARCHAR (255), а валидатор пропускает URL до URL_MAX_LENGTH (2048 по умолчанию):
+-- длинный URL проходил проверку и падал на INSERT. raw_origin уже TEXT.
+ALTER TABLE url_service ALTER COLUMN origin TYPE TEXT;
+ALTER TABLE url_service_archive ALTER COLUMN origin TYPE TEXT;
@@ -3,8 +3,12 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 
@@ -28,22 +32,9 @@ func New(connStr string) *pg {
 	return dbConnect
 }
 
-// SchemeInit Создает таблицы в БД если они не созданы.
-func (p *pg) SchemeInit() error {
-	// Контекст для инициализации БД
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	// Общая таблица таблицы.
-	_, err := p.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS url_service (
-                          id serial PRIMARY KEY,
-						  origin VARCHAR (255) NOT NULL,
-						  short VARCHAR (255) NOT NULL,
-						  owner VARCHAR (255) NOT NULL,
-						  delete BOOLEAN DEFAULT FALSE)`)
-	if err != nil {
-		return fmt.Errorf("create table `url_service`: %w", err)
-	}
-	return nil
+// Close - закрывает пул соединений, ждет завершения запросов в работе
+func (p *pg) Close() error {
+	return p.db.Close()
 }
 
 // Ping - Проверка соединения с БД
@@ -54,125 +45,691 @@ func (p *pg) Ping() bool {
 	return true
 }
 
-// Add - добавляет новую запись в таблицу: url_service записать в БД url и токен.
-func (p *pg) Add(ctx context.Context, shortURL string, longURL string, token string) error {
-	_, err := p.db.ExecContext(ctx, `INSERT INTO url_service (origin, short, owner) VALUES ($1, $2, $3)`, longURL, shortURL, token)
+// AddAlias - резервирует выбранный пользователем short: вставляет запись только если short еще не занят.
+//			  Если short уже занят другим origin или другим пользователем - вернет *models.CollisionError
+func (p *pg) AddAlias(ctx context.Context, record models.Record) error {
+	shortURL, longURL, userID := record.ShortURL, record.OriginURL, record.UserID
+	// Вставляем запись только если short еще не занят (уникальный индекс url_service_short_key)
+	result, err := p.db.ExecContext(ctx, `INSERT INTO url_service (origin, short, user_id, expires_at, raw_origin)
+											VALUES ($1, $2, $3, $4, $5)
+											ON CONFLICT (short) DO NOTHING`,
+											longURL, shortURL, userID, record.ExpiresAt, record.RawURL)
 	if err != nil {
-		log.Printf("sql | insert new url err %s\n", err)
+		return dbErr("insert new url", err)
 	}
-
-	log.Printf("DEBUG: User: %s add URL: %s -> %s\n", token,  longURL, shortURL)
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return dbErr("insert new url rows affected", err)
+	}
+	if inserted == 0 {
+		// short уже есть в БД, коллизия если он указывает на другой origin или чужой
+		var existURL, existOwner string
+		err = p.db.QueryRowContext(ctx, `SELECT origin, user_id FROM url_service WHERE short=$1 LIMIT 1`, shortURL).Scan(&existURL, &existOwner)
+		if err != nil {
+			return dbErr("select exist short url", err)
+		}
+		if existURL != longURL || existOwner != userID {
+			return &models.CollisionError{ShortURL: shortURL}
+		}
+		return nil
+	}
+	log.Printf("DEBUG: User: %s add URL: %s -> %s\n", userID,  longURL, shortURL)
 	return nil
 }
 
-// Get - Возвращает оригинальный URL или 410 если он помечен удаленных (для всех пользователей)
-func (p *pg) Get(ctx context.Context, shortURL string, token string) (string, error) {
-	var originURL string
-	var isDelete bool
+// Upsert - добавляет запись с владельцем дедупликации, если у него нет ссылки на тот же origin, иначе вернет ее.
+//			Уникальный индекс url_service_dedup_key делает проверку и вставку атомарными:
+//			из параллельных запросов запись добавит один, остальные получат ее
+func (p *pg) Upsert(ctx context.Context, record models.Record, dedupOwner string, now time.Time) (models.Record, bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Record{}, false, dbErr("transaction begin", err)
+	}
+	// если возникает ошибка, откатываем изменения
+	defer tx.Rollback()
 
-	// Получаем оргинальный URL
-	err := p.db.QueryRowContext(ctx, `SELECT origin, delete FROM url_service WHERE short=$1 LIMIT 1`, shortURL).Scan(&originURL, &isDelete)
+	// Истекшая ссылка больше не отвечает за свой URL
+	_, err = tx.ExecContext(ctx, `UPDATE url_service SET dedup_owner=NULL
+											WHERE dedup_owner=$1 AND origin=$2 AND expires_at <= $3`,
+											dedupOwner, record.OriginURL, now)
+	if err != nil {
+		return models.Record{}, false, dbErr("release expired dedup url", err)
+	}
+	var id int
+	err = tx.QueryRowContext(ctx, `INSERT INTO url_service (origin, short, user_id, expires_at, dedup_owner, raw_origin)
+											VALUES ($1, $2, $3, $4, $5, $6)
+											ON CONFLICT (dedup_owner, origin) WHERE dedup_owner IS NOT NULL DO NOTHING
+											RETURNING id`,
+											record.OriginURL, record.ShortURL, record.UserID, record.ExpiresAt, dedupOwner, record.RawURL).Scan(&id)
+	if isUniqueViolation(err, shortUniqueIndex) {
+		return models.Record{}, false, &models.CollisionError{ShortURL: record.ShortURL}
+	}
+	created := err == nil
+	if !created && !errors.Is(err, sql.ErrNoRows) {
+		return models.Record{}, false, dbErr("upsert url", err)
+	}
+	if !created {
+		// Ссылка уже есть: она и есть результат
+		err = tx.QueryRowContext(ctx, `SELECT origin, short, user_id, expires_at, raw_origin FROM url_service
+											WHERE dedup_owner=$1 AND origin=$2`, dedupOwner, record.OriginURL).
+											Scan(&record.OriginURL, &record.ShortURL, &record.UserID, &record.ExpiresAt, &record.RawURL)
+		if err != nil {
+			return models.Record{}, false, dbErr("select dedup url", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return models.Record{}, false, dbErr("transaction commit", err)
+	}
+	return record, created, nil
+}
 
+// AddBatch - добавляет пачку записей одним INSERT и ключ идемпотентности в одной транзакции.
+//			  Каждая запись проходит дедупликацию как в Upsert: ссылки dedupOwner на URL пачки находим одним SELECT,
+//			  повтор URL в пачке получает ту же ссылку. Если short новой записи занят, в том числе в самой пачке,
+//			  или тот же URL успел добавить параллельный запрос, - откатываем всю пачку и возвращаем
+//			  *models.CollisionError: при повторе SELECT найдет уже добавленную ссылку
+func (p *pg) AddBatch(ctx context.Context, records []models.Record, dedupOwner string, now time.Time, key models.IdempotencyKeyFunc) ([]models.Record, []bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("sql |  get origin url status err: %s", err)
+		return nil, nil, dbErr("transaction begin", err)
 	}
+	// если возникает ошибка, откатываем изменения
+	defer tx.Rollback()
 
-	// Возвращаем пустую строку если URL помечен как удаленный
+	saved := make([]models.Record, len(records))
+	created := make([]bool, len(records))
+	if len(records) != 0 {
+		origins := make([]string, 0, len(records))
+		for _, record := range records {
+			origins = append(origins, record.OriginURL)
+		}
+		// Истекшие ссылки больше не отвечают за свои URL
+		_, err = tx.ExecContext(ctx, `UPDATE url_service SET dedup_owner=NULL
+											WHERE dedup_owner=$1 AND origin = ANY($2) AND expires_at <= $3`,
+											dedupOwner, origins, now)
+		if err != nil {
+			return nil, nil, dbErr("release expired dedup urls", err)
+		}
+		existing, err := dedupRecords(ctx, tx, dedupOwner, origins)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// fresh - origin -> новая запись пачки, taken - short новых записей
+		fresh := make(map[string]int, len(records))
+		taken := make(map[string]bool, len(records))
+		values := make([]string, 0, len(records))
+		args := make([]interface{}, 0, 6*len(records))
+		for i, record := range records {
+			if exist, ok := existing[record.OriginURL]; ok {
+				saved[i] = exist
+				continue
+			}
+			if j, ok := fresh[record.OriginURL]; ok {
+				saved[i] = saved[j]
+				continue
+			}
+			if taken[record.ShortURL] {
+				return nil, nil, &models.CollisionError{ShortURL: record.ShortURL}
+			}
+			fresh[record.OriginURL] = i
+			taken[record.ShortURL] = true
+			saved[i], created[i] = record, true
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+			args = append(args, record.OriginURL, record.ShortURL, record.UserID, record.ExpiresAt, dedupOwner, record.RawURL)
+		}
+		if len(values) != 0 {
+			// Без conflict target пропускаются и занятые short, и URL, которые успел добавить параллельный запрос
+			inserted, err := insertBatch(ctx, tx, `INSERT INTO url_service (origin, short, user_id, expires_at, dedup_owner, raw_origin)
+											VALUES `+strings.Join(values, ", ")+`
+											ON CONFLICT DO NOTHING
+											RETURNING short`, args)
+			if err != nil {
+				return nil, nil, err
+			}
+			for i, record := range saved {
+				if created[i] && !inserted[record.ShortURL] {
+					return nil, nil, &models.CollisionError{ShortURL: record.ShortURL}
+				}
+			}
+		}
+	}
+
+	if key != nil {
+		idempotencyKey, err := key(saved, created)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Истекший, но еще не удаленный ключ можно занять заново
+		result, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (user_id, key, request_hash, response, created_at, expires_at)
+											VALUES ($1, $2, $3, $4, $5, $6)
+											ON CONFLICT (user_id, key) DO UPDATE
+											SET request_hash = EXCLUDED.request_hash, response = EXCLUDED.response,
+												created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+											WHERE idempotency_keys.expires_at <= EXCLUDED.created_at`,
+											idempotencyKey.UserID, idempotencyKey.Key, idempotencyKey.RequestHash, idempotencyKey.Response, idempotencyKey.CreatedAt, idempotencyKey.ExpiresAt)
+		if err != nil {
+			return nil, nil, dbErr("insert idempotency key", err)
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return nil, nil, dbErr("insert idempotency key rows affected", err)
+		}
+		if inserted == 0 {
+			return nil, nil, fmt.Errorf("idempotency key %s: %w", idempotencyKey.Key, models.ErrConflict)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, dbErr("transaction commit", err)
+	}
+	return saved, created, nil
+}
+
+// dedupRecords - origin -> ссылка, которая отвечает за него у dedupOwner
+func dedupRecords(ctx context.Context, tx *sql.Tx, dedupOwner string, origins []string) (map[string]models.Record, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT origin, short, user_id, expires_at, raw_origin FROM url_service
+											WHERE dedup_owner=$1 AND origin = ANY($2)`, dedupOwner, origins)
+	if err != nil {
+		return nil, dbErr("select dedup urls", err)
+	}
+	defer rows.Close()
+
+	records := make(map[string]models.Record, len(origins))
+	for rows.Next() {
+		var record models.Record
+		if err = rows.Scan(&record.OriginURL, &record.ShortURL, &record.UserID, &record.ExpiresAt, &record.RawURL); err != nil {
+			return nil, dbErr("scan dedup urls", err)
+		}
+		records[record.OriginURL] = record
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbErr("select dedup urls", err)
+	}
+	return records, nil
+}
+
+// insertBatch - выполняет INSERT ... RETURNING short, вернет добавленные short
+func insertBatch(ctx context.Context, tx *sql.Tx, query string, args []interface{}) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbErr("insert url batch", err)
+	}
+	defer rows.Close()
+
+	inserted := make(map[string]bool, len(args))
+	for rows.Next() {
+		var short string
+		if err = rows.Scan(&short); err != nil {
+			return nil, dbErr("scan inserted urls", err)
+		}
+		inserted[short] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbErr("insert url batch", err)
+	}
+	return inserted, nil
+}
+
+// GetIdempotencyKey - вернет ключ идемпотентности пользователя, если он не истек на now
+func (p *pg) GetIdempotencyKey(ctx context.Context, userID string, key string, now time.Time) (models.IdempotencyKey, error) {
+	var result models.IdempotencyKey
+	err := p.db.QueryRowContext(ctx, `SELECT user_id, key, request_hash, response, created_at, expires_at
+											FROM idempotency_keys WHERE user_id=$1 AND key=$2 AND expires_at > $3`,
+											userID, key, now).
+		Scan(&result.UserID, &result.Key, &result.RequestHash, &result.Response, &result.CreatedAt, &result.ExpiresAt)
+	if err != nil {
+		return models.IdempotencyKey{}, dbErr("select idempotency key", err)
+	}
+	return result, nil
+}
+
+// DeleteIdempotencyKeys - удаляет ключи идемпотентности, истекшие на now
+func (p *pg) DeleteIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, dbErr("delete expired idempotency keys", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, dbErr("delete expired idempotency keys rows affected", err)
+	}
+	return int(deleted), nil
+}
+
+// Get - Возвращает оригинальный URL.
+//		 Вернет models.ErrNotFound, models.ErrBlocked если URL заблокировал модератор,
+//		 models.ErrDeleted если URL помечен удаленным (для всех пользователей)
+//		 и models.ErrExpired если истек срок его жизни
+func (p *pg) Get(ctx context.Context, shortURL string, userID string) (string, error) {
+	var originURL string
+	var isDelete, isBlocked, isExpired bool
+
+	// Получаем оргинальный URL
+	err := p.db.QueryRowContext(ctx, `SELECT origin, COALESCE(delete, false), blocked, COALESCE(expires_at <= now(), false)
+											FROM url_service WHERE short=$1 LIMIT 1`, shortURL).Scan(&originURL, &isDelete, &isBlocked, &isExpired)
+	if err != nil {
+		return "", dbErr("get origin url", err)
+	}
+	if isBlocked {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrBlocked)
+	}
 	if isDelete {
-		return "", nil
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrDeleted)
+	}
+	if isExpired {
+		return "", fmt.Errorf("shorturl %s: %w", shortURL, models.ErrExpired)
 	}
-	// Возвращаем оригинальный URL если помечен как не удаленный
 	return originURL, nil
 }
 
-// GetUserURL - Возвращает все url для конкретного token
-func (p *pg) GetUserURL(ctx context.Context, token string) ([]models.Record, error) {
+// GetUserURL - Возвращает все url пользователя
+func (p *pg) GetUserURL(ctx context.Context, userID string) ([]models.Record, error) {
 	// Слайс который будем возвращать как результат работы метода
 	var urls []models.Record
 
-	// Получаем все url для конкретного owner
-	rows, err := p.db.QueryContext(ctx, `SELECT origin, short FROM url_service WHERE owner=$1`, token)
-	fmt.Println("token", token)
+	// Получаем все url пользователя
+	rows, err := p.db.QueryContext(ctx, `SELECT origin, short, expires_at, raw_origin FROM url_service WHERE user_id=$1`, userID)
 	if err != nil {
-		return urls, err
+		return urls, dbErr("get users url", err)
 	}
 	defer rows.Close()
 
 	// Достаем по id конкретные URL: origin, short.
 	for rows.Next() {
 		var url models.Record
-		rows.Scan(&url.OriginURL, &url.ShortURL)
+		if err = rows.Scan(&url.OriginURL, &url.ShortURL, &url.ExpiresAt, &url.RawURL); err != nil {
+			return nil, dbErr("scan users url", err)
+		}
 		urls = append(urls, url)
 	}
 	if err = rows.Err(); err != nil {
-		log.Printf("sql | get users url err: %s\n", err)
+		return nil, dbErr("get users url", err)
 	}
 	return urls, nil
 }
 
-// GetShortURLByIdentityPath вернет все записи пользователя по идентификатору короткого URL
-func (p *pg) GetShortURLByIdentityPath(ctx context.Context, identityPath string, token string) int {
+// GetUserRecord - вернет url пользователя по short, чужой url не найдется
+func (p *pg) GetUserRecord(ctx context.Context, shortURL string, userID string) (models.Record, error) {
+	var url models.Record
+	err := p.db.QueryRowContext(ctx, `SELECT origin, short, user_id, expires_at, raw_origin FROM url_service
+											WHERE short=$1 AND user_id=$2`, shortURL, userID).
+		Scan(&url.OriginURL, &url.ShortURL, &url.UserID, &url.ExpiresAt, &url.RawURL)
+	if err != nil {
+		return models.Record{}, dbErr("get user url", err)
+	}
+	return url, nil
+}
+
+// ForEachUserURL - читает url пользователя курсором по одной строке и отдает их в fn в порядке добавления
+func (p *pg) ForEachUserURL(ctx context.Context, userID string, fn func(models.Record) error) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT origin, short, expires_at, raw_origin FROM url_service WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return dbErr("get users url", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url models.Record
+		if err = rows.Scan(&url.OriginURL, &url.ShortURL, &url.ExpiresAt, &url.RawURL); err != nil {
+			return dbErr("scan users url", err)
+		}
+		if err = fn(url); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return dbErr("get users url", err)
+	}
+	return nil
+}
+
+// ReserveSequence - сдвигает счетчик name на size одним запросом, параллельные резервы получают разные блоки.
+//					 Новый счетчик начинается после max(id) уже сохраненных ссылок
+func (p *pg) ReserveSequence(ctx context.Context, name string, size uint64) (uint64, error) {
+	var start int64
+	err := p.db.QueryRowContext(ctx, `INSERT INTO sequences (name, value)
+											VALUES ($1, (SELECT COALESCE(max(id), 0) FROM url_service) + $2)
+											ON CONFLICT (name) DO UPDATE SET value = sequences.value + $2
+											RETURNING value - $2 + 1`, name, int64(size)).Scan(&start)
+	if err != nil {
+		return 0, dbErr("reserve sequence "+name, err)
+	}
+	return uint64(start), nil
+}
+
+// GetShortURLByIdentityPath вернет id записи пользователя по идентификатору короткого URL.
+//							 identityPath приходит из тела запроса на удаление, поэтому % и _ в нем
+//							 (в алиасах "_" разрешен) экранируем: сравнение точное, как в file и inmemory
+func (p *pg) GetShortURLByIdentityPath(ctx context.Context, identityPath string, userID string) (int, error) {
 	var urlID int
 	err := p.db.QueryRowContext(ctx, `SELECT id FROM url_service 
-											WHERE short LIKE $1
-											AND owner=$2`,
-											"%"+identityPath, token).Scan(&urlID)
+											WHERE short LIKE $1 ESCAPE '\'
+											AND user_id=$2`,
+											"%/"+escapeLike(identityPath), userID).Scan(&urlID)
+	if err != nil {
+		return 0, dbErr("select short url by identity path", err)
+	}
+	return urlID, nil
+}
+
+// likeEscaper - экранирует спецсимволы LIKE, экранирующий символ - обратный слэш
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 
+// escapeLike - s для LIKE как есть, без подстановочных символов
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// URLBulkDelete помечает удаленным в таблице url_service. delete=true - одним запросом на всю пачку,
+//				 удаленные ссылки выходят из дедупликации
+func (p *pg) URLBulkDelete(ctx context.Context, urlsID []int) error {
+	if len(urlsID) == 0 {
+		return nil
+	}
+	if _, err := p.db.ExecContext(ctx, `UPDATE url_service SET delete=true, dedup_owner=NULL WHERE id = ANY($1)`, urlsID); err != nil {
+		return dbErr("bulk delete", err)
+	}
+	return nil
+}
+
+// AddDeletionJob - добавляет задание на удаление в таблицу deletion_jobs
+func (p *pg) AddDeletionJob(ctx context.Context, job models.DeletionJob) error {
+	identities, err := json.Marshal(job.Identities)
+	if err != nil {
+		return fmt.Errorf("marshal deletion job identities: %w", err)
+	}
+	_, err = p.db.ExecContext(ctx, `INSERT INTO deletion_jobs (id, user_id, identities, created_at)
+											VALUES ($1, $2, $3, $4)`,
+											job.ID, job.UserID, string(identities), job.CreatedAt)
 	if err != nil {
-		log.Printf("sql | select short url by identity path err: %s", err)
+		return dbErr("insert deletion job", err)
 	}
-	return urlID
+	return nil
 }
 
-// URLBulkDelete помечает удаленным в таблице url_service. delete=true
-func (p *pg) URLBulkDelete(ctx context.Context,  urlsID chan int) error {
-	// шаг 1 — объявляем транзакцию
-	tx, err := p.db.Begin()
+// GetDeletionJobs - вернет невыполненные задания на удаление в порядке создания
+func (p *pg) GetDeletionJobs(ctx context.Context) ([]models.DeletionJob, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, user_id, identities, created_at
+											FROM deletion_jobs ORDER BY created_at, id`)
 	if err != nil {
-		return fmt.Errorf("sql | transaction begin err: %w", err)
+		return nil, dbErr("get deletion jobs", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.DeletionJob
+	for rows.Next() {
+		var job models.DeletionJob
+		var identities []byte
+		if err = rows.Scan(&job.ID, &job.UserID, &identities, &job.CreatedAt); err != nil {
+			return nil, dbErr("scan deletion jobs", err)
+		}
+		if err = json.Unmarshal(identities, &job.Identities); err != nil {
+			return nil, fmt.Errorf("unmarshal deletion job %s identities: %w", job.ID, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbErr("get deletion jobs", err)
+	}
+	return jobs, nil
+}
+
+// CompleteDeletionJobs - удаляет выполненные задания из таблицы deletion_jobs
+func (p *pg) CompleteDeletionJobs(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM deletion_jobs WHERE id = ANY($1)`, ids); err != nil {
+		return dbErr("complete deletion jobs", err)
+	}
+	return nil
+}
+
+
+
+// SearchURLs - ищет ссылки по фильтру в порядке добавления
+func (p *pg) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.AdminRecord, error) {
+	// Условия добавляем только для заданных полей фильтра
+	var where []string
+	var args []interface{}
+	conditions := []struct {
+		column string
+		value  string
+	}{{"origin", filter.OriginURL}, {"short", filter.ShortURL}, {"user_id", filter.UserID}}
+	for _, c := range conditions {
+		if len(c.value) != 0 {
+			args = append(args, c.value)
+			where = append(where, fmt.Sprintf("%s=$%d", c.column, len(args)))
+		}
+	}
+	query := `SELECT origin, short, user_id, expires_at, COALESCE(delete, false), blocked FROM url_service`
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbErr("search urls", err)
+	}
+	defer rows.Close()
+
+	var result []models.AdminRecord
+	for rows.Next() {
+		var record models.AdminRecord
+		if err = rows.Scan(&record.OriginURL, &record.ShortURL, &record.UserID, &record.ExpiresAt, &record.Deleted, &record.Blocked); err != nil {
+			return nil, dbErr("scan search urls", err)
+		}
+		result = append(result, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbErr("search urls", err)
+	}
+	return result, nil
+}
+
+// SetURLBlocked - блокирует или разблокирует ссылку
+func (p *pg) SetURLBlocked(ctx context.Context, shortURL string, blocked bool) error {
+	return p.updateURL(ctx, "block url", `UPDATE url_service SET blocked=$2 WHERE short=$1`, shortURL, blocked)
+}
+
+// RestoreURL - снимает с ссылки пометку удаленной
+func (p *pg) RestoreURL(ctx context.Context, shortURL string) error {
+	return p.updateURL(ctx, "restore url", `UPDATE url_service SET delete=false WHERE short=$1`, shortURL)
+}
+
+// TransferURL - передает ссылку другому пользователю, переданная ссылка выходит из дедупликации.
+//				 Несуществующего пользователя не пустит внешний ключ
+func (p *pg) TransferURL(ctx context.Context, shortURL string, userID string) error {
+	return p.updateURL(ctx, "transfer url", `UPDATE url_service SET user_id=$2, dedup_owner=NULL WHERE short=$1`, shortURL, userID)
+}
+
+// updateURL - выполняет UPDATE одной ссылки, первый аргумент запроса - short.
+//			   Вернет models.ErrNotFound если ссылки нет
+func (p *pg) updateURL(ctx context.Context, op string, query string, shortURL string, args ...interface{}) error {
+	result, err := p.db.ExecContext(ctx, query, append([]interface{}{shortURL}, args...)...)
+	if err != nil {
+		return dbErr(op, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return dbErr(op+" rows affected", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("sql | %s %s: %w", op, shortURL, models.ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteExpired - переносит ссылки с истекшим сроком жизни в url_service_archive
+func (p *pg) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	result, err := p.db.ExecContext(ctx, `WITH expired AS (
+												DELETE FROM url_service WHERE expires_at <= $1
+												RETURNING id, origin, short, user_id, expires_at, created_at, raw_origin)
+											INSERT INTO url_service_archive (id, origin, short, user_id, expires_at, created_at, raw_origin)
+											SELECT id, origin, short, user_id, expires_at, created_at, raw_origin FROM expired
+											ON CONFLICT (id) DO NOTHING`, now)
+	if err != nil {
+		return 0, dbErr("archive expired urls", err)
+	}
+	archived, err := result.RowsAffected()
+	if err != nil {
+		return 0, dbErr("archive expired urls rows affected", err)
+	}
+	return int(archived), nil
+}
+
+// AddClicks - сохраняет пачку переходов в url_clicks одной транзакцией
+func (p *pg) AddClicks(ctx context.Context, clicks []models.Click) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbErr("transaction begin", err)
 	}
-	// если возникает ошибка, откатываем изменения
 	defer tx.Rollback()
 
-	// шаг 2 — готовим инструкцию
-	stmt, err := tx.PrepareContext(ctx, "UPDATE url_service SET delete=true WHERE id=$1")
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO url_clicks (short, clicked_at, referrer, user_agent, country, visitor)
+											VALUES ($1, $2, $3, $4, $5, $6)`)
 	if err != nil {
-		return fmt.Errorf("sql | transaction prepare context err err %w", err)
+		return dbErr("transaction prepare context", err)
 	}
 	defer stmt.Close()
 
-	// шаг 3 - указываем, что для каждого id в таблице url_service нужно обновить поле delete
-	for id := range urlsID{
-		fmt.Printf("DEBUG: transaction statement prepare delete url with ID:%d\n", id)
-		if _, err = stmt.ExecContext(ctx, id); err != nil {
-			return fmt.Errorf("sql | transaction statement exec context err %w", err)
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ShortURL, click.Time, click.Referrer, click.UserAgent, click.Country, click.VisitorID)
+		if err != nil {
+			return dbErr("insert click", err)
 		}
 	}
-	// шаг 4 — сохраняем изменения
-	fmt.Printf("DEBUG: Commit transaction\n")
 	return tx.Commit()
 }
 
+// GetClickStats - вернет статистику переходов по short с агрегатами по дням (UTC)
+func (p *pg) GetClickStats(ctx context.Context, shortURL string) (models.LinkStats, error) {
+	stats := models.LinkStats{ShortURL: shortURL, Daily: []models.DailyClicks{}}
+	err := p.db.QueryRowContext(ctx, `SELECT count(*), count(DISTINCT visitor) FROM url_clicks WHERE short=$1`,
+											shortURL).Scan(&stats.Clicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, dbErr("select click stats", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, `SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+												count(*), count(DISTINCT visitor)
+											FROM url_clicks WHERE short=$1
+											GROUP BY day ORDER BY day`, shortURL)
+	if err != nil {
+		return stats, dbErr("select daily click stats", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day models.DailyClicks
+		if err = rows.Scan(&day.Date, &day.Clicks, &day.UniqueVisitors); err != nil {
+			return stats, dbErr("scan daily click stats", err)
+		}
+		stats.Daily = append(stats.Daily, day)
+	}
+	return stats, rows.Err()
+}
+
+// CreateUser - добавляет пользователя в таблицу users
+func (p *pg) CreateUser(ctx context.Context, user models.User) error {
+	_, err := p.db.ExecContext(ctx, `INSERT INTO users (id, created_at, display_name, status)
+											VALUES ($1, $2, $3, $4)`,
+											user.ID, user.CreatedAt, user.DisplayName, user.Status)
+	if err != nil {
+		return dbErr("insert user", err)
+	}
+	return nil
+}
+
+// GetUser - вернет пользователя по ID
+func (p *pg) GetUser(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+	err := p.db.QueryRowContext(ctx, `SELECT id, created_at, display_name, status FROM users WHERE id=$1`,
+											userID).Scan(&user.ID, &user.CreatedAt, &user.DisplayName, &user.Status)
+	if err != nil {
+		return models.User{}, dbErr("select user", err)
+	}
+	return user, nil
+}
 
+// CreateAPIKey - добавляет API ключ в таблицу api_keys
+func (p *pg) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := p.db.ExecContext(ctx, `INSERT INTO api_keys (id, user_id, name, key_hash, scopes, created_at)
+											VALUES ($1, $2, $3, $4, $5, $6)`,
+											key.ID, key.UserID, key.Name, key.Hash, strings.Join(key.Scopes, ","), key.CreatedAt)
+	if err != nil {
+		return dbErr("insert api key", err)
+	}
+	return nil
+}
 
-// GetToken - Проверяет наличие токена в БД
-func (p *pg) GetToken(ctx context.Context, token string) (bool, error) {
-	var owner int
-	if err := p.db.QueryRowContext(ctx, `SELECT id FROM url_service WHERE owner=$1 LIMIT 1;`, token).Scan(&owner); err != nil {
-		return false, fmt.Errorf("sql | token not found: %w", err)
+// GetAPIKey - вернет API ключ по хэшу
+func (p *pg) GetAPIKey(ctx context.Context, hash string) (models.APIKey, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT id, user_id, name, key_hash, scopes, created_at, revoked_at
+											FROM api_keys WHERE key_hash=$1`, hash)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		return models.APIKey{}, dbErr("select api key", err)
 	}
-	return true, nil
+	return key, nil
 }
 
-// OriginURLExists - проверяет наличие URL в БД
-func (p *pg) OriginURLExists(ctx context.Context, originURL string) (bool, error) {
-	var url string
-	err := p.db.QueryRowContext(ctx, `SELECT origin FROM url_service WHERE origin=$1 LIMIT 1`, originURL).Scan(&url)
+// GetUserAPIKeys - вернет все API ключи пользователя в порядке создания
+func (p *pg) GetUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, user_id, name, key_hash, scopes, created_at, revoked_at
+											FROM api_keys WHERE user_id=$1 ORDER BY created_at, id`, userID)
 	if err != nil {
-		return false, err
+		return nil, dbErr("get user api keys", err)
 	}
-	if len(url) == 0 {
-		return false, nil
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, dbErr("scan user api keys", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbErr("get user api keys", err)
 	}
-	return true, nil
-}
\ No newline at end of file
+	return keys, nil
+}
+
+// RevokeAPIKey - отзывает API ключ пользователя, повторный отзыв не меняет дату
+func (p *pg) RevokeAPIKey(ctx context.Context, userID string, keyID string, now time.Time) error {
+	result, err := p.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $3)
+											WHERE id=$1 AND user_id=$2`,
+											keyID, userID, now)
+	if err != nil {
+		return dbErr("revoke api key", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return dbErr("revoke api key rows affected", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("sql | revoke api key %s: %w", keyID, models.ErrNotFound)
+	}
+	return nil
+}
+
+// scanAPIKey - читает API ключ из строки ответа, права хранятся через запятую
+func scanAPIKey(row interface{ Scan(dest ...interface{}) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &scopes, &key.CreatedAt, &key.RevokedAt); err != nil {
+		return models.APIKey{}, err
+	}
+	if len(scopes) != 0 {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return key, nil
+}
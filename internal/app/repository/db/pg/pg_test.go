@@ -0,0 +1,105 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// newTestPG - Postgres из TEST_DATABASE_DSN с примененными миграциями, без него тест пропускается
+func newTestPG(t *testing.T) *pg {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if len(dsn) == 0 {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db := New(dsn)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.MigrateUp(context.Background()))
+	return db
+}
+
+func TestPG_LongOriginURL(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPG(t)
+	now := time.Now()
+	userID := fmt.Sprintf("long-url-%d", now.UnixNano())
+	require.NoError(t, db.CreateUser(ctx, models.NewUser(userID, now)))
+
+	// Длиннее прежнего VARCHAR (255), но короче URL_MAX_LENGTH
+	originURL := "https://example.com/" + strings.Repeat("a", 300)
+	record := models.Record{OriginURL: originURL, ShortURL: "http://127.0.0.1:8080/" + userID, UserID: userID, RawURL: originURL + " "}
+	saved, created, err := db.Upsert(ctx, record, userID, now)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, originURL, saved.OriginURL)
+
+	got, err := db.Get(ctx, record.ShortURL, userID)
+	require.NoError(t, err)
+	assert.Equal(t, originURL, got)
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "abc", escapeLike("abc"))
+	assert.Equal(t, `a\_b\%c\\d`, escapeLike(`a_b%c\d`))
+}
+
+func TestPG_GetShortURLByIdentityPath_Wildcards(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPG(t)
+	now := time.Now()
+	userID := fmt.Sprintf("identity-%d", now.UnixNano())
+	require.NoError(t, db.CreateUser(ctx, models.NewUser(userID, now)))
+
+	// "_" в алиасе не должен совпадать с любым символом
+	for _, alias := range []string{"ab-" + userID, "abX" + userID} {
+		record := models.Record{OriginURL: "https://example.com/" + alias, ShortURL: "http://127.0.0.1:8080/" + alias, UserID: userID}
+		require.NoError(t, db.AddAlias(ctx, record))
+	}
+	_, err := db.GetShortURLByIdentityPath(ctx, "ab_"+userID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = db.GetShortURLByIdentityPath(ctx, "%"+userID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = db.GetShortURLByIdentityPath(ctx, "ab-"+userID, userID)
+	assert.NoError(t, err)
+}
+
+func TestPG_AddBatchDedup(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPG(t)
+	now := time.Now()
+	userID := fmt.Sprintf("batch-dedup-%d", now.UnixNano())
+	require.NoError(t, db.CreateUser(ctx, models.NewUser(userID, now)))
+
+	base := "http://127.0.0.1:8080/" + userID
+	single, _, err := db.Upsert(ctx, models.Record{OriginURL: "https://example.com/" + userID + "/1", ShortURL: base + "-1", UserID: userID}, userID, now)
+	require.NoError(t, err)
+
+	// Ссылка из Upsert и повтор URL в пачке - существующие ссылки
+	saved, created, err := db.AddBatch(ctx, []models.Record{
+		{OriginURL: single.OriginURL, ShortURL: base + "-new", UserID: userID},
+		{OriginURL: "https://example.com/" + userID + "/2", ShortURL: base + "-2", UserID: userID},
+		{OriginURL: "https://example.com/" + userID + "/2", ShortURL: base + "-3", UserID: userID},
+	}, userID, now, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, created)
+	assert.Equal(t, single.ShortURL, saved[0].ShortURL)
+	assert.Equal(t, base+"-2", saved[2].ShortURL)
+
+	// Ссылка пачки находится через Upsert, занятый short - коллизия
+	exist, created2, err := db.Upsert(ctx, models.Record{OriginURL: "https://example.com/" + userID + "/2", ShortURL: base + "-4", UserID: userID}, userID, now)
+	require.NoError(t, err)
+	assert.False(t, created2)
+	assert.Equal(t, base+"-2", exist.ShortURL)
+	_, _, err = db.AddBatch(ctx, []models.Record{{OriginURL: "https://example.com/" + userID + "/3", ShortURL: base + "-1", UserID: userID}}, userID, now, nil)
+	var collision *models.CollisionError
+	assert.ErrorAs(t, err, &collision)
+}
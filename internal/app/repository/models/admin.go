@@ -0,0 +1,26 @@
+package models
+
+// Структуры для модерации ссылок через /api/admin
+
+// URLFilter - условия поиска ссылок, пустые поля не учитываются
+type URLFilter struct {
+	OriginURL string
+	ShortURL  string
+	UserID    string
+	// Limit - сколько ссылок вернуть, 0 - все
+	Limit int
+}
+
+// Match - подходит ли ссылка под все заданные условия
+func (f URLFilter) Match(record Record) bool {
+	return (len(f.OriginURL) == 0 || record.OriginURL == f.OriginURL) &&
+		(len(f.ShortURL) == 0 || record.ShortURL == f.ShortURL) &&
+		(len(f.UserID) == 0 || record.UserID == f.UserID)
+}
+
+// AdminRecord - ссылка вместе с владельцем и пометками, которые не видны пользователям
+type AdminRecord struct {
+	Record
+	Deleted bool `json:"deleted"`
+	Blocked bool `json:"blocked"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Права API ключа
+const (
+	ScopeShorten = "shorten" // сокращение ссылок
+	ScopeRead    = "read"    // список ссылок и статистика
+	ScopeDelete  = "delete"  // удаление ссылок
+)
+
+// Scopes - все права, которые можно выдать ключу
+var Scopes = []string{ScopeShorten, ScopeRead, ScopeDelete}
+
+// APIKey - ключ доступа к API для машинных клиентов.
+//			Сам ключ не храним: его отдаем пользователю один раз при создании, в БД лежит только Hash
+type APIKey struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // nil - ключ действует
+}
+
+// HashAPIKey - хэш, по которому ключ ищется в БД.
+//				Ключ - 32 случайных байта, поэтому медленный хэш для паролей не нужен
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScope - выдано ли ключу право scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked - отозван ли ключ
+func (k APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// APIKeyRequest - десериализуем запрос на создание ключа
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKeyResponse - ключ в ответе клиенту: без хэша, сам Key заполнен только при создании
+type APIKeyResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Key       string     `json:"key,omitempty"`
+}
+
+// Response - ключ для ответа клиенту
+func (k APIKey) Response() APIKeyResponse {
+	return APIKeyResponse{ID: k.ID, Name: k.Name, Scopes: k.Scopes, CreatedAt: k.CreatedAt, RevokedAt: k.RevokedAt}
+}
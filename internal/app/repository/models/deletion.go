@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DeletionJob - запрос пользователя на удаление ссылок, ожидающий фоновой обработки.
+//				 Хранится в БД до выполнения, чтобы удаление пережило рестарт сервиса
+type DeletionJob struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// Identities - сокращенные части url, как их прислал пользователь
+	Identities []string  `json:"identities"`
+	CreatedAt  time.Time `json:"created_at"`
+}
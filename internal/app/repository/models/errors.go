@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ошибки которые возвращают реализации БД.
+// Объявлены здесь, т.к. пакеты реализаций не могут импортировать пакет db (он импортирует их),
+// снаружи используем их через db.ErrNotFound и т.д.
+
+var (
+	// ErrNotFound - записи нет в БД
+	ErrNotFound = errors.New("not found")
+	// ErrDeleted - запись помечена удаленной
+	ErrDeleted = errors.New("deleted")
+	// ErrExpired - истек срок жизни ссылки
+	ErrExpired = errors.New("expired")
+	// ErrBlocked - ссылка заблокирована модератором
+	ErrBlocked = errors.New("blocked")
+	// ErrConflict - запись конфликтует с уже существующей
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable - БД недоступна
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrTooLong - значение не помещается в колонку БД
+	ErrTooLong = errors.New("value too long")
+)
+
+// CollisionError - короткий URL уже занят другим оригинальным URL (или другим пользователем для alias).
+//					Возвращается из Repository.AddAlias, Upsert и AddBatch, вместо перезаписи чужой ссылки.
+//					errors.Is(err, ErrConflict) для нее вернет true.
+type CollisionError struct {
+	ShortURL string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("short url %s already taken by another origin url", e.ShortURL)
+}
+
+func (e *CollisionError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// IdempotencyKey - результат запроса с заголовком Idempotency-Key.
+//					Повтор запроса с тем же ключом получает сохраненный ответ, а не создает записи заново
+type IdempotencyKey struct {
+	UserID string `json:"user_id"`
+	Key    string `json:"key"`
+	// RequestHash - sha256 тела запроса: тот же ключ с другим телом - ошибка клиента
+	RequestHash string `json:"request_hash"`
+	// Response - тело успешного ответа
+	Response  []byte    `json:"response"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IdempotencyKeyFunc - строит ключ идемпотентности пачки, когда известны ее сохраненные записи:
+//						ответ зависит от них. created[i]=false - saved[i] уже была в БД
+type IdempotencyKeyFunc func(saved []Record, created []bool) (*IdempotencyKey, error)
+
+// Expired - истек ли срок хранения ключа на момент now
+func (k IdempotencyKey) Expired(now time.Time) bool {
+	return !now.Before(k.ExpiresAt)
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Структуры для работы с БД
 
 // Record - описывает каждую запись в БД как json
@@ -8,9 +10,16 @@ package models
 //				repository.inmemory  	- read / write to file
 // 				repository.pg.GetUserURL - парсинг отваета SQL запроса
 type Record struct {
-	ShortURL  	string `json:"short_url"`
-	OriginURL 	string `json:"original_url"`
-	Token 		string `json:"token"`
+	ShortURL  	string 		`json:"short_url"`
+	OriginURL 	string 		`json:"original_url"`
+	UserID 		string 		`json:"user_id"`
+	ExpiresAt 	*time.Time 	`json:"expires_at,omitempty"` // nil - ссылка бессрочная
+	RawURL 		string 		`json:"raw_url,omitempty"`    // строка, которую прислал пользователь, если OriginURL - ее нормализованный вид
+}
+
+// Expired - истек ли срок жизни ссылки на момент now
+func (r Record) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
 }
 
 // структуры для handler помогающие обрабатывать и сериализовать коммуникации с клиентом
@@ -19,19 +28,51 @@ type Record struct {
 //		десериализуем данные пришедшие по HTTP
 // 		Так же с помощью этой структуры сериализуем ответ клиенту
 type URL struct {
-	Request  string `json:"url,omitempty"`    // Не учитываем поле при Marshal
-	Response string `json:"result,omitempty"` // Не учитываем поле при Unmarshal
+	Request   string     `json:"url,omitempty"`        // Не учитываем поле при Marshal
+	Alias     string     `json:"alias,omitempty"`      // Необязательный желаемый короткий путь, например: promo2026
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Необязательная дата, после которой ссылка перестает работать
+	TTL       int64      `json:"ttl,omitempty"`        // Необязательное время жизни ссылки в секундах
+	Response  string     `json:"result,omitempty"`     // Не учитываем поле при Unmarshal
 }
 
 // URLBatch
 // 		 десериализуем данные пришедшие по HTTP
 // 		 Так же с помощью этой структуры сериализуем ответ клиенту
 type URLBatch struct {
-	CorrelationID 	string `json:"correlation_id"`
-	OriginalURL 	string `json:"original_url,omitempty"`
-	ShortURL 		string `json:"short_url,omitempty"`
+	CorrelationID 	string 		`json:"correlation_id"`
+	OriginalURL 	string 		`json:"original_url,omitempty"`
+	ExpiresAt 		*time.Time 	`json:"expires_at,omitempty"`
+	TTL 			int64 		`json:"ttl,omitempty"`
+	ShortURL 		string 		`json:"short_url,omitempty"`
+	// Status - результат по элементу в ответе: BatchStatusCreated, BatchStatusExists, BatchStatusInvalid,
+	//			BatchStatusSkipped, BatchStatusFailed
+	Status 			string 		`json:"status,omitempty"`
+	// Error - почему элемент не прошел проверку или не сохранен, только для BatchStatusInvalid и BatchStatusFailed
+	Error 			*URLBatchError `json:"error,omitempty"`
 }
 
+// Статусы элементов пачки. Пачка сохраняется целиком или не сохраняется совсем
+const (
+	BatchStatusCreated = "created" // элемент сохранен
+	BatchStatusExists  = "exists"  // ссылка на этот URL уже была (см. DEDUP_SCOPE), в short_url - она
+	BatchStatusInvalid = "invalid" // элемент не прошел проверку
+	BatchStatusSkipped = "skipped" // элемент корректный, но пачка не сохранена из-за других элементов
+	BatchStatusFailed  = "failed"  // потоковый импорт: пачку не удалось сохранить, импорт остановлен
+)
+
+// URLBatchError - код и описание ошибки проверки элемента пачки, как в теле ответа с ошибкой
+type URLBatchError struct {
+	Code 	string `json:"code"`
+	Message string `json:"message"`
+}
+
+// URLExport - строка выгрузки ссылок пользователя, ее можно снова загрузить потоковым импортом
+type URLExport struct {
+	ShortURL 	string 		`json:"short_url"`
+	OriginalURL string 		`json:"original_url"`
+	ExpiresAt 	*time.Time 	`json:"expires_at,omitempty"`
+	RawURL 		string 		`json:"raw_url,omitempty"`
+}
 
 // Вариант использовать пару общих структур для передаи данных между слоями
 // 		   и сериализации/десериализации коммуникаций с пользователем.
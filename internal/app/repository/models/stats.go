@@ -0,0 +1,89 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// Структуры для аналитики переходов по коротким URL
+
+// Click - один переход по короткому URL
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	VisitorID string    `json:"visitor_id"` // хеш IP + User-Agent, для оценки уникальных посетителей
+}
+
+// DailyClicks - агрегат переходов за день (UTC)
+type DailyClicks struct {
+	Date           string `json:"date"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+// LinkStats - статистика переходов по короткому URL, сериализуем ответ клиенту
+type LinkStats struct {
+	ShortURL       string        `json:"short_url"`
+	Clicks         int           `json:"clicks"`
+	UniqueVisitors int           `json:"unique_visitors"`
+	Daily          []DailyClicks `json:"daily"`
+}
+
+// NewLinkStats - считает статистику по списку переходов.
+//				  Используем в repository.inmemory, в pg агрегирует SQL
+func NewLinkStats(shortURL string, clicks []Click) LinkStats {
+	counter := NewClickCounter()
+	for _, click := range clicks {
+		counter.Add(click)
+	}
+	return counter.Stats(shortURL)
+}
+
+// ClickCounter - агрегаты переходов по одному короткому URL, обновляются по одному переходу.
+//				  Хранит счетчики и посетителей по дням, а не сами переходы:
+//				  repository.file держит их в памяти, чтобы не читать файл переходов на каждый запрос статистики
+type ClickCounter struct {
+	clicks   int
+	visitors map[string]bool
+	days     map[string]*dayCounter
+}
+
+// dayCounter - переходы и посетители за день (UTC)
+type dayCounter struct {
+	clicks   int
+	visitors map[string]bool
+}
+
+func NewClickCounter() *ClickCounter {
+	return &ClickCounter{visitors: map[string]bool{}, days: map[string]*dayCounter{}}
+}
+
+// Add - учитывает переход
+func (c *ClickCounter) Add(click Click) {
+	c.clicks++
+	c.visitors[click.VisitorID] = true
+
+	date := click.Time.UTC().Format("2006-01-02")
+	day, ok := c.days[date]
+	if !ok {
+		day = &dayCounter{visitors: map[string]bool{}}
+		c.days[date] = day
+	}
+	day.clicks++
+	day.visitors[click.VisitorID] = true
+}
+
+// Stats - статистика для ответа клиенту, дни по порядку
+func (c *ClickCounter) Stats(shortURL string) LinkStats {
+	stats := LinkStats{ShortURL: shortURL, Clicks: c.clicks, UniqueVisitors: len(c.visitors), Daily: []DailyClicks{}}
+	for date, day := range c.days {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: date, Clicks: day.clicks, UniqueVisitors: len(day.visitors)})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	return stats
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Статусы пользователя
+const (
+	UserActive  = "active"
+	UserBlocked = "blocked"
+)
+
+// User - пользователь сервиса, владелец ссылок.
+//		  ID приходит из подписанной cookie сессии, у машинных клиентов - от владельца API ключа или из claims JWT
+type User struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	DisplayName string    `json:"display_name"`
+	Status      string    `json:"status"`
+}
+
+// NewUser - новый активный пользователь
+func NewUser(id string, now time.Time) User {
+	return User{ID: id, CreatedAt: now.UTC(), Status: UserActive}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 32
+)
+
+// ErrInvalidAlias - пользователь запросил недопустимый alias
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// aliasPattern - допустимые символы alias: безопасные для path без экранирования
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases - первые сегменты path занятые эндпоинтами из handler.NewRouter
+var reservedAliases = map[string]bool{
+	"api":  true,
+	"ping": true,
+}
+
+// ValidateAlias - проверяет длину, набор символов и что alias не совпадает с эндпоинтами сервиса
+func ValidateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return fmt.Errorf("%w: length must be from %d to %d", ErrInvalidAlias, aliasMinLength, aliasMaxLength)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("%w: allowed characters are A-Z, a-z, 0-9, '_' and '-'", ErrInvalidAlias)
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("%w: %s is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
+
+// ShortenAlias - сохраняет в БД выбранный пользователем короткий URL.
+//				  Вернет ErrInvalidAlias если alias не прошел проверку
+//				  и *models.CollisionError если alias занят другим пользователем.
+func (l *LinkCompressor) ShortenAlias(ctx context.Context, record models.Record, alias string) (string, error) {
+	if err := ValidateAlias(alias); err != nil {
+		return "", err
+	}
+	record.ShortURL = fmt.Sprintf("%s/%s", l.ServiceName, alias)
+	if err := l.db.AddAlias(ctx, record); err != nil {
+		return "", storageErr(err)
+	}
+	return record.ShortURL, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+// ErrInvalidScope - пользователь запросил для ключа неизвестное право или не указал ни одного
+var ErrInvalidScope = errors.New("invalid api key scope")
+
+// apiKeyPrefix - по префиксу ключ легко найти в логах CI и в сканерах секретов
+const apiKeyPrefix = "sk_"
+
+// NewAPIKey - выпускает API ключ пользователя с правами scopes.
+//			   Вернет ключ для сохранения в БД и сам секрет: его показываем пользователю один раз
+func NewAPIKey(userID string, name string, scopes []string, now time.Time) (models.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return models.APIKey{}, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	var unique []string
+	for _, scope := range scopes {
+		if !knownScope(scope) {
+			return models.APIKey{}, "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !contains(unique, scope) {
+			unique = append(unique, scope)
+		}
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return models.APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return models.APIKey{}, "", err
+	}
+	token := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	key := models.APIKey{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		Name:      name,
+		Hash:      models.HashAPIKey(token),
+		Scopes:    unique,
+		CreatedAt: now.UTC(),
+	}
+	return key, token, nil
+}
+
+func knownScope(scope string) bool {
+	return contains(models.Scopes, scope)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	key, token, err := NewAPIKey("user", "ci", []string{models.ScopeShorten, models.ScopeRead, models.ScopeShorten}, now)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, apiKeyPrefix))
+	assert.Equal(t, models.HashAPIKey(token), key.Hash)
+	assert.NotContains(t, key.Hash, token)
+	assert.Equal(t, []string{models.ScopeShorten, models.ScopeRead}, key.Scopes)
+	assert.Equal(t, "user", key.UserID)
+	assert.Equal(t, now, key.CreatedAt)
+
+	// Каждый раз новый ключ
+	other, otherToken, err := NewAPIKey("user", "ci", []string{models.ScopeRead}, now)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.ID, other.ID)
+	assert.NotEqual(t, token, otherToken)
+
+	_, _, err = NewAPIKey("user", "ci", nil, now)
+	assert.True(t, errors.Is(err, ErrInvalidScope))
+	_, _, err = NewAPIKey("user", "ci", []string{"admin"}, now)
+	assert.True(t, errors.Is(err, ErrInvalidScope))
+}
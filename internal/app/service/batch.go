@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+)
+
+var (
+	// ErrEmptyBatch - прислали пачку без элементов: 400
+	ErrEmptyBatch = errors.New("batch is empty")
+	// ErrBatchTooLarge - в пачке больше элементов, чем BATCH_MAX_SIZE: 413
+	ErrBatchTooLarge = errors.New("batch is too large")
+	// ErrInvalidIdempotencyKey - ключ идемпотентности слишком длинный: 400
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	// ErrIdempotencyKeyReused - ключ идемпотентности уже использован с другим телом запроса: 422
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with another request")
+)
+
+// streamChunkSize - сколько строк потокового импорта сохраняется одной пачкой
+const streamChunkSize = 100
+
+// maxIdempotencyKeyLength - ограничение колонки key в idempotency_keys
+const maxIdempotencyKeyLength = 255
+
+// Idempotency - ключ идемпотентности для ShortenBatch.
+//				 Ответ зависит от коротких URL, поэтому его строит Response, когда они уже известны,
+//				 и он сохраняется в Key.Response вместе с пачкой. exists[i] - как в ShortenBatch
+type Idempotency struct {
+	Key      models.IdempotencyKey
+	Response func(shortURLs []string, exists []bool) ([]byte, error)
+}
+
+// CheckIdempotencyKey - вернет ErrInvalidIdempotencyKey, если ключ не поместится в БД
+func CheckIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidIdempotencyKey, maxIdempotencyKeyLength)
+	}
+	return nil
+}
+
+// RequestHash - hex sha256 тела запроса для models.IdempotencyKey
+func RequestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewIdempotency - ключ идемпотентности пользователя userID для запроса с телом body,
+//					ответ хранится IDEMPOTENCY_KEY_TTL
+func (l *LinkCompressor) NewIdempotency(userID string, key string, body []byte, now time.Time, response func(shortURLs []string, exists []bool) ([]byte, error)) *Idempotency {
+	return &Idempotency{
+		Key: models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: RequestHash(body),
+			CreatedAt:   now.UTC(),
+			ExpiresAt:   now.Add(l.idempotencyKeyTTL).UTC(),
+		},
+		Response: response,
+	}
+}
+
+// CheckBatchSize - вернет ErrEmptyBatch или ErrBatchTooLarge, если размер пачки n недопустим
+func (l *LinkCompressor) CheckBatchSize(n int) error {
+	if n == 0 {
+		return ErrEmptyBatch
+	}
+	if l.maxBatchSize > 0 && n > l.maxBatchSize {
+		return fmt.Errorf("%w: %d items, max %d", ErrBatchTooLarge, n, l.maxBatchSize)
+	}
+	return nil
+}
+
+// StreamChunkSize - размер пачки потокового импорта, не больше BATCH_MAX_SIZE
+func (l *LinkCompressor) StreamChunkSize() int {
+	if l.maxBatchSize > 0 && l.maxBatchSize < streamChunkSize {
+		return l.maxBatchSize
+	}
+	return streamChunkSize
+}
+
+// ShortenBatch - сокращает пачку URL и сохраняет ее одной транзакцией вместе с ключом идемпотентности,
+//				  idempotency nil - без ключа. URL, для которых уже есть ссылка в области дедупликации DEDUP_SCOPE,
+//				  получают ее, как в Shorten: exists[i]=true. Если код одной из новых записей занят, пачка
+//				  не сохраняется: таким записям берем следующего кандидата и сохраняем пачку заново.
+//				  Вернет короткие URL в порядке records или ErrShortCodeExhausted, если свободных кодов не нашлось
+func (l *LinkCompressor) ShortenBatch(ctx context.Context, records []models.Record, idempotency *Idempotency) (shortURLs []string, exists []bool, err error) {
+	if err := l.CheckBatchSize(len(records)); err != nil {
+		return nil, nil, err
+	}
+	dedupOwner := l.dedupOwner(records[0].UserID)
+	batch := make([]models.Record, len(records))
+	copy(batch, records)
+	// Новый код генерируем только записям с занятым кодом: генераторы counter и random не повторяются
+	attempts := make([]int, len(batch))
+	regenerate := make([]bool, len(batch))
+	for i := range regenerate {
+		regenerate[i] = true
+	}
+	var lastErr error
+	// key - ответ для ключа идемпотентности строим по сохраненным записям, в той же транзакции
+	var key models.IdempotencyKeyFunc
+	if idempotency != nil {
+		key = func(saved []models.Record, created []bool) (*models.IdempotencyKey, error) {
+			shortURLs, exists := batchResult(saved, created)
+			response, err := idempotency.Response(shortURLs, exists)
+			if err != nil {
+				return nil, err
+			}
+			idempotency.Key.Response = response
+			return &idempotency.Key, nil
+		}
+	}
+	for try := 0; try < maxAttempts; try++ {
+		for i := range batch {
+			if regenerate[i] {
+				shortURL, err := l.SortURL(ctx, batch[i].OriginURL, attempts[i])
+				if err != nil {
+					return nil, nil, err
+				}
+				batch[i].ShortURL = shortURL
+				regenerate[i] = false
+			}
+		}
+
+		saved, created, err := l.db.AddBatch(ctx, batch, dedupOwner, time.Now(), key)
+		var collision *models.CollisionError
+		if errors.As(err, &collision) {
+			l.logger.Printf("short url collision in batch: %s, attempt: %d", collision.ShortURL, try)
+			lastErr = err
+			for i := range batch {
+				if batch[i].ShortURL == collision.ShortURL {
+					attempts[i]++
+					regenerate[i] = true
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, storageErr(err)
+		}
+		shortURLs, exists = batchResult(saved, created)
+		return shortURLs, exists, nil
+	}
+	return nil, nil, exhausted(lastErr)
+}
+
+// batchResult - короткие URL сохраненных записей пачки и какие из них уже были
+func batchResult(saved []models.Record, created []bool) (shortURLs []string, exists []bool) {
+	shortURLs = make([]string, len(saved))
+	exists = make([]bool, len(saved))
+	for i := range saved {
+		shortURLs[i] = saved[i].ShortURL
+		exists[i] = !created[i]
+	}
+	return shortURLs, exists
+}
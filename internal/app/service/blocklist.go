@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blocklistRegexpPrefix - строка блоклиста с этим префиксом - регулярное выражение для URL целиком
+const blocklistRegexpPrefix = "regexp:"
+
+// Blocklist - запрещенные для сокращения URL из локального файла.
+//			 Формат: правило на строку, строки начинающиеся с # пропускаются.
+//	Домен запрещает себя и все поддомены, "regexp:<выражение>" проверяется по URL целиком.
+//	Файл перечитывается в Run, если изменилось время его модификации.
+type Blocklist struct {
+	path   string
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	domains  map[string]bool
+	patterns []*regexp.Regexp
+	modTime  time.Time
+}
+
+// NewBlocklist - загружает правила из файла. Пустой путь - блоклист пустой.
+func NewBlocklist(path string, logger *logrus.Logger) (*Blocklist, error) {
+	b := &Blocklist{path: path, logger: logger, domains: map[string]bool{}}
+	if len(path) == 0 {
+		return b, nil
+	}
+	if _, err := b.reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Match - вернет правило, под которое попадает URL
+func (b *Blocklist) Match(u *url.URL) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	// Проверяем сам домен и все родительские: a.b.example.com, b.example.com, example.com, com
+	for domain := host; len(domain) != 0; {
+		if b.domains[domain] {
+			return domain, true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	for _, pattern := range b.patterns {
+		if pattern.MatchString(u.String()) {
+			return blocklistRegexpPrefix + pattern.String(), true
+		}
+	}
+	return "", false
+}
+
+// Run - раз в interval перечитывает файл, если он изменился, пока не отменен ctx.
+//			 С ошибкой в файле продолжаем работать со старыми правилами.
+func (b *Blocklist) Run(ctx context.Context, interval time.Duration) {
+	if len(b.path) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := b.reload()
+			if err != nil {
+				b.logger.Print("blocklist reload: ", err)
+				continue
+			}
+			if reloaded {
+				b.logger.Infof("the blocklist %s reloaded", b.path)
+			}
+		}
+	}
+}
+
+// reload - перечитывает файл, если он изменился с прошлой загрузки
+func (b *Blocklist) reload() (bool, error) {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false, fmt.Errorf("blocklist: %w", err)
+	}
+	b.mu.RLock()
+	unchanged := info.ModTime().Equal(b.modTime)
+	b.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return false, fmt.Errorf("blocklist: %w", err)
+	}
+	domains, patterns, err := parseBlocklist(data)
+	if err != nil {
+		return false, fmt.Errorf("blocklist %s: %w", b.path, err)
+	}
+	b.mu.Lock()
+	b.domains, b.patterns, b.modTime = domains, patterns, info.ModTime()
+	b.mu.Unlock()
+	return true, nil
+}
+
+func parseBlocklist(data []byte) (map[string]bool, []*regexp.Regexp, error) {
+	domains := map[string]bool{}
+	var patterns []*regexp.Regexp
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		rule := strings.TrimSpace(scanner.Text())
+		if len(rule) == 0 || strings.HasPrefix(rule, "#") {
+			continue
+		}
+		if strings.HasPrefix(rule, blocklistRegexpPrefix) {
+			pattern, err := regexp.Compile(strings.TrimPrefix(rule, blocklistRegexpPrefix))
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			patterns = append(patterns, pattern)
+			continue
+		}
+		domains[strings.TrimSuffix(strings.ToLower(rule), ".")] = true
+	}
+	return domains, patterns, scanner.Err()
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clickBatchSize - сколько переходов копим, прежде чем записать в БД
+const clickBatchSize = 100
+
+// ClickTracker - асинхронный сбор переходов по коротким URL.
+//				  Хендлер редиректа только кладет переход в буферизированный канал,
+//				  а Run пачками пишет их в БД раз в flushInterval или по заполнению пачки.
+type ClickTracker struct {
+	db            db.Repository
+	geoIP         *GeoIP
+	clicks        chan models.Click
+	flushInterval time.Duration
+	logger        *logrus.Logger
+}
+
+// NewClickTracker - вернет объект для сбора переходов, запись в БД запускается через Run
+func NewClickTracker(db db.Repository, geoIP *GeoIP, bufferSize int, flushInterval time.Duration, logger *logrus.Logger) *ClickTracker {
+	return &ClickTracker{
+		db:            db,
+		geoIP:         geoIP,
+		clicks:        make(chan models.Click, bufferSize),
+		flushInterval: flushInterval,
+		logger:        logger,
+	}
+}
+
+// Track - собирает переход из HTTP запроса и не блокируясь отправляет его на запись.
+//		   Если буфер переполнен - переход теряется, редирект важнее статистики.
+func (t *ClickTracker) Track(r *http.Request, shortURL string) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	visitor := sha256.Sum256([]byte(ip + "|" + r.UserAgent()))
+	click := models.Click{
+		ShortURL:  shortURL,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		Country:   t.geoIP.Country(ip),
+		VisitorID: hex.EncodeToString(visitor[:8]),
+	}
+	select {
+	case t.clicks <- click:
+	default:
+		t.logger.Print("click buffer is full, drop click for ", shortURL)
+	}
+}
+
+// Run - пачками пишет переходы в БД, пока не отменен ctx. Перед выходом сбрасывает накопленное.
+func (t *ClickTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+	batch := make([]models.Click, 0, clickBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Пишем с отдельным контекстом, чтобы не потерять пачку при остановке
+		if err := t.db.AddClicks(context.Background(), batch); err != nil {
+			t.logger.Print("AddClicks: ", err)
+		}
+		batch = make([]models.Click, 0, clickBatchSize)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// Забираем то, что осталось в буфере
+			for {
+				select {
+				case click := <-t.clicks:
+					batch = append(batch, click)
+				default:
+					flush()
+					return
+				}
+			}
+		case click := <-t.clicks:
+			batch = append(batch, click)
+			if len(batch) >= clickBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
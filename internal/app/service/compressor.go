@@ -1,56 +1,130 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
+	"time"
 
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
 	"github.com/yury-nazarov/shorturl/internal/config"
 
-	"github.com/itchyny/base58-go"
 	"github.com/sirupsen/logrus"
 )
 
+// Области дедупликации оригинальных URL, выбираются через config.DedupScope
+const (
+	DedupScopeUser   = "user"   // у каждого пользователя своя ссылка на URL
+	DedupScopeGlobal = "global" // одна ссылка на URL на всех
+)
+
+// ErrShortCodeExhausted - за maxAttempts попыток не нашлось свободного кода: это проблема сервиса
+//						   (короткая длина кода, заполненное пространство), а не конфликт с данными клиента
+var ErrShortCodeExhausted = errors.New("no free short code")
+
+const (
+	// maxAttempts - сколько кандидатов короткого URL пробуем, прежде чем вернуть ошибку коллизии
+	maxAttempts = 16
+	// attemptsPerLength - через сколько неудачных попыток удлиняем короткий URL на один символ
+	attemptsPerLength = 4
+)
+
 type LinkCompressor struct {
-	urlLength   int
+	generator   ShortCodeGenerator
 	ServiceName string
+	db          db.Repository
+	// maxBatchSize - сколько ссылок можно сократить одной пачкой, 0 - без ограничения
+	maxBatchSize int
+	// idempotencyKeyTTL - сколько хранить ответ на пачку с ключом идемпотентности
+	idempotencyKeyTTL time.Duration
+	// dedupScope - DedupScopeUser или DedupScopeGlobal
+	dedupScope 	string
 	logger 		*logrus.Logger
 }
 
-// NewLinkCompressor - объект содержит в себе все необходимое для подготови короткого URL
-//func NewLinkCompressor(urlLength int, urlAnswer string, logger *logrus.Logger) LinkCompressor {
-func NewLinkCompressor(cfg config.Config, logger *logrus.Logger) LinkCompressor {
+// NewLinkCompressor - объект содержит в себе все необходимое для подготови короткого URL.
+//					   Вернет ошибку, если в конфиге неизвестная стратегия кодов или область дедупликации
+func NewLinkCompressor(cfg config.Config, db db.Repository, logger *logrus.Logger) (LinkCompressor, error) {
+	generator, err := NewShortCodeGenerator(cfg, db)
+	if err != nil {
+		return LinkCompressor{}, err
+	}
+	switch cfg.DedupScope {
+	case "":
+		cfg.DedupScope = DedupScopeUser
+	case DedupScopeUser, DedupScopeGlobal:
+	default:
+		return LinkCompressor{}, fmt.Errorf("unknown dedup scope: %s", cfg.DedupScope)
+	}
 	lc := LinkCompressor{
-		urlLength:   cfg.URLLength,
+		generator:   generator,
 		ServiceName: cfg.BaseURL,
+		db:          db,
+		maxBatchSize: cfg.BatchMaxSize,
+		idempotencyKeyTTL: cfg.IdempotencyKeyTTL,
+		dedupScope: cfg.DedupScope,
 		logger: logger,
 	}
-	logger.Info("the link compressor success init")
-	return lc
+	logger.Infof("the link compressor success init, short code strategy: %s, dedup scope: %s", cfg.ShortCodeStrategy, cfg.DedupScope)
+	return lc, nil
 }
 
+// Shorten - сокращает URL record.OriginURL и сохраняет запись в БД, если для него еще нет ссылки
+//			 в области дедупликации DEDUP_SCOPE. exists=true - вернули существующую ссылку, новая не создана.
+//			 Если сгенерированный код уже занят другим URL, пробуем следующего кандидата.
+//			 Вернет ErrShortCodeExhausted если свободный код так и не нашелся.
+func (l *LinkCompressor) Shorten(ctx context.Context, record models.Record) (shortURL string, exists bool, err error) {
+	dedupOwner := l.dedupOwner(record.UserID)
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		record.ShortURL, err = l.SortURL(ctx, record.OriginURL, attempt)
+		if err != nil {
+			return "", false, err
+		}
+		saved, created, err := l.db.Upsert(ctx, record, dedupOwner, time.Now())
+		var collision *models.CollisionError
+		if errors.As(err, &collision) {
+			l.logger.Printf("short url collision: %s, attempt: %d", record.ShortURL, attempt)
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return "", false, storageErr(err)
+		}
+		return saved.ShortURL, !created, nil
+	}
+	return "", false, exhausted(lastErr)
+}
 
-// SortURL - собирает сокращенный URL
-// 			 TODO: Опечатка. И переименовать в отражающее суть: MakeShortURL
-func (l *LinkCompressor) SortURL(originalLink string) string {
-	path := l.shortPath(originalLink)
-	url := fmt.Sprintf("%s/%s", l.ServiceName, path[:l.urlLength])
-	return url
+// exhausted - последняя коллизия клиенту не отдается: для него это не "URL уже есть", а ошибка сервиса
+func exhausted(lastErr error) error {
+	return fmt.Errorf("%w after %d attempts, last: %s", ErrShortCodeExhausted, maxAttempts, lastErr)
+}
+
+// storageErr - URL прошел проверку длины, но не поместился в колонку БД: для клиента это та же ошибка url_too_long
+func storageErr(err error) error {
+	if errors.Is(err, db.ErrTooLong) {
+		return invalidURL(CodeURLTooLong, "url does not fit into storage")
+	}
+	return err
 }
 
-// ShortPath алгоритм сокращения URL на основе base58 - для получения
-//					 набора символов которые человеком могут читатся однозначно.
-func (l *LinkCompressor) shortPath(originalLink string) string {
-	generatedNumber := new(big.Int).SetBytes([]byte(originalLink)).Uint64()
-	finalString := l.base58Encoded([]byte(fmt.Sprintf("%d", generatedNumber)))
-	return finalString[:l.urlLength]
+// dedupOwner - владелец дедупликации для ссылки пользователя userID: он сам или "" - все пользователи
+func (l *LinkCompressor) dedupOwner(userID string) string {
+	if l.dedupScope == DedupScopeGlobal {
+		return ""
+	}
+	return userID
 }
 
-func (l *LinkCompressor) base58Encoded(bytes []byte) string {
-	encoding := base58.BitcoinEncoding
-	encoded, err := encoding.Encode(bytes)
+// SortURL - собирает сокращенный URL для попытки attempt
+// 			 TODO: Опечатка. И переименовать в отражающее суть: MakeShortURL
+func (l *LinkCompressor) SortURL(ctx context.Context, originalLink string, attempt int) (string, error) {
+	path, err := l.generator.Generate(ctx, originalLink, attempt)
 	if err != nil {
-		log.Fatal(err.Error())
+		return "", err
 	}
-	return string(encoded)
+	url := fmt.Sprintf("%s/%s", l.ServiceName, path)
+	return url, nil
 }
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+func TestLinkCompressor_Shorten(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5}
+	db := inmemorydb.NewInMemoryDB()
+	lc, err := NewLinkCompressor(cfg, db, logger.New())
+	require.NoError(t, err)
+
+	originURL := "https://www.youtube.com/watch?v=09nmlZjxRFs"
+	otherURL := "https://example.com/other"
+
+	// Занимаем первого кандидата чужой ссылкой
+	firstCandidate, err := lc.SortURL(ctx, originURL, 0)
+	require.NoError(t, err)
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: firstCandidate, OriginURL: otherURL, UserID: "user_1"}))
+
+	// Коллизия не перезаписывает чужую ссылку, а выбирает следующего кандидата
+	shortURL, exists, err := lc.Shorten(ctx, models.Record{OriginURL: originURL, UserID: "user_2"})
+	require.NoError(t, err)
+	assert.False(t, exists)
+	secondCandidate, err := lc.SortURL(ctx, originURL, 1)
+	require.NoError(t, err)
+	assert.Equal(t, secondCandidate, shortURL)
+
+	existURL, err := db.Get(ctx, firstCandidate, "")
+	require.NoError(t, err)
+	assert.Equal(t, otherURL, existURL)
+
+	// Повторное сокращение того же URL вернет ту же ссылку
+	again, exists, err := lc.Shorten(ctx, models.Record{OriginURL: originURL, UserID: "user_2"})
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, shortURL, again)
+}
+
+func TestLinkCompressor_ShortenDedup(t *testing.T) {
+	ctx := context.Background()
+	originURL := "https://example.com/dedup"
+	for _, tt := range []struct {
+		scope string
+		// shared - второй пользователь получает ссылку первого
+		shared bool
+	}{
+		{scope: DedupScopeUser},
+		{scope: DedupScopeGlobal, shared: true},
+	} {
+		t.Run(tt.scope, func(t *testing.T) {
+			// random генерирует каждый раз новый код: одинаковый ответ дает только дедупликация
+			cfg := config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5, ShortCodeStrategy: "random", DedupScope: tt.scope}
+			db := inmemorydb.NewInMemoryDB()
+			lc, err := NewLinkCompressor(cfg, db, logger.New())
+			require.NoError(t, err)
+
+			first, exists, err := lc.Shorten(ctx, models.Record{OriginURL: originURL, UserID: "user_1"})
+			require.NoError(t, err)
+			assert.False(t, exists)
+			again, exists, err := lc.Shorten(ctx, models.Record{OriginURL: originURL, UserID: "user_1"})
+			require.NoError(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, first, again)
+
+			other, exists, err := lc.Shorten(ctx, models.Record{OriginURL: originURL, UserID: "user_2"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.shared, exists)
+			assert.Equal(t, tt.shared, first == other)
+		})
+	}
+}
+
+func TestLinkCompressor_ShortenBatch(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5, BatchMaxSize: 3, IdempotencyKeyTTL: time.Hour}
+	db := inmemorydb.NewInMemoryDB()
+	lc, err := NewLinkCompressor(cfg, db, logger.New())
+	require.NoError(t, err)
+
+	// Первый кандидат второй ссылки занят чужой ссылкой
+	taken, err := lc.SortURL(ctx, "https://example.com/2", 0)
+	require.NoError(t, err)
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: taken, OriginURL: "https://example.com/other", UserID: "user_1"}))
+
+	records := []models.Record{
+		{OriginURL: "https://example.com/1", UserID: "user_2"},
+		{OriginURL: "https://example.com/2", UserID: "user_2"},
+	}
+	now := time.Now()
+	idempotency := lc.NewIdempotency("user_2", "key", []byte("body"), now, func(shortURLs []string, exists []bool) ([]byte, error) {
+		return []byte(strings.Join(shortURLs, ",")), nil
+	})
+	shortURLs, exists, err := lc.ShortenBatch(ctx, records, idempotency)
+	require.NoError(t, err)
+	require.Len(t, shortURLs, 2)
+	assert.Equal(t, []bool{false, false}, exists)
+	second, err := lc.SortURL(ctx, "https://example.com/2", 1)
+	require.NoError(t, err)
+	assert.Equal(t, second, shortURLs[1])
+
+	// Ответ для ключа идемпотентности построен по итоговым коротким URL
+	saved, err := db.GetIdempotencyKey(ctx, "user_2", "key", now)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(shortURLs, ","), string(saved.Response))
+	assert.Equal(t, RequestHash([]byte("body")), saved.RequestHash)
+	records2, err := db.GetUserURL(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Len(t, records2, 2)
+
+	// Ссылки пачки и одиночные ссылки дедуплицируются вместе
+	shortURL, exist, err := lc.Shorten(ctx, models.Record{OriginURL: "https://example.com/1", UserID: "user_2"})
+	require.NoError(t, err)
+	assert.True(t, exist)
+	assert.Equal(t, shortURLs[0], shortURL)
+	single, _, err := lc.Shorten(ctx, models.Record{OriginURL: "https://example.com/4", UserID: "user_2"})
+	require.NoError(t, err)
+	again, exists, err := lc.ShortenBatch(ctx, []models.Record{
+		{OriginURL: "https://example.com/4", UserID: "user_2"},
+		{OriginURL: "https://example.com/2", UserID: "user_2"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{single, shortURLs[1]}, again)
+	assert.Equal(t, []bool{true, true}, exists)
+
+	// Тот же URL у другого пользователя: кандидат занят чужой ссылкой, берем следующий
+	otherURLs, exists, err := lc.ShortenBatch(ctx, []models.Record{{OriginURL: "https://example.com/1", UserID: "user_3"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false}, exists)
+	assert.NotEqual(t, shortURLs[0], otherURLs[0])
+	_, err = db.GetUserRecord(ctx, otherURLs[0], "user_3")
+	assert.NoError(t, err)
+
+	// Ключ уже занят: пачка не сохраняется
+	_, _, err = lc.ShortenBatch(ctx, []models.Record{{OriginURL: "https://example.com/3", UserID: "user_2"}}, idempotency)
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	records2, err = db.GetUserURL(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Len(t, records2, 3)
+
+	_, _, err = lc.ShortenBatch(ctx, nil, nil)
+	assert.True(t, errors.Is(err, ErrEmptyBatch))
+	_, _, err = lc.ShortenBatch(ctx, make([]models.Record, 4), nil)
+	assert.True(t, errors.Is(err, ErrBatchTooLarge))
+}
+
+// fixedGenerator - всегда один и тот же код, чтобы исчерпать попытки
+type fixedGenerator struct{}
+
+func (fixedGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	return "taken", nil
+}
+
+func TestLinkCompressor_ShortCodeExhausted(t *testing.T) {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	lc, err := NewLinkCompressor(config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5}, db, logger.New())
+	require.NoError(t, err)
+	lc.generator = fixedGenerator{}
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://127.0.0.1:8080/taken", OriginURL: "https://example.com/other", UserID: "user_1"}))
+
+	// Коды кончились - это ошибка сервиса, а не "URL уже есть"
+	_, _, err = lc.Shorten(ctx, models.Record{OriginURL: "https://example.com/1", UserID: "user_2"})
+	assert.True(t, errors.Is(err, ErrShortCodeExhausted))
+	assert.False(t, errors.Is(err, models.ErrConflict))
+	_, _, err = lc.ShortenBatch(ctx, []models.Record{{OriginURL: "https://example.com/1", UserID: "user_2"}}, nil)
+	assert.True(t, errors.Is(err, ErrShortCodeExhausted))
+	assert.False(t, errors.Is(err, models.ErrConflict))
+}
+
+// tooLongDB - БД, в колонку которой URL не помещается
+type tooLongDB struct {
+	db.Repository
+}
+
+func (tooLongDB) Upsert(context.Context, models.Record, string, time.Time) (models.Record, bool, error) {
+	return models.Record{}, false, fmt.Errorf("sql | upsert url: %w", models.ErrTooLong)
+}
+
+func (tooLongDB) AddBatch(context.Context, []models.Record, string, time.Time, models.IdempotencyKeyFunc) ([]models.Record, []bool, error) {
+	return nil, nil, fmt.Errorf("sql | insert url batch: %w", models.ErrTooLong)
+}
+
+func TestLinkCompressor_StorageTooLong(t *testing.T) {
+	ctx := context.Background()
+	lc, err := NewLinkCompressor(config.Config{BaseURL: "http://127.0.0.1:8080", URLLength: 5}, tooLongDB{inmemorydb.NewInMemoryDB()}, logger.New())
+	require.NoError(t, err)
+
+	// Клиент получает ту же ошибку проверки, что и от валидатора
+	_, _, err = lc.Shorten(ctx, models.Record{OriginURL: "https://example.com/long", UserID: "user_1"})
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, CodeURLTooLong, validationErr.Code)
+	assert.True(t, errors.Is(err, ErrInvalidURL))
+	_, _, err = lc.ShortenBatch(ctx, []models.Record{{OriginURL: "https://example.com/long", UserID: "user_1"}}, nil)
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, CodeURLTooLong, validationErr.Code)
+}
+
+func TestNewLinkCompressor_InvalidConfig(t *testing.T) {
+	// Ошибка конфига возвращается вызывающему: main закроет БД до выхода
+	_, err := NewLinkCompressor(config.Config{ShortCodeStrategy: "sequential"}, inmemorydb.NewInMemoryDB(), logger.New())
+	assert.Error(t, err)
+	_, err = NewLinkCompressor(config.Config{DedupScope: "team"}, inmemorydb.NewInMemoryDB(), logger.New())
+	assert.Error(t, err)
+}
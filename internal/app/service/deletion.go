@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrQueueFull - очередь удаления заполнена, клиенту стоит повторить запрос позже
+var ErrQueueFull = errors.New("deletion queue is full")
+
+// maxDeleteRetryInterval - предел паузы между повторами неудачного удаления
+const maxDeleteRetryInterval = time.Minute
+
+// URLDeleter - фоновое удаление ссылок пользователей.
+//				Хендлер только сохраняет задание в БД и ставит его в ограниченную очередь,
+//				Run собирает задания разных пользователей в пачки по batchSize ссылок или раз в flushInterval
+//				и отдает пачки workers обработчикам. Задание убирается из БД только после удаления ссылок,
+//				поэтому невыполненные задания подхватываются после рестарта
+type URLDeleter struct {
+	db db.Repository
+	// queue - сохраненные задания, ждущие обработки; slots - занятые места в очереди
+	queue         chan models.DeletionJob
+	slots         chan struct{}
+	batchSize     int
+	flushInterval time.Duration
+	workers       int
+	retryInterval time.Duration
+	logger        *logrus.Logger
+}
+
+// deletionTask - задание в пачке, queued - задание пришло через Enqueue и держит место в очереди
+type deletionTask struct {
+	job    models.DeletionJob
+	queued bool
+}
+
+// NewURLDeleter - вернет объект фонового удаления, обработка запускается через Run
+func NewURLDeleter(db db.Repository, cfg config.Config, logger *logrus.Logger) *URLDeleter {
+	d := &URLDeleter{
+		db:            db,
+		queue:         make(chan models.DeletionJob, cfg.DeleteQueueSize),
+		slots:         make(chan struct{}, cfg.DeleteQueueSize),
+		batchSize:     cfg.DeleteBatchSize,
+		flushInterval: cfg.DeleteFlushInterval,
+		workers:       cfg.DeleteWorkers,
+		retryInterval: cfg.DeleteRetryInterval,
+		logger:        logger,
+	}
+	if d.workers < 1 {
+		d.workers = 1
+	}
+	if d.flushInterval <= 0 {
+		d.flushInterval = time.Second
+	}
+	if d.retryInterval <= 0 {
+		d.retryInterval = time.Second
+	}
+	return d
+}
+
+// RetryAfter - через сколько клиенту повторить запрос, если очередь заполнена
+func (d *URLDeleter) RetryAfter() time.Duration {
+	if d.flushInterval < time.Second {
+		return time.Second
+	}
+	return d.flushInterval
+}
+
+// Enqueue - сохраняет задание на удаление ссылок пользователя userID по идентификаторам (сокращенная часть url)
+//			 и ставит его в очередь. Чужие и несуществующие ссылки при обработке пропускаются.
+//			 Если очередь заполнена - вернет ErrQueueFull, задание не сохраняется
+func (d *URLDeleter) Enqueue(ctx context.Context, userID string, identities []string) error {
+	if len(identities) == 0 {
+		return nil
+	}
+	// Место резервируем до записи в БД, чтобы сохраненное задание всегда помещалось в канал
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		return ErrQueueFull
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		<-d.slots
+		return err
+	}
+	job := models.DeletionJob{
+		ID:         hex.EncodeToString(id),
+		UserID:     userID,
+		Identities: identities,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := d.db.AddDeletionJob(ctx, job); err != nil {
+		<-d.slots
+		return err
+	}
+	d.queue <- job
+	return nil
+}
+
+// Run - собирает задания в пачки и удаляет ссылки, пока не отменен ctx.
+//		 Перед выходом отдает обработчикам то, что осталось в очереди, и дожидается их
+func (d *URLDeleter) Run(ctx context.Context) {
+	batches := make(chan []deletionTask)
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				d.process(ctx, batch)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(batches)
+
+	var batch []deletionTask
+	var size int
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Пока обработчики заняты, очередь не разбираем: при переполнении Enqueue вернет ErrQueueFull
+		batches <- batch
+		batch, size = nil, 0
+	}
+	add := func(task deletionTask) {
+		batch = append(batch, task)
+		size += len(task.job.Identities)
+		if size >= d.batchSize {
+			flush()
+		}
+	}
+	// Задания, не выполненные до прошлой остановки. Если БД недоступна - пробуем на следующем тике
+	loaded := d.loadPending(add)
+
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+	d.logger.Info("the url deleter run with ", d.workers, " workers")
+	for {
+		select {
+		case <-ctx.Done():
+			// Забираем то, что осталось в очереди
+			for {
+				select {
+				case job := <-d.queue:
+					add(deletionTask{job: job, queued: true})
+				default:
+					flush()
+					return
+				}
+			}
+		case job := <-d.queue:
+			add(deletionTask{job: job, queued: true})
+		case <-ticker.C:
+			if !loaded {
+				loaded = d.loadPending(add)
+			}
+			flush()
+		}
+	}
+}
+
+// loadPending - добавляет в пачку невыполненные задания из БД, false - БД недоступна
+func (d *URLDeleter) loadPending(add func(deletionTask)) bool {
+	jobs, err := d.db.GetDeletionJobs(context.Background())
+	if err != nil {
+		d.logger.Print("GetDeletionJobs: ", err)
+		return false
+	}
+	if len(jobs) != 0 {
+		d.logger.Info("the url deleter resume ", len(jobs), " jobs")
+	}
+	for _, job := range jobs {
+		add(deletionTask{job: job})
+	}
+	return true
+}
+
+// process - удаляет ссылки пачки. При ошибке повторяет с растущей паузой, пока не отменен ctx:
+//			 невыполненные задания остаются в БД до следующего запуска
+func (d *URLDeleter) process(ctx context.Context, batch []deletionTask) {
+	defer func() {
+		for _, task := range batch {
+			if task.queued {
+				<-d.slots
+			}
+		}
+	}()
+	interval := d.retryInterval
+	for {
+		err := d.delete(batch)
+		if err == nil {
+			return
+		}
+		d.logger.Print("delete urls: ", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxDeleteRetryInterval {
+			interval = maxDeleteRetryInterval
+		}
+	}
+}
+
+// delete - одним запросом помечает удаленными ссылки всех заданий пачки и отмечает задания выполненными.
+//			Повтор безопасен: уже удаленные ссылки и выполненные задания пропускаются.
+//			Пишем с отдельным контекстом, чтобы не потерять пачку при остановке
+func (d *URLDeleter) delete(batch []deletionTask) error {
+	ctx := context.Background()
+	var urlsID []int
+	jobIDs := make([]string, 0, len(batch))
+	for _, task := range batch {
+		for _, identity := range task.job.Identities {
+			id, err := d.db.GetShortURLByIdentityPath(ctx, identity, task.job.UserID)
+			if errors.Is(err, db.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			urlsID = append(urlsID, id)
+		}
+		jobIDs = append(jobIDs, task.job.ID)
+	}
+	if err := d.db.URLBulkDelete(ctx, urlsID); err != nil {
+		return err
+	}
+	return d.db.CompleteDeletionJobs(ctx, jobIDs)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+// flakyRepo - первые failures вызовов URLBulkDelete отвечают ErrUnavailable
+type flakyRepo struct {
+	db.Repository
+	failures int32
+}
+
+func (r *flakyRepo) URLBulkDelete(ctx context.Context, urlsID []int) error {
+	if atomic.AddInt32(&r.failures, -1) >= 0 {
+		return fmt.Errorf("bulk delete: %w", models.ErrUnavailable)
+	}
+	return r.Repository.URLBulkDelete(ctx, urlsID)
+}
+
+func newTestDeleterDB(t *testing.T) db.Repository {
+	ctx := context.Background()
+	db := inmemorydb.NewInMemoryDB()
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/abc", OriginURL: "https://example.com/1", UserID: "user"}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "http://localhost/def", OriginURL: "https://example.com/2", UserID: "user-2"}))
+	return db
+}
+
+func deleted(repo db.Repository, shortURL string) func() bool {
+	return func() bool {
+		_, err := repo.Get(context.Background(), shortURL, "")
+		return errors.Is(err, models.ErrDeleted)
+	}
+}
+
+func TestURLDeleter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.Config{DeleteQueueSize: 10, DeleteBatchSize: 100, DeleteFlushInterval: 10 * time.Millisecond, DeleteWorkers: 2}
+	repo := newTestDeleterDB(t)
+
+	// Задание, оставшееся с прошлого запуска
+	require.NoError(t, repo.AddDeletionJob(ctx, models.DeletionJob{ID: "old", UserID: "user-2", Identities: []string{"def"}, CreatedAt: time.Now()}))
+
+	deleter := NewURLDeleter(repo, cfg, logger.New())
+	done := make(chan struct{})
+	go func() {
+		deleter.Run(ctx)
+		close(done)
+	}()
+
+	// Чужие и несуществующие ссылки пропускаются
+	require.NoError(t, deleter.Enqueue(ctx, "user", []string{"abc", "def", "unknown"}))
+	assert.Eventually(t, deleted(repo, "http://localhost/abc"), time.Second, 10*time.Millisecond)
+	assert.Eventually(t, deleted(repo, "http://localhost/def"), time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		jobs, err := repo.GetDeletionJobs(ctx)
+		return err == nil && len(jobs) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestURLDeleter_QueueFull(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{DeleteQueueSize: 1, DeleteBatchSize: 100, DeleteFlushInterval: time.Hour}
+	repo := newTestDeleterDB(t)
+	deleter := NewURLDeleter(repo, cfg, logger.New())
+
+	// Run не запущен: место в очереди одно, второе задание не сохраняется
+	require.NoError(t, deleter.Enqueue(ctx, "user", []string{"abc"}))
+	assert.True(t, errors.Is(deleter.Enqueue(ctx, "user", []string{"abc"}), ErrQueueFull))
+	require.NoError(t, deleter.Enqueue(ctx, "user", nil))
+	jobs, err := repo.GetDeletionJobs(ctx)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1)
+}
+
+func TestURLDeleter_Retry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.Config{DeleteQueueSize: 10, DeleteBatchSize: 1, DeleteFlushInterval: time.Hour, DeleteRetryInterval: 10 * time.Millisecond}
+	repo := &flakyRepo{Repository: newTestDeleterDB(t), failures: 2}
+	deleter := NewURLDeleter(repo, cfg, logger.New())
+	go deleter.Run(ctx)
+
+	// Пачка из одной ссылки уходит сразу, после двух ошибок БД удаление проходит и место в очереди освобождается
+	require.NoError(t, deleter.Enqueue(ctx, "user", []string{"abc"}))
+	assert.Eventually(t, deleted(repo, "http://localhost/abc"), time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return len(deleter.slots) == 0
+	}, time.Second, 10*time.Millisecond)
+}
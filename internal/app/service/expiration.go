@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidExpiration - пользователь передал некорректный срок жизни ссылки
+var ErrInvalidExpiration = errors.New("invalid expiration")
+
+// CodeInvalidExpiration - код ошибки срока жизни для элемента пачки, как коды ValidationError
+const CodeInvalidExpiration = "invalid_expiration"
+
+// ExpiresAt - вычисляет момент, после которого ссылка перестает работать.
+//			   Можно передать либо абсолютную дату expiresAt, либо ttl в секундах.
+//			   nil - ссылка бессрочная.
+func ExpiresAt(now time.Time, expiresAt *time.Time, ttl int64) (*time.Time, error) {
+	if expiresAt != nil && ttl != 0 {
+		return nil, fmt.Errorf("%w: use either expires_at or ttl", ErrInvalidExpiration)
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("%w: ttl must be positive", ErrInvalidExpiration)
+	}
+	if ttl > 0 {
+		t := now.Add(time.Duration(ttl) * time.Second)
+		return &t, nil
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, fmt.Errorf("%w: expires_at is in the past", ErrInvalidExpiration)
+	}
+	return expiresAt, nil
+}
+
+// ExpiredSweeper - фоновая задача, которая периодически удаляет из БД ссылки с истекшим сроком жизни
+type ExpiredSweeper struct {
+	db       db.Repository
+	interval time.Duration
+	logger   *logrus.Logger
+}
+
+// NewExpiredSweeper - вернет объект фоновой очистки, запускается через Run
+func NewExpiredSweeper(db db.Repository, interval time.Duration, logger *logrus.Logger) *ExpiredSweeper {
+	return &ExpiredSweeper{
+		db:       db,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Run - каждые interval удаляет ссылки с истекшим сроком жизни, пока не отменен ctx
+func (s *ExpiredSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	s.logger.Info("the expired sweeper run with interval ", s.interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := s.db.DeleteExpired(ctx, now)
+			if err != nil {
+				s.logger.Print("DeleteExpired: ", err)
+				continue
+			}
+			if deleted > 0 {
+				s.logger.Infof("the expired sweeper removed %d urls", deleted)
+			}
+			// Заодно убираем истекшие ключи идемпотентности
+			if _, err = s.db.DeleteIdempotencyKeys(ctx, now); err != nil {
+				s.logger.Print("DeleteIdempotencyKeys: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/models"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		ttl       int64
+		want      *time.Time
+		wantErr   bool
+	}{
+		{name: "without expiration"},
+		{name: "ttl", ttl: 3600, want: &future},
+		{name: "expires_at", expiresAt: &future, want: &future},
+		{name: "expires_at in the past", expiresAt: &past, wantErr: true},
+		{name: "negative ttl", ttl: -1, wantErr: true},
+		{name: "both ttl and expires_at", expiresAt: &future, ttl: 60, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpiresAt(now, tt.expiresAt, tt.ttl)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidExpiration))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpiredSweeper_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := inmemorydb.NewInMemoryDB()
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "expired", OriginURL: "https://example.com/1", ExpiresAt: &past}))
+	require.NoError(t, db.AddAlias(ctx, models.Record{ShortURL: "alive", OriginURL: "https://example.com/2", ExpiresAt: &future}))
+
+	// Истекшая ссылка больше не отдается, даже если еще не удалена
+	_, err := db.Get(ctx, "expired", "")
+	assert.True(t, errors.Is(err, models.ErrExpired))
+
+	go NewExpiredSweeper(db, 10*time.Millisecond, logger.New()).Run(ctx)
+
+	// После очистки истекшей ссылки нет в БД
+	assert.Eventually(t, func() bool {
+		_, err := db.Get(ctx, "expired", "")
+		return errors.Is(err, models.ErrNotFound)
+	}, time.Second, 10*time.Millisecond)
+
+	originURL, err := db.Get(ctx, "alive", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/2", originURL)
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+
+	"github.com/itchyny/base58-go"
+)
+
+// Стратегии генерации кода короткого URL, выбираются через config.ShortCodeStrategy
+const (
+	StrategyHash    = "hash"
+	StrategyCounter = "counter"
+	StrategyRandom  = "random"
+	StrategyHashids = "hashids"
+	StrategyHMAC    = "hmac"
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ShortCodeGenerator - стратегия генерации кода (path) короткого URL.
+//						attempt - номер попытки, больше нуля если предыдущий код оказался занят.
+type ShortCodeGenerator interface {
+	Generate(ctx context.Context, originURL string, attempt int) (string, error)
+}
+
+// SequenceStore - где живут счетчики генераторов, см. db.Repository.ReserveSequence
+type SequenceStore interface {
+	ReserveSequence(ctx context.Context, name string, size uint64) (uint64, error)
+}
+
+// NewShortCodeGenerator - фабрика: вернет генератор для стратегии из конфига.
+//						   Счетчики стратегий counter и hashids хранятся в store
+func NewShortCodeGenerator(cfg config.Config, store SequenceStore) (ShortCodeGenerator, error) {
+	switch cfg.ShortCodeStrategy {
+	case "", StrategyHash:
+		return &hashGenerator{length: cfg.URLLength}, nil
+	case StrategyCounter:
+		return &counterGenerator{seq: &sequence{name: StrategyCounter, store: store}}, nil
+	case StrategyRandom:
+		return &randomGenerator{length: cfg.URLLength}, nil
+	case StrategyHashids:
+		return newHashidsGenerator(cfg.URLLength, cfg.ShortCodeSecret, store), nil
+	case StrategyHMAC:
+		if len(cfg.ShortCodeSecret) == 0 {
+			return nil, fmt.Errorf("short code strategy %s requires a secret", StrategyHMAC)
+		}
+		return &hmacGenerator{length: cfg.URLLength, secret: []byte(cfg.ShortCodeSecret)}, nil
+	}
+	return nil, fmt.Errorf("unknown short code strategy: %s", cfg.ShortCodeStrategy)
+}
+
+// codeLength - удлиняем код на один символ каждые attemptsPerLength неудачных попыток
+func codeLength(length int, attempt int) int {
+	return length + attempt/attemptsPerLength
+}
+
+// hashGenerator - base58 от sha256 URL. Детерминированный: одинаковые URL дают одинаковый код.
+type hashGenerator struct {
+	length int
+}
+
+func (g *hashGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	data := originURL
+	if attempt > 0 {
+		data = fmt.Sprintf("%s#%d", originURL, attempt)
+	}
+	sum := sha256.Sum256([]byte(data))
+	generatedNumber := new(big.Int).SetBytes(sum[:])
+	encoded, err := base58.BitcoinEncoding.Encode([]byte(generatedNumber.String()))
+	if err != nil {
+		return "", err
+	}
+	return string(encoded[:codeLength(g.length, attempt)]), nil
+}
+
+// sequenceBlockSize - сколько номеров счетчика резервируем в хранилище за раз.
+//					   После рестарта недоиспользованный остаток блока пропускается
+const sequenceBlockSize = 100
+
+// sequence - счетчик, который переживает рестарт: номера берем из store блоками по sequenceBlockSize
+type sequence struct {
+	mu    sync.Mutex
+	name  string
+	store SequenceStore
+	// next, end - еще не выданная часть зарезервированного блока [next, end)
+	next uint64
+	end  uint64
+}
+
+// take - пропускает skip номеров и вернет следующий
+func (s *sequence) take(ctx context.Context, skip uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next+skip >= s.end {
+		size := uint64(sequenceBlockSize)
+		if skip >= size {
+			size = skip + 1
+		}
+		start, err := s.store.ReserveSequence(ctx, s.name, size)
+		if err != nil {
+			return 0, fmt.Errorf("reserve %s sequence: %w", s.name, err)
+		}
+		// Пропускаем номера уже в новом блоке: его начало не меньше конца прежнего
+		s.next, s.end = start, start+size
+	}
+	n := s.next + skip
+	s.next = n + 1
+	return n, nil
+}
+
+// counterGenerator - последовательный счетчик в base62. Самые короткие коды, но предсказуемые.
+//					  Счетчик хранится в БД и продолжается после рестарта. Код может быть занят алиасом
+//					  или ссылкой, созданной до появления счетчика, - тогда перепрыгиваем вперед с удвоением шага.
+type counterGenerator struct {
+	seq *sequence
+}
+
+func (g *counterGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	var skip uint64
+	if attempt > 0 {
+		skip = 1<<attempt - 1
+	}
+	n, err := g.seq.take(ctx, skip)
+	if err != nil {
+		return "", err
+	}
+	return base62Encode(n), nil
+}
+
+// randomGenerator - случайный код из crypto/rand. Непредсказуемый и недетерминированный.
+type randomGenerator struct {
+	length int
+}
+
+func (g *randomGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	length := codeLength(g.length, attempt)
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, length)
+	for i, b := range buf {
+		code[i] = base62Alphabet[int(b)%len(base62Alphabet)]
+	}
+	return string(code), nil
+}
+
+// hashidsGenerator - обфусцированная последовательность в духе hashids:
+//					  номер из счетчика в БД биективно перемешивается в пространстве 62^length
+//					  и кодируется перемешанным по соли алфавитом. Коды короткие, но не идут подряд.
+type hashidsGenerator struct {
+	seq      *sequence
+	length   int
+	alphabet string
+}
+
+// hashidsMultiplier - взаимно простой с 62 множитель, дает биекцию по модулю 62^length
+const hashidsMultiplier = 1580030173
+
+func newHashidsGenerator(length int, salt string, store SequenceStore) *hashidsGenerator {
+	return &hashidsGenerator{
+		seq:      &sequence{name: StrategyHashids, store: store},
+		length:   length,
+		alphabet: shuffleAlphabet(base62Alphabet, salt),
+	}
+}
+
+func (g *hashidsGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	n, err := g.seq.take(ctx, 0)
+	if err != nil {
+		return "", err
+	}
+	// Подбираем минимальную длину, в которую помещается номер
+	length := codeLength(g.length, attempt)
+	space := new(big.Int).Exp(big.NewInt(int64(len(g.alphabet))), big.NewInt(int64(length)), nil)
+	for space.Cmp(new(big.Int).SetUint64(n)) <= 0 {
+		length++
+		space.Mul(space, big.NewInt(int64(len(g.alphabet))))
+	}
+	x := new(big.Int).SetUint64(n)
+	x.Mul(x, big.NewInt(hashidsMultiplier))
+	x.Mod(x, space)
+
+	code := make([]byte, length)
+	base := big.NewInt(int64(len(g.alphabet)))
+	mod := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		x.DivMod(x, base, mod)
+		code[i] = g.alphabet[mod.Int64()]
+	}
+	return string(code), nil
+}
+
+// shuffleAlphabet - детерминированно перемешивает алфавит по соли
+func shuffleAlphabet(alphabet string, salt string) string {
+	result := []byte(alphabet)
+	if len(salt) == 0 {
+		return string(result)
+	}
+	sum := sha256.Sum256([]byte(salt))
+	seed := binary.BigEndian.Uint64(sum[:8])
+	for i := len(result) - 1; i > 0; i-- {
+		// xorshift64
+		seed ^= seed << 13
+		seed ^= seed >> 7
+		seed ^= seed << 17
+		j := int(seed % uint64(i+1))
+		result[i], result[j] = result[j], result[i]
+	}
+	return string(result)
+}
+
+// hmacGenerator - base62 от HMAC-SHA256 URL с секретом. Детерминированный, но без секрета код не угадать.
+type hmacGenerator struct {
+	length int
+	secret []byte
+}
+
+func (g *hmacGenerator) Generate(ctx context.Context, originURL string, attempt int) (string, error) {
+	h := hmac.New(sha256.New, g.secret)
+	h.Write([]byte(originURL))
+	if attempt > 0 {
+		h.Write([]byte(fmt.Sprintf("#%d", attempt)))
+	}
+	encoded := base62Encode(binary.BigEndian.Uint64(h.Sum(nil)[:8]))
+	// 64 бита дают до 11 символов base62, дополняем слева до нужной длины
+	length := codeLength(g.length, attempt)
+	for len(encoded) < length {
+		encoded = "0" + encoded
+	}
+	return encoded[:length], nil
+}
+
+// base62Encode - кодирует число в base62
+func base62Encode(n uint64) string {
+	if n == 0 {
+		return string(base62Alphabet[0])
+	}
+	var code []byte
+	for n > 0 {
+		code = append([]byte{base62Alphabet[n%62]}, code...)
+		n /= 62
+	}
+	return string(code)
+}
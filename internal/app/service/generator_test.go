@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/file"
+	"github.com/yury-nazarov/shorturl/internal/app/repository/db/inmemory"
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+func TestNewShortCodeGenerator(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		strategy      string
+		secret        string
+		deterministic bool
+		wantErr       bool
+	}{
+		{name: "default", strategy: "", deterministic: true},
+		{name: "hash", strategy: StrategyHash, deterministic: true},
+		{name: "counter", strategy: StrategyCounter},
+		{name: "random", strategy: StrategyRandom},
+		{name: "hashids", strategy: StrategyHashids, secret: "salt"},
+		{name: "hmac", strategy: StrategyHMAC, secret: "secret", deterministic: true},
+		{name: "hmac without secret", strategy: StrategyHMAC, wantErr: true},
+		{name: "unknown", strategy: "md5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{URLLength: 5, ShortCodeStrategy: tt.strategy, ShortCodeSecret: tt.secret}
+			g, err := NewShortCodeGenerator(cfg, inmemorydb.NewInMemoryDB())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// Разные URL дают разные коды
+			codes := map[string]bool{}
+			for i := 0; i < 1000; i++ {
+				code, err := g.Generate(ctx, fmt.Sprintf("https://example.com/%d", i), 0)
+				require.NoError(t, err)
+				assert.False(t, codes[code], "duplicate code %s", code)
+				codes[code] = true
+			}
+
+			first, err := g.Generate(ctx, "https://example.com", 0)
+			require.NoError(t, err)
+			second, err := g.Generate(ctx, "https://example.com", 0)
+			require.NoError(t, err)
+			if tt.deterministic {
+				assert.Equal(t, first, second)
+			} else {
+				assert.NotEqual(t, first, second)
+			}
+
+			// Повторная попытка дает другой код
+			retry, err := g.Generate(ctx, "https://example.com", 1)
+			require.NoError(t, err)
+			assert.NotEqual(t, first, retry)
+		})
+	}
+}
+
+func TestShortCodeGenerator_Restart(t *testing.T) {
+	ctx := context.Background()
+	dbName := filepath.Join(t.TempDir(), "db")
+	for _, strategy := range []string{StrategyCounter, StrategyHashids} {
+		t.Run(strategy, func(t *testing.T) {
+			cfg := config.Config{URLLength: 5, ShortCodeStrategy: strategy, ShortCodeSecret: "salt"}
+			codes := map[string]bool{}
+			// После рестарта счетчик продолжается с сохраненного значения, а не с нуля
+			for restart := 0; restart < 3; restart++ {
+				db, err := filedb.NewFileDB(dbName, filedb.Options{})
+				require.NoError(t, err)
+				g, err := NewShortCodeGenerator(cfg, db)
+				require.NoError(t, err)
+				for i := 0; i < 150; i++ {
+					code, err := g.Generate(ctx, "https://example.com", 0)
+					require.NoError(t, err)
+					assert.False(t, codes[code], "duplicate code %s after %d restarts", code, restart)
+					codes[code] = true
+				}
+				require.NoError(t, db.Close())
+			}
+		})
+	}
+}
+
+func TestShortCodeGenerator_CounterSkip(t *testing.T) {
+	ctx := context.Background()
+	g, err := NewShortCodeGenerator(config.Config{ShortCodeStrategy: StrategyCounter}, inmemorydb.NewInMemoryDB())
+	require.NoError(t, err)
+
+	first, err := g.Generate(ctx, "https://example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "1", first)
+	// Попытка attempt пропускает 2^attempt-1 номеров, в том числе за пределы зарезервированного блока
+	retry, err := g.Generate(ctx, "https://example.com", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "3", retry)
+	far, err := g.Generate(ctx, "https://example.com", 10)
+	require.NoError(t, err)
+	assert.Equal(t, base62Encode(101+1023), far)
+	next, err := g.Generate(ctx, "https://example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, base62Encode(101+1024), next)
+}
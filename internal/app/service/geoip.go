@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeoIP - грубое определение страны по IP из локального CSV файла.
+//		   Формат строки: сеть в нотации CIDR и ISO код страны, например: 1.0.0.0/24,AU
+//		   Первая строка может быть заголовком, строки начинающиеся с # пропускаются.
+type GeoIP struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start   net.IP
+	end     net.IP
+	country string
+}
+
+// NewGeoIP - загружает диапазоны из файла. Пустой путь - страну не определяем.
+func NewGeoIP(fileName string) (*GeoIP, error) {
+	g := &GeoIP{}
+	if len(fileName) == 0 {
+		return g, nil
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %w", err)
+		}
+		if len(line) < 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(line[0]))
+		if err != nil {
+			// Заголовок или битая строка
+			continue
+		}
+		g.ranges = append(g.ranges, ipRange{
+			start:   network.IP.To16(),
+			end:     lastIP(network).To16(),
+			country: strings.ToUpper(strings.TrimSpace(line[1])),
+		})
+	}
+	sort.Slice(g.ranges, func(i, j int) bool {
+		return bytes.Compare(g.ranges[i].start, g.ranges[j].start) < 0
+	})
+	return g, nil
+}
+
+// Country - вернет ISO код страны для ip или пустую строку
+func (g *GeoIP) Country(ip string) string {
+	parsed := net.ParseIP(ip).To16()
+	if parsed == nil {
+		return ""
+	}
+	// Последний диапазон, который начинается не позже ip
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return bytes.Compare(g.ranges[i].start, parsed) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(parsed, g.ranges[i].end) > 0 {
+		return ""
+	}
+	return g.ranges[i].country
+}
+
+// lastIP - последний адрес сети
+func lastIP(network *net.IPNet) net.IP {
+	ip := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		ip[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return ip
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoIP_Country(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "geoip.csv")
+	data := "network,country_iso_code\n" +
+		"# comment\n" +
+		"10.0.0.0/8,ru\n" +
+		"1.0.0.0/24,AU\n" +
+		"2001:db8::/32,DE\n"
+	require.NoError(t, os.WriteFile(fileName, []byte(data), 0600))
+
+	geoIP, err := NewGeoIP(fileName)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "10.1.2.3", want: "RU"},
+		{ip: "1.0.0.255", want: "AU"},
+		{ip: "1.0.1.0", want: ""},
+		{ip: "2001:db8::1", want: "DE"},
+		{ip: "192.168.0.1", want: ""},
+		{ip: "not ip", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, geoIP.Country(tt.ip))
+		})
+	}
+}
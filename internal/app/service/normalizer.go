@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+// Политики слэша в конце пути, выбираются через config.URLTrailingSlash
+const (
+	TrailingSlashKeep  = "keep"  // оставляем как прислали
+	TrailingSlashStrip = "strip" // убираем
+	TrailingSlashAdd   = "add"   // добавляем, если последний сегмент не похож на файл (в нем нет точки)
+)
+
+// URLNormalizer - приводит оригинальный URL к каноническому виду, чтобы разные записи одного адреса
+//				   давали один короткий код и находились дедупликацией: хост в нижнем регистре и в punycode,
+//				   без порта по умолчанию, пустой путь - "/", без трекинговых параметров, параметры по имени
+type URLNormalizer struct {
+	sortQuery bool
+	// stripParams - имена параметров в нижнем регистре, stripPrefixes - префиксы из шаблонов вида utm_*
+	stripParams   map[string]bool
+	stripPrefixes []string
+	trailingSlash string
+}
+
+// NewURLNormalizer - пустая политика слэша - TrailingSlashKeep
+func NewURLNormalizer(cfg config.Config) (*URLNormalizer, error) {
+	n := &URLNormalizer{
+		sortQuery:     cfg.URLSortQuery,
+		stripParams:   map[string]bool{},
+		trailingSlash: cfg.URLTrailingSlash,
+	}
+	switch n.trailingSlash {
+	case "":
+		n.trailingSlash = TrailingSlashKeep
+	case TrailingSlashKeep, TrailingSlashStrip, TrailingSlashAdd:
+	default:
+		return nil, fmt.Errorf("unknown trailing slash policy: %s", cfg.URLTrailingSlash)
+	}
+	for _, param := range cfg.URLStripParams {
+		param = strings.ToLower(strings.TrimSpace(param))
+		switch {
+		case len(param) == 0:
+		case strings.HasSuffix(param, "*"):
+			n.stripPrefixes = append(n.stripPrefixes, strings.TrimSuffix(param, "*"))
+		default:
+			n.stripParams[param] = true
+		}
+	}
+	return n, nil
+}
+
+// Normalize - меняет u на месте. Схему и хост в нижний регистр приводит URLValidator до проверок,
+//			   ошибка - хост не переводится в punycode
+func (n *URLNormalizer) Normalize(u *url.URL) error {
+	host, port := u.Hostname(), u.Port()
+	// Хост с не ASCII символами (пример.рф) храним в punycode (xn--e1afmkfd.xn--p1ai)
+	if !isASCII(host) {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return fmt.Errorf("idna host %q: %w", host, err)
+		}
+		host = ascii
+	}
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	u.Host = joinHostPort(host, port)
+
+	if len(u.Opaque) == 0 && len(u.Path) == 0 {
+		u.Path, u.RawPath = "/", ""
+	}
+	u.Path, u.RawPath = n.slash(u.Path), n.slash(u.RawPath)
+
+	u.RawQuery = n.query(u.RawQuery)
+	u.ForceQuery = false
+	return nil
+}
+
+// slash - применяет политику слэша к пути, корень "/" не трогаем
+func (n *URLNormalizer) slash(p string) string {
+	if len(p) <= 1 {
+		return p
+	}
+	switch n.trailingSlash {
+	case TrailingSlashStrip:
+		if trimmed := strings.TrimRight(p, "/"); len(trimmed) != 0 {
+			return trimmed
+		}
+		return "/"
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(p, "/") && !strings.Contains(path.Base(p), ".") {
+			return p + "/"
+		}
+	}
+	return p
+}
+
+// query - убирает трекинговые параметры и сортирует остальные по имени.
+//		   Работаем с исходной строкой: кодирование значений и порядок одноименных параметров не меняем
+func (n *URLNormalizer) query(rawQuery string) string {
+	if len(rawQuery) == 0 {
+		return ""
+	}
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if len(raw) == 0 {
+			continue
+		}
+		name := raw
+		if i := strings.Index(raw, "="); i >= 0 {
+			name = raw[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if n.strip(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+	if n.sortQuery {
+		sort.SliceStable(params, func(a, b int) bool {
+			return params[a].name < params[b].name
+		})
+	}
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// strip - параметр трекинговый и его нужно убрать
+func (n *URLNormalizer) strip(name string) bool {
+	name = strings.ToLower(name)
+	if n.stripParams[name] {
+		return true
+	}
+	for _, prefix := range n.stripPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RawURL - строка пользователя для models.Record.RawURL: пустая, если она совпала с сохраняемым URL
+func RawURL(raw string, normalized string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == normalized {
+		return ""
+	}
+	return raw
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// joinHostPort - IPv6 адрес снова берем в квадратные скобки
+func joinHostPort(host string, port string) string {
+	if len(port) != 0 {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
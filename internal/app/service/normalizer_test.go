@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+func TestURLNormalizer_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "default port", raw: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "other port", raw: "http://example.com:8443/a", want: "http://example.com:8443/a"},
+		{name: "empty path", raw: "https://example.com", want: "https://example.com/"},
+		{name: "idn host", raw: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "invalid idn host", raw: "https://ex‍ample.рф/", wantErr: true},
+		{name: "ipv6 default port", raw: "http://[::1]:80/a", want: "http://[::1]/a"},
+		{name: "query kept as is", raw: "https://example.com/a?b=1&a=2&utm_source=mail", want: "https://example.com/a?b=1&a=2&utm_source=mail"},
+		{
+			name: "sorted query without tracking params",
+			cfg:  config.Config{URLSortQuery: true, URLStripParams: []string{"UTM_*", " fbclid"}},
+			raw:  "https://example.com/a?b=1&utm_Source=mail&a=2&fbclid=x&a=1&c",
+			want: "https://example.com/a?a=2&a=1&b=1&c",
+		},
+		{name: "only tracking params", cfg: config.Config{URLStripParams: []string{"gclid"}}, raw: "https://example.com/?gclid=1", want: "https://example.com/"},
+		{name: "escaped values kept", cfg: config.Config{URLSortQuery: true}, raw: "https://example.com/?q=a%20b&p=%2F", want: "https://example.com/?p=%2F&q=a%20b"},
+		{name: "strip trailing slash", cfg: config.Config{URLTrailingSlash: TrailingSlashStrip}, raw: "https://example.com/a//", want: "https://example.com/a"},
+		{name: "strip keeps root", cfg: config.Config{URLTrailingSlash: TrailingSlashStrip}, raw: "https://example.com/", want: "https://example.com/"},
+		{name: "add trailing slash", cfg: config.Config{URLTrailingSlash: TrailingSlashAdd}, raw: "https://example.com/a/b", want: "https://example.com/a/b/"},
+		{name: "add skips files", cfg: config.Config{URLTrailingSlash: TrailingSlashAdd}, raw: "https://example.com/a/index.html", want: "https://example.com/a/index.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizer, err := NewURLNormalizer(tt.cfg)
+			require.NoError(t, err)
+			u, err := url.Parse(tt.raw)
+			require.NoError(t, err)
+			err = normalizer.Normalize(u)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, u.String())
+		})
+	}
+
+	_, err := NewURLNormalizer(config.Config{URLTrailingSlash: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestURLValidator_ValidateNormalized(t *testing.T) {
+	validator, err := NewURLValidator(config.Config{
+		BaseURL:        "http://127.0.0.1:8080",
+		URLMaxLength:   2048,
+		URLSortQuery:   true,
+		URLStripParams: []string{"utm_*"},
+	}, nil)
+	require.NoError(t, err)
+
+	// Разные записи одного адреса дают одну строку для хэша, дедупликации и хранения
+	first, err := validator.Validate("https://Example.com/a?b=1&a=2")
+	require.NoError(t, err)
+	second, err := validator.Validate("https://example.com:443/a?a=2&b=1&utm_medium=email")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a?a=2&b=1", first)
+	assert.Equal(t, first, second)
+
+	assert.Equal(t, "https://Example.com/a?b=1&a=2", RawURL(" https://Example.com/a?b=1&a=2\n", first))
+	assert.Empty(t, RawURL("https://example.com/a?a=2&b=1 ", first))
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+)
+
+var (
+	// ErrInvalidURL - прислали не абсолютный URL: 400
+	ErrInvalidURL = errors.New("invalid url")
+	// ErrRejectedURL - корректный URL, который мы не сокращаем: 422
+	ErrRejectedURL = errors.New("rejected url")
+)
+
+// Причины, по которым URL не прошел проверку, отдаем клиенту в поле code
+const (
+	CodeEmptyURL         = "empty_url"
+	CodeURLTooLong       = "url_too_long"
+	CodeMalformedURL     = "malformed_url"
+	CodeRelativeURL      = "relative_url"
+	CodeSchemeNotAllowed = "scheme_not_allowed"
+	CodeSelfReference    = "self_reference"
+	CodeBlockedURL       = "blocked_url"
+)
+
+// ValidationError - почему оригинальный URL не прошел проверку.
+//			 errors.Is(err, ErrInvalidURL) или errors.Is(err, ErrRejectedURL) вернет true
+type ValidationError struct {
+	Code   string
+	Reason string
+	// CorrelationID - элемент пачки из /api/shorten/batch, который не прошел проверку
+	CorrelationID string
+	kind          error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.Reason)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.kind
+}
+
+func invalidURL(code string, format string, args ...interface{}) error {
+	return &ValidationError{Code: code, Reason: fmt.Sprintf(format, args...), kind: ErrInvalidURL}
+}
+
+func rejectedURL(code string, format string, args ...interface{}) error {
+	return &ValidationError{Code: code, Reason: fmt.Sprintf(format, args...), kind: ErrRejectedURL}
+}
+
+// URLValidator - проверки оригинального URL перед сокращением:
+//			 длина, абсолютный URL, разрешенная схема, не ссылка на сам сервис, не в блоклисте.
+//			 Проверяем уже нормализованный URL, см. URLNormalizer
+type URLValidator struct {
+	schemes    map[string]bool
+	maxLength  int
+	// selfHosts, selfPorts - где отвечает сервис: BASE_URL и HTTP_REDIRECT_ADDRESS
+	selfHosts  map[string]bool
+	selfPorts  map[string]bool
+	normalizer *URLNormalizer
+	Blocklist  *Blocklist
+}
+
+// NewURLValidator - без схем в конфиге принимаем http и https.
+//					Блоклист загружается сразу, перечитывать его нужно через Blocklist.Run
+func NewURLValidator(cfg config.Config, blocklist *Blocklist) (*URLValidator, error) {
+	self, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("base url: %w", err)
+	}
+	normalizer, err := NewURLNormalizer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	v := &URLValidator{
+		schemes:    map[string]bool{},
+		maxLength:  cfg.URLMaxLength,
+		selfHosts:  map[string]bool{normalizeHost(self.Hostname()): true},
+		selfPorts:  map[string]bool{effectivePort(self): true},
+		normalizer: normalizer,
+		Blocklist:  blocklist,
+	}
+	// Сервер перенаправления на HTTPS отвечает на том же хосте, а если слушает конкретный адрес - и на нем
+	if len(cfg.HTTPRedirectAddress) != 0 {
+		host, port, err := net.SplitHostPort(cfg.HTTPRedirectAddress)
+		if err != nil {
+			return nil, fmt.Errorf("http redirect address: %w", err)
+		}
+		if ip := net.ParseIP(host); len(host) != 0 && (ip == nil || !ip.IsUnspecified()) {
+			v.selfHosts[normalizeHost(host)] = true
+		}
+		v.selfPorts[port] = true
+	}
+	schemes := cfg.URLAllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	for _, scheme := range schemes {
+		v.schemes[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+	return v, nil
+}
+
+// Validate - вернет URL в том виде, в котором его сохраняем, хэшируем и ищем дедупликацией:
+//			 без пробелов по краям, схема и хост в нижнем регистре, остальное - URLNormalizer.
+//			 Присланную строку для models.Record.RawURL дает RawURL. Ошибка - всегда *ValidationError
+func (v *URLValidator) Validate(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", invalidURL(CodeEmptyURL, "url is empty")
+	}
+	if v.maxLength > 0 && len(raw) > v.maxLength {
+		return "", invalidURL(CodeURLTooLong, "url is longer than %d bytes", v.maxLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", invalidURL(CodeMalformedURL, "url can not be parsed")
+	}
+	if !u.IsAbs() {
+		return "", invalidURL(CodeRelativeURL, "url must be absolute, e.g. https://example.com/path")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !v.schemes[u.Scheme] {
+		return "", rejectedURL(CodeSchemeNotAllowed, "scheme %q is not allowed", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return "", invalidURL(CodeMalformedURL, "url has no host")
+	}
+	u.Host = strings.ToLower(u.Host)
+	if err = v.normalizer.Normalize(u); err != nil {
+		return "", invalidURL(CodeMalformedURL, "url host is not a valid domain name")
+	}
+
+	if v.isSelf(u) {
+		return "", rejectedURL(CodeSelfReference, "url points to this service")
+	}
+	if v.Blocklist != nil {
+		if rule, ok := v.Blocklist.Match(u); ok {
+			return "", rejectedURL(CodeBlockedURL, "url is blocked by rule %q", rule)
+		}
+	}
+	return u.String(), nil
+}
+
+// isSelf - URL указывает на сервис: хост сервиса и порт не указан, порт по умолчанию любой схемы или порт сервиса.
+//			Схему не сравниваем: http на хост с https BASE_URL попадет в перенаправление на HTTPS и вернется к нам
+func (v *URLValidator) isSelf(u *url.URL) bool {
+	if !v.selfHosts[normalizeHost(u.Hostname())] {
+		return false
+	}
+	port := u.Port()
+	return len(port) == 0 || isDefaultPort(port) || v.selfPorts[port]
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); len(port) != 0 {
+		return port
+	}
+	return defaultPorts[strings.ToLower(u.Scheme)]
+}
+
+func isDefaultPort(port string) bool {
+	for _, p := range defaultPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPorts - порт по умолчанию для схем, которые могут указывать на сервис
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yury-nazarov/shorturl/internal/config"
+	"github.com/yury-nazarov/shorturl/internal/logger"
+)
+
+func TestURLValidator_Validate(t *testing.T) {
+	blocklistPath := filepath.Join(t.TempDir(), "blocklist")
+	require.NoError(t, os.WriteFile(blocklistPath, []byte("# phishing\nevil.com\nregexp:^https?://[^/]+/wp-login\\.php\n"), 0644))
+	blocklist, err := NewBlocklist(blocklistPath, logger.New())
+	require.NoError(t, err)
+	validator, err := NewURLValidator(config.Config{BaseURL: "http://127.0.0.1:8080", URLMaxLength: 64}, blocklist)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		raw      string
+		want     string
+		wantErr  error
+		wantCode string
+	}{
+		{name: "valid", raw: "https://example.com/path?q=1", want: "https://example.com/path?q=1"},
+		{name: "scheme and host in lower case", raw: "  HTTPS://Example.COM/Path ", want: "https://example.com/Path"},
+		{name: "empty", raw: " ", wantErr: ErrInvalidURL, wantCode: CodeEmptyURL},
+		{name: "too long", raw: "https://example.com/" + strings.Repeat("a", 64), wantErr: ErrInvalidURL, wantCode: CodeURLTooLong},
+		{name: "relative path", raw: "/path", wantErr: ErrInvalidURL, wantCode: CodeRelativeURL},
+		{name: "without scheme", raw: "example.com/path", wantErr: ErrInvalidURL, wantCode: CodeRelativeURL},
+		{name: "without host", raw: "http:///path", wantErr: ErrInvalidURL, wantCode: CodeMalformedURL},
+		{name: "malformed", raw: "http://[::1", wantErr: ErrInvalidURL, wantCode: CodeMalformedURL},
+		{name: "javascript", raw: "javascript:alert(1)", wantErr: ErrRejectedURL, wantCode: CodeSchemeNotAllowed},
+		{name: "self reference", raw: "http://127.0.0.1:8080/abc", wantErr: ErrRejectedURL, wantCode: CodeSelfReference},
+		{name: "other port of the same host", raw: "http://127.0.0.1:9090/abc", want: "http://127.0.0.1:9090/abc"},
+		{name: "blocked subdomain", raw: "https://login.EVIL.com/", wantErr: ErrRejectedURL, wantCode: CodeBlockedURL},
+		{name: "blocked by regexp", raw: "https://example.com/wp-login.php", wantErr: ErrRejectedURL, wantCode: CodeBlockedURL},
+		{name: "similar domain", raw: "https://notevil.com/", want: "https://notevil.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validator.Validate(tt.raw)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				var validationErr *ValidationError
+				require.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.wantCode, validationErr.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestURLValidator_SelfReference(t *testing.T) {
+	validator, err := NewURLValidator(config.Config{BaseURL: "https://Short.example", HTTPRedirectAddress: "10.0.0.5:8080"}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		raw  string
+		self bool
+	}{
+		{raw: "https://short.example/abc", self: true},
+		// http на хост с https BASE_URL уходит в перенаправление на HTTPS и возвращается к сервису
+		{raw: "http://short.example/abc", self: true},
+		{raw: "http://short.example.:80/abc", self: true},
+		{raw: "https://short.example:443/abc", self: true},
+		{raw: "http://short.example:8080/abc", self: true},
+		// Адрес сервера перенаправления
+		{raw: "http://10.0.0.5:8080/abc", self: true},
+		{raw: "http://10.0.0.5/abc", self: true},
+		{raw: "https://short.example:9090/abc", self: false},
+		{raw: "https://other.example/abc", self: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, err := validator.Validate(tt.raw)
+			var validationErr *ValidationError
+			if tt.self {
+				require.True(t, errors.As(err, &validationErr), err)
+				assert.Equal(t, CodeSelfReference, validationErr.Code)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Сервер перенаправления на всех интерфейсах - отвечает на хосте BASE_URL
+	validator, err = NewURLValidator(config.Config{BaseURL: "https://short.example", HTTPRedirectAddress: ":8081"}, nil)
+	require.NoError(t, err)
+	_, err = validator.Validate("http://short.example:8081/abc")
+	assert.True(t, errors.Is(err, ErrRejectedURL))
+	_, err = validator.Validate("http://0.0.0.0:8081/abc")
+	assert.NoError(t, err)
+
+	_, err = NewURLValidator(config.Config{BaseURL: "https://short.example", HTTPRedirectAddress: "8081"}, nil)
+	assert.Error(t, err)
+}
+
+func TestBlocklist_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\n"), 0644))
+	blocklist, err := NewBlocklist(path, logger.New())
+	require.NoError(t, err)
+	validator, err := NewURLValidator(config.Config{BaseURL: "http://127.0.0.1:8080"}, blocklist)
+	require.NoError(t, err)
+
+	_, err = validator.Validate("https://bad.org/")
+	require.NoError(t, err)
+
+	// Файл изменился - подхватываем новые правила
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\nbad.org\n"), 0644))
+	touch(t, path, 1)
+	reloaded, err := blocklist.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	_, err = validator.Validate("https://bad.org/")
+	assert.True(t, errors.Is(err, ErrRejectedURL))
+
+	// С ошибкой в файле остаются старые правила
+	require.NoError(t, os.WriteFile(path, []byte("regexp:(\n"), 0644))
+	touch(t, path, 2)
+	_, err = blocklist.reload()
+	assert.Error(t, err)
+	_, err = validator.Validate("https://bad.org/")
+	assert.True(t, errors.Is(err, ErrRejectedURL))
+
+	// Пока файл не исправят, каждая проверка снова сообщает об ошибке
+	reloaded, err = blocklist.reload()
+	assert.Error(t, err)
+	assert.False(t, reloaded)
+
+	// Файл не менялся - не перечитываем
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\n"), 0644))
+	touch(t, path, 3)
+	reloaded, err = blocklist.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	reloaded, err = blocklist.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+}
+
+// touch - сдвигает время модификации файла, чтобы изменение было видно даже на ФС с секундной точностью
+func touch(t *testing.T, path string, seconds int) {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	modTime := info.ModTime().Add(time.Duration(seconds) * time.Hour)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
@@ -2,6 +2,9 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/sirupsen/logrus"
@@ -12,9 +15,105 @@ import (
 type Config struct {
 	ServerAddress    string `env:"SERVER_ADDRESS" envDefault:"127.0.0.1:8080"`
 	BaseURL 		 string `env:"BASE_URL" envDefault:"http://127.0.0.1:8080"`
+	// ServerReadTimeout, ServerWriteTimeout, ServerIdleTimeout - таймауты http.Server.
+	// У потокового импорта и выгрузки чтение и запись считаются от последнего чтения и записи
+	ServerReadTimeout time.Duration `env:"SERVER_READ_TIMEOUT" envDefault:"10s"`
+	ServerWriteTimeout time.Duration `env:"SERVER_WRITE_TIMEOUT" envDefault:"30s"`
+	ServerIdleTimeout time.Duration `env:"SERVER_IDLE_TIMEOUT" envDefault:"2m"`
+	// ShutdownTimeout - сколько ждать завершения запросов в работе после SIGTERM
+	ShutdownTimeout  time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	// EnableHTTPS - отдавать сервис по HTTPS, BaseURL тогда тоже https
+	EnableHTTPS 	 bool 	`env:"ENABLE_HTTPS" envDefault:"false"`
+	// TLSCertFile, TLSKeyFile - сертификат и ключ в PEM, без них генерируем самоподписанный сертификат
+	TLSCertFile 	 string `env:"TLS_CERT_FILE"`
+	TLSKeyFile 		 string `env:"TLS_KEY_FILE"`
+	// HTTPRedirectAddress - адрес HTTP сервера, который перенаправляет на HTTPS, пустой - не запускаем
+	HTTPRedirectAddress string `env:"HTTP_REDIRECT_ADDRESS"`
+	// GRPCAddress - адрес gRPC API, пустой - gRPC сервер не запускаем
+	GRPCAddress 	 string `env:"GRPC_ADDRESS"`
+	// HSTSMaxAge - max-age заголовка Strict-Transport-Security в режиме HTTPS, 0 - заголовок не отдаем
+	HSTSMaxAge 		 time.Duration `env:"HSTS_MAX_AGE" envDefault:"0s"`
 	FileStoragePath  string `env:"FILE_STORAGE_PATH"`
+	// FileSyncPolicy - когда делать fsync журнала файловой БД: always, interval, never
+	FileSyncPolicy   string `env:"FILE_SYNC_POLICY" envDefault:"always"`
+	// FileSyncInterval - период fsync журнала для FILE_SYNC_POLICY=interval
+	FileSyncInterval time.Duration `env:"FILE_SYNC_INTERVAL" envDefault:"1s"`
+	// FileCompactInterval - как часто переписывать журнал файловой БД без удаленных записей, 0 - никогда
+	FileCompactInterval time.Duration `env:"FILE_COMPACT_INTERVAL" envDefault:"10m"`
 	DatabaseDSN      string `env:"DATABASE_DSN"`
 	URLLength 	 	 int 	`env:"URLLength" envDefault:"5"`
+	// ShortCodeStrategy - алгоритм генерации короткого URL: hash, counter, random, hashids, hmac
+	ShortCodeStrategy string `env:"SHORT_CODE_STRATEGY" envDefault:"hash"`
+	// ShortCodeSecret - секрет для hmac и соль для hashids
+	ShortCodeSecret  string `env:"SHORT_CODE_SECRET"`
+	// ExpiredSweepInterval - как часто удалять из БД ссылки с истекшим сроком жизни
+	ExpiredSweepInterval time.Duration `env:"EXPIRED_SWEEP_INTERVAL" envDefault:"1m"`
+	// GeoIPPath - CSV файл с диапазонами IP и кодами стран для аналитики переходов
+	GeoIPPath 		 string `env:"GEOIP_PATH"`
+	// ClickBufferSize - размер буфера переходов, ожидающих записи в БД
+	ClickBufferSize  int 	`env:"CLICK_BUFFER_SIZE" envDefault:"1024"`
+	// CookieSecrets - секреты подписи cookie сессии через запятую, первым подписываем, остальными только проверяем
+	CookieSecrets 	 []string `env:"COOKIE_SECRETS" envSeparator:","`
+	// CookieMaxAge - срок жизни сессии
+	CookieMaxAge 	 time.Duration `env:"COOKIE_MAX_AGE" envDefault:"720h"`
+	// CookieSecure - отдавать cookie сессии только по HTTPS
+	CookieSecure 	 bool 	`env:"COOKIE_SECURE" envDefault:"false"`
+	// ClickFlushInterval - как часто сбрасывать накопленные переходы в БД
+	ClickFlushInterval time.Duration `env:"CLICK_FLUSH_INTERVAL" envDefault:"5s"`
+	// JWTSecret - секрет для JWT с алгоритмом HS256
+	JWTSecret 		 string `env:"JWT_SECRET"`
+	// JWTKeyFile - публичные ключи для JWT с алгоритмами RS256 и ES256: PEM или JWKS
+	JWTKeyFile 		 string `env:"JWT_KEY_FILE"`
+	// JWTIssuer, JWTAudience - ожидаемые iss и aud токена, пустое значение не проверяется
+	JWTIssuer 		 string `env:"JWT_ISSUER"`
+	JWTAudience 	 string `env:"JWT_AUDIENCE"`
+	// JWTUserClaim - claim с ID пользователя, владельца ссылок
+	JWTUserClaim 	 string `env:"JWT_USER_CLAIM" envDefault:"sub"`
+	// JWTNameClaim - claim с отображаемым именем пользователя
+	JWTNameClaim 	 string `env:"JWT_NAME_CLAIM" envDefault:"name"`
+	// JWTClockSkew - допустимое расхождение часов с выпустившим токен сервером при проверке exp и nbf
+	JWTClockSkew 	 time.Duration `env:"JWT_CLOCK_SKEW" envDefault:"1m"`
+	// URLMaxLength - максимальная длина оригинального URL
+	URLMaxLength 	 int 	`env:"URL_MAX_LENGTH" envDefault:"2048"`
+	// URLAllowedSchemes - схемы оригинальных URL, которые принимаем на сокращение
+	URLAllowedSchemes []string `env:"URL_ALLOWED_SCHEMES" envSeparator:"," envDefault:"http,https"`
+	// URLSortQuery - сортировать параметры запроса оригинального URL по имени, чтобы их порядок не влиял на код и дедупликацию
+	URLSortQuery 	 bool 	`env:"URL_SORT_QUERY" envDefault:"true"`
+	// URLStripParams - трекинговые параметры, которые убираем из оригинального URL, * в конце - по префиксу
+	URLStripParams 	 []string `env:"URL_STRIP_PARAMS" envSeparator:"," envDefault:"utm_*,fbclid,gclid"`
+	// URLTrailingSlash - слэш в конце пути оригинального URL: keep, strip или add
+	URLTrailingSlash string `env:"URL_TRAILING_SLASH" envDefault:"keep"`
+	// URLBlocklistPath - файл с запрещенными доменами и регулярными выражениями, см. service.Blocklist
+	URLBlocklistPath string `env:"URL_BLOCKLIST_PATH"`
+	// URLBlocklistReload - как часто проверять, не изменился ли файл блоклиста
+	URLBlocklistReload time.Duration `env:"URL_BLOCKLIST_RELOAD" envDefault:"30s"`
+	// AdminToken - bearer токен для /api/admin, без него admin API выключен
+	AdminToken 		 string `env:"ADMIN_TOKEN"`
+	// RateLimitCreate - сколько ссылок клиент может создать в минуту, 0 - без ограничения
+	RateLimitCreate  int 	`env:"RATE_LIMIT_CREATE" envDefault:"60"`
+	// RateLimitCreateBurst - сколько запросов на создание можно сделать подряд
+	RateLimitCreateBurst int `env:"RATE_LIMIT_CREATE_BURST" envDefault:"20"`
+	// RateLimitRedirect - сколько переходов по коротким ссылкам клиент может сделать в минуту, 0 - без ограничения
+	RateLimitRedirect int 	`env:"RATE_LIMIT_REDIRECT" envDefault:"1200"`
+	// RateLimitRedirectBurst - сколько переходов можно сделать подряд
+	RateLimitRedirectBurst int `env:"RATE_LIMIT_REDIRECT_BURST" envDefault:"200"`
+	// DeleteQueueSize - сколько заданий на удаление может ждать обработки, при переполнении - 503
+	DeleteQueueSize  int 	`env:"DELETE_QUEUE_SIZE" envDefault:"1000"`
+	// DeleteBatchSize - сколько ссылок разных пользователей удаляем одним запросом к БД
+	DeleteBatchSize  int 	`env:"DELETE_BATCH_SIZE" envDefault:"500"`
+	// DeleteFlushInterval - как долго копим неполную пачку удаления
+	DeleteFlushInterval time.Duration `env:"DELETE_FLUSH_INTERVAL" envDefault:"1s"`
+	// DeleteWorkers - сколько пачек удаления обрабатываем параллельно
+	DeleteWorkers 	 int 	`env:"DELETE_WORKERS" envDefault:"2"`
+	// DeleteRetryInterval - пауза перед повтором неудачного удаления, дальше растет вдвое до минуты
+	DeleteRetryInterval time.Duration `env:"DELETE_RETRY_INTERVAL" envDefault:"1s"`
+	// BatchMaxSize - сколько ссылок можно сократить одним запросом /api/shorten/batch, 0 - без ограничения
+	BatchMaxSize 	 int 	`env:"BATCH_MAX_SIZE" envDefault:"1000"`
+	// IdempotencyKeyTTL - сколько хранить ответ на запрос с заголовком Idempotency-Key
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+	// DedupScope - повторное сокращение того же URL вернет существующую ссылку: user - ссылку пользователя,
+	//				global - ссылку любого пользователя
+	DedupScope 		 string `env:"DEDUP_SCOPE" envDefault:"user"`
 }
 
 func NewConfig(logger *logrus.Logger) (Config, error) {
@@ -23,11 +122,41 @@ func NewConfig(logger *logrus.Logger) (Config, error) {
 	flag.StringVar(&cfg.BaseURL, "b", cfg.BaseURL, "set base URL, by example: http://127.0.0.1:8080")
 	flag.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "set file path for storage, by example: db.txt")
 	flag.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "set database string for Postgres, by example: 'host=localhost port=5432 user=example password=123 dbname=example sslmode=disable connect_timeout=5'")
+	flag.StringVar(&cfg.ShortCodeStrategy, "g", cfg.ShortCodeStrategy, "set short code strategy: hash, counter, random, hashids, hmac")
+	flag.BoolVar(&cfg.EnableHTTPS, "s", cfg.EnableHTTPS, "enable HTTPS, by example: -s -b https://127.0.0.1:8080")
 
 	if err := env.Parse(&cfg); err != nil {
 		return cfg, err
 	}
 	flag.Parse()
+	if err := cfg.validate(logger); err != nil {
+		return cfg, err
+	}
 	logger.Info("the config success init")
 	return cfg, nil
 }
+
+// validate - схема BaseURL должна соответствовать режиму сервера.
+//			  https при HTTP сервере допустим: TLS может завершаться на балансировщике перед нами
+func (cfg *Config) validate(logger *logrus.Logger) error {
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return fmt.Errorf("base url: %w", err)
+	}
+	switch {
+	case cfg.EnableHTTPS && baseURL.Scheme != "https":
+		return fmt.Errorf("base url %q must be https when HTTPS is enabled, set it with -b or BASE_URL", cfg.BaseURL)
+	case !cfg.EnableHTTPS && baseURL.Scheme == "https":
+		logger.Warn("the base url is https, but the server speaks plain HTTP: TLS must be terminated in front of it")
+	case !cfg.EnableHTTPS && baseURL.Scheme != "http":
+		return fmt.Errorf("base url %q must be http or https", cfg.BaseURL)
+	}
+	if (len(cfg.TLSCertFile) == 0) != (len(cfg.TLSKeyFile) == 0) {
+		return fmt.Errorf("both TLS_CERT_FILE and TLS_KEY_FILE must be set")
+	}
+	// По HTTPS cookie сессии не должна уходить по открытому каналу
+	if cfg.EnableHTTPS {
+		cfg.CookieSecure = true
+	}
+	return nil
+}